@@ -1,6 +1,7 @@
 package archives
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -27,8 +28,20 @@ type SevenZip struct {
 	// operation will continue on remaining files.
 	ContinueOnError bool
 
+	// Limits on the resources that extraction may use, to guard
+	// against malicious archives. By default, there are no limits.
+	Limits Limits
+
+	// How to normalize the names of files as they are read from
+	// the archive. By default, names are left exactly as they are.
+	Normalizer NameNormalizer
+
 	// The password, if dealing with an encrypted archive.
 	Password string
+
+	// If set, called for more passwords to try when Password, or the
+	// last password that worked, is wrong. See PasswordFunc.
+	PasswordFunc PasswordFunc
 }
 
 func (SevenZip) Extension() string { return ".7z" }
@@ -71,10 +84,23 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 		return fmt.Errorf("determining stream size: %w", err)
 	}
 
-	zr, err := sevenzip.NewReaderWithPassword(sra, size, z.Password)
+	// the headers may be encrypted, in which case the password must be
+	// known to list the files; otherwise, one is only needed to read them
+	passwords := newPasswords(z.Password, z.PasswordFunc, archiveName(sourceArchive))
+	readers := &sevenZipReaders{sra: sra, size: size, byPassword: make(map[string]*sevenzip.Reader)}
+	var zr *sevenzip.Reader
+	err = passwords.try(ctx, "", func(password string) error {
+		var err error
+		zr, err = readers.get(password)
+		if isSevenZipEncryptionError(err) {
+			return fmt.Errorf("%w: %w", ErrEncryptedHeaders, passwordError(password, err))
+		}
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	lim := newLimiter(z.Limits)
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -84,7 +110,8 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 			return err // honor context cancellation
 		}
 
-		if fileIsIncluded(skipDirs, f.Name) {
+		name := z.Normalizer.Normalize(f.Name)
+		if fileIsIncluded(skipDirs, name) {
 			continue
 		}
 
@@ -92,21 +119,33 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 		file := FileInfo{
 			FileInfo:      fi,
 			Header:        f.FileHeader,
-			NameInArchive: f.Name,
+			NameInArchive: name,
 			Open: func() (fs.File, error) {
-				openedFile, err := f.Open()
+				var openedFile io.ReadCloser
+				err := passwords.try(ctx, f.Name, func(password string) error {
+					var err error
+					openedFile, err = readers.open(i, password)
+					return err
+				})
 				if err != nil {
 					return nil, err
 				}
 				return fileInArchive{openedFile, fi}, nil
 			},
 		}
+		z.Normalizer.normalizeFile(&file)
+		if err := lim.check(&file); err != nil {
+			return err
+		}
 
 		err := handleFile(ctx, file)
+		if lim.err != nil {
+			return lim.err
+		}
 		if errors.Is(err, fs.SkipAll) {
 			break
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
-			skipDirs.add(f.Name)
+			skipDirs.add(name)
 		} else if err != nil {
 			if z.ContinueOnError {
 				log.Printf("[ERROR] %s: %v", f.Name, err)
@@ -119,6 +158,77 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 	return nil
 }
 
+// sevenZipReaders are the readers of one archive, by password, since the
+// password of a 7z archive can only be given when the reader is created.
+type sevenZipReaders struct {
+	sra        seekReaderAt
+	size       int64
+	byPassword map[string]*sevenzip.Reader
+}
+
+func (rs *sevenZipReaders) get(password string) (*sevenzip.Reader, error) {
+	if zr, ok := rs.byPassword[password]; ok {
+		return zr, nil
+	}
+	zr, err := sevenzip.NewReaderWithPassword(rs.sra, rs.size, password)
+	if err != nil {
+		return nil, err
+	}
+	rs.byPassword[password] = zr
+	return zr, nil
+}
+
+// open opens the i'th file of the archive with password. Since 7z
+// archives have no way to check a password other than decrypting data
+// with it, the start of the file is read right away; a wrong password
+// is likely, but not certain, to be detected then.
+func (rs *sevenZipReaders) open(i int, password string) (io.ReadCloser, error) {
+	zr, err := rs.get(password)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := zr.File[i].Open()
+	if err != nil {
+		return nil, sevenZipPasswordError(password, err)
+	}
+	br := bufio.NewReader(rc)
+	if _, err := br.Peek(1); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, sevenZipPasswordError(password, err)
+	}
+	return sevenZipFile{br, rc, password}, nil
+}
+
+// sevenZipFile is a file in a 7z archive, whose read errors are
+// reported as password errors if the file is encrypted.
+type sevenZipFile struct {
+	r        io.Reader
+	rc       io.ReadCloser
+	password string
+}
+
+func (f sevenZipFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	return n, sevenZipPasswordError(f.password, err)
+}
+
+func (f sevenZipFile) Close() error { return f.rc.Close() }
+
+// sevenZipPasswordError returns err, or if err is an error that the
+// sevenzip package reports as having to do with encryption, a password
+// error wrapping it.
+func sevenZipPasswordError(password string, err error) error {
+	if isSevenZipEncryptionError(err) {
+		return passwordError(password, err)
+	}
+	return err
+}
+
+func isSevenZipEncryptionError(err error) bool {
+	var readErr *sevenzip.ReadError
+	return errors.As(err, &readErr) && readErr.Encrypted
+}
+
 // https://py7zr.readthedocs.io/en/latest/archive_format.html#signature
 var sevenZipHeader = []byte("7z\xBC\xAF\x27\x1C")
 
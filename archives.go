@@ -41,6 +41,20 @@ type FileInfo struct {
 	// Not supported by all archive formats.
 	LinkTarget string
 
+	// When extracting, a non-nil error wrapping ErrMalformedEntry
+	// if the entry was found to be malformed in a way that did not
+	// prevent it from being read, such as a zip entry whose data
+	// overlaps that of another entry. See Zip.Strict.
+	Malformed error
+
+	// When extracting, true if the contents of the file are
+	// encrypted, for formats that record it per file.
+	Encrypted bool
+
+	// The comment of the file, for formats that have them, like zip.
+	// It is set when extracting, and written when archiving.
+	Comment string
+
 	// A callback function that opens the file to read its
 	// contents. The file must be closed when reading is
 	// complete.
@@ -66,7 +80,9 @@ func (f FileInfo) Stat() (fs.FileInfo, error) { return f.FileInfo, nil }
 // (sans path) in the root of the archive; and map values that end in a slash
 // will use the base name of the file in that folder of the archive.
 //
-// File gathering will adhere to the settings specified in options.
+// File gathering will adhere to the settings specified in options. If options
+// has a NameMapper, it is applied to the names in the archive derived from
+// the map; files for which it returns an empty name are left out.
 //
 // This function is used primarily when preparing a list of files to add to
 // an archive.
@@ -99,14 +115,20 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 				return nil
 			}
 
+			var nameMapper NameMapper
+			if options != nil {
+				nameMapper = options.NameMapper
+			}
+			mappedName := mapName(nameMapper, nameInArchive)
+
 			// handle hardlinks for regular files
 			var linkTarget string
-			if info.Mode().IsRegular() {
+			if info.Mode().IsRegular() && mappedName != "" {
 				if key, ok := hardlinkKey(info); ok {
 					if firstPath, exists := inodeMap[key]; exists {
 						linkTarget = firstPath
 					} else {
-						inodeMap[key] = nameInArchive
+						inodeMap[key] = mappedName
 					}
 				}
 			}
@@ -120,6 +142,8 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 						return err
 					}
 					if info.IsDir() {
+						// the name mapper is applied to the names within the
+						// directory, so give it the unmapped name to start with
 						symlinkDirFiles, err := FilesFromDisk(ctx, options, map[string]string{filename: nameInArchive})
 						if err != nil {
 							return fmt.Errorf("getting files from symlink directory %s dereferenced to %s: %w", originalFilename, linkTarget, err)
@@ -137,6 +161,10 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 				}
 			}
 
+			if mappedName == "" {
+				return nil // dropped by name mapper
+			}
+
 			// handle file attributes
 			if options != nil && options.ClearAttributes {
 				info = noAttrFileInfo{info}
@@ -144,7 +172,7 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 
 			file := FileInfo{
 				FileInfo:      info,
-				NameInArchive: nameInArchive,
+				NameInArchive: mappedName,
 				LinkTarget:    linkTarget,
 				Open: func() (fs.File, error) {
 					return os.Open(filename)
@@ -178,7 +206,9 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 // (sans path) in the root of the archive; and map values that end in a slash
 // will use the base name of the file in that folder of the archive.
 //
-// File gathering will adhere to the settings specified in options.
+// File gathering will adhere to the settings specified in options. If options
+// has a NameMapper, it is applied to the names in the archive derived from
+// the map; files for which it returns an empty name are left out.
 //
 // This function is used primarily when preparing a list of files to add to
 // an archive.
@@ -213,6 +243,12 @@ func FilesFromFS(ctx context.Context, fsys fs.FS, options *FromFSOptions, filena
 				return nil
 			}
 
+			var nameMapper NameMapper
+			if options != nil {
+				nameMapper = options.NameMapper
+			}
+			mappedName := mapName(nameMapper, nameInArchive)
+
 			// handle symbolic links
 			var linkTarget string
 			if isSymlink(info) {
@@ -244,6 +280,10 @@ func FilesFromFS(ctx context.Context, fsys fs.FS, options *FromFSOptions, filena
 				}
 			}
 
+			if mappedName == "" {
+				return nil // dropped by name mapper
+			}
+
 			// handle file attributes
 			if options != nil && options.ClearAttributes {
 				info = noAttrFileInfo{info}
@@ -251,7 +291,7 @@ func FilesFromFS(ctx context.Context, fsys fs.FS, options *FromFSOptions, filena
 
 			file := FileInfo{
 				FileInfo:      info,
-				NameInArchive: nameInArchive,
+				NameInArchive: mappedName,
 				LinkTarget:    linkTarget,
 				Open: func() (fs.File, error) {
 					return fsys.Open(filename)
@@ -348,6 +388,10 @@ type FromDiskOptions struct {
 	// If true, some file attributes will not be preserved.
 	// Name, size, type, and permissions will still be preserved.
 	ClearAttributes bool
+
+	// If set, rewrites the name in the archive of each file,
+	// or leaves the file out if it returns an empty name.
+	NameMapper NameMapper
 }
 
 // FromFSOptions specifies various options for gathering files from a [fs.FS].
@@ -360,6 +404,10 @@ type FromFSOptions struct {
 	// If true, some file attributes will not be preserved.
 	// Name, size, type, and permissions will still be preserved.
 	ClearAttributes bool
+
+	// If set, rewrites the name in the archive of each file,
+	// or leaves the file out if it returns an empty name.
+	NameMapper NameMapper
 }
 
 // FileHandler is a callback function that is used to handle files as they are read
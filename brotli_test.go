@@ -110,14 +110,6 @@ func TestBrotli_Fuzzy_Both(t *testing.T) {
 	}
 }
 
-// min returns the minimum of two integers
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 // deterministicRNG provides deterministic random numbers for testing
 type deterministicRNG struct {
 	seed int64
@@ -0,0 +1,243 @@
+package archives
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// checkpoint is the progress of an extraction, as recorded in the
+// file named by ExtractOptions.Checkpoint.
+type checkpoint struct {
+	// number of entries of the archive that have been handled
+	Entries int `json:"entries"`
+
+	// the last entry that was handled: its name in the archive,
+	// and the path, size, and CRC-32 of the file written for it
+	// (if it was a regular file)
+	Name  string `json:"name"`
+	Path  string `json:"path,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+	CRC32 uint32 `json:"crc32,omitempty"`
+
+	// offset of the headers of the last entry, relative to where
+	// the archive began to be read; only set for plain tar
+	// archives, and zero if unknown
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// checkpointer loads and saves checkpoints during an extraction.
+type checkpointer struct {
+	filename string
+	fsync    bool
+
+	saved checkpoint // from the previous run, if any
+	index int        // index of the entry being handled
+	skip  int        // number of entries to skip without writing
+
+	// counts bytes read from a seekable plain tar archive,
+	// or nil if the archive is not one
+	counter *countingReader
+
+	// offset of the end of the previous entry in the
+	// plain tar archive, or zero if unknown
+	prevEnd int64
+}
+
+// newCheckpointer loads the checkpoint from filename, if it exists.
+func newCheckpointer(filename string, fsync bool) (*checkpointer, error) {
+	cp := &checkpointer{filename: filename, fsync: fsync}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp.saved); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %w", filename, err)
+	}
+	return cp, nil
+}
+
+// resume checks that the file written for the last entry recorded in the
+// checkpoint is still intact in fsys, and prepares to skip the entries
+// that were already handled. If format is a plain tar archive and the
+// archive can seek, it seeks to the last entry that was handled, so that
+// its name can still be checked against the checkpoint. It returns the
+// reader to extract from.
+func (cp *checkpointer) resume(format Extractor, archive io.Reader, fsys WritableFS) (io.Reader, error) {
+	if cp.saved.Path != "" {
+		if err := cp.checkFile(fsys); err != nil {
+			return nil, err
+		}
+	}
+
+	cp.skip = cp.saved.Entries
+
+	seeker, ok := archive.(io.Seeker)
+	if !ok || !isPlainTar(format) {
+		return archive, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return archive, nil // can't seek after all
+	}
+	if cp.saved.Offset > 0 {
+		if _, err := seeker.Seek(start+cp.saved.Offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking to checkpoint: %w", err)
+		}
+		cp.index = cp.saved.Entries - 1
+	}
+	cp.counter = &countingReader{r: archive, n: cp.saved.Offset}
+	return cp.counter, nil
+}
+
+// checkFile returns an error wrapping ErrCheckpointMismatch if the file
+// written for the last entry recorded in the checkpoint is missing from
+// fsys, or has a different size. If fsys can also be read (that is, it
+// is an fs.FS), the CRC-32 of the file's contents is checked too.
+func (cp *checkpointer) checkFile(fsys WritableFS) error {
+	info, err := fsys.Lstat(cp.saved.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", cp.saved.Path, ErrCheckpointMismatch, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if info.Size() != cp.saved.Size {
+		return fmt.Errorf("%s: expected size %d, found %d: %w", cp.saved.Path, cp.saved.Size, info.Size(), ErrCheckpointMismatch)
+	}
+
+	readable, ok := fsys.(fs.FS)
+	if !ok {
+		return nil
+	}
+	f, err := readable.Open(cp.saved.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", cp.saved.Path, ErrCheckpointMismatch, err)
+	}
+	defer f.Close()
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, f); err != nil {
+		return fmt.Errorf("%s: %w: %w", cp.saved.Path, ErrCheckpointMismatch, err)
+	}
+	if crc.Sum32() != cp.saved.CRC32 {
+		return fmt.Errorf("%s: expected CRC-32 %08x, found %08x: %w", cp.saved.Path, cp.saved.CRC32, crc.Sum32(), ErrCheckpointMismatch)
+	}
+	return nil
+}
+
+// next returns the index of the entry about to be handled and whether it
+// should be skipped because it was already handled in a previous run. It
+// returns an error if the archive does not match the checkpoint.
+func (cp *checkpointer) next(file FileInfo) (index int, skip bool, err error) {
+	index = cp.index
+	cp.index++
+	if index >= cp.skip {
+		return index, false, nil
+	}
+	if index == cp.skip-1 && file.NameInArchive != cp.saved.Name {
+		return index, true, fmt.Errorf("expected entry %d to be %s, found %s: %w",
+			index, cp.saved.Name, file.NameInArchive, ErrCheckpointMismatch)
+	}
+	return index, true, nil
+}
+
+// headerStart returns the offset, relative to where reading of the
+// archive began, of the headers of the current entry in a plain tar
+// archive, or 0 if it is not known. It must be called for every entry,
+// before the entry's contents are read.
+func (cp *checkpointer) headerStart(file FileInfo) int64 {
+	if cp.counter == nil {
+		return 0
+	}
+	start := cp.prevEnd
+	hdr, ok := file.Header.(*tar.Header)
+	if !ok || isSparseTarEntry(file) {
+		cp.prevEnd = 0 // the size of the data in the archive is not the size of the file
+	} else {
+		cp.prevEnd = cp.counter.n + hdr.Size + tarBlockPadding(hdr.Size)
+	}
+	return start
+}
+
+// save records that the entry at index has been handled.
+func (cp *checkpointer) save(index int, file FileInfo, fe *fsExtractor, offset int64) error {
+	data, err := json.Marshal(checkpoint{
+		Entries: index + 1,
+		Name:    file.NameInArchive,
+		Path:    fe.lastPath,
+		Size:    fe.lastSize,
+		CRC32:   fe.lastCRC,
+		Offset:  offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := cp.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil && cp.fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, cp.filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint file once extraction has completed.
+func (cp *checkpointer) remove() error {
+	if err := os.Remove(cp.filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// isPlainTar returns true if format extracts uncompressed tar archives.
+func isPlainTar(format Extractor) bool {
+	switch f := format.(type) {
+	case Tar, *Tar:
+		return true
+	case CompressedArchive:
+		return f.Compression == nil && isPlainTar(f.Extraction)
+	case *CompressedArchive:
+		return f.Compression == nil && isPlainTar(f.Extraction)
+	}
+	return false
+}
+
+// countingReader counts the bytes read from r. It deliberately
+// does not implement io.Seeker, so that readers like tar.Reader
+// read through it rather than seeking the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ErrCheckpointMismatch is returned (wrapped) when a checkpoint does not
+// match the archive or destination of the extraction it is used with.
+var ErrCheckpointMismatch = errors.New("checkpoint does not match extraction")
@@ -0,0 +1,224 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelFS cancels a context when a file whose name
+// contains the given string starts to be created.
+type cancelFS struct {
+	*MemFS
+	cancelAt string
+	cancel   context.CancelFunc
+}
+
+func (c cancelFS) Create(name string) (io.WriteCloser, error) {
+	if strings.Contains(name, c.cancelAt) {
+		c.cancel()
+	}
+	return c.MemFS.Create(name)
+}
+
+// failFS fails to create files whose names contain the given string.
+type failFS struct {
+	*MemFS
+	failAt string
+}
+
+func (f failFS) Create(name string) (io.WriteCloser, error) {
+	if strings.Contains(name, f.failAt) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MemFS.Create(name)
+}
+
+// seekRecorder records the offsets that are sought to.
+type seekRecorder struct {
+	io.ReadSeeker
+	seeks []int64
+}
+
+func (s *seekRecorder) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.ReadSeeker.Seek(offset, whence)
+	if whence != io.SeekCurrent {
+		s.seeks = append(s.seeks, pos)
+	}
+	return pos, err
+}
+
+func TestExtractCheckpoint(t *testing.T) {
+	tarball := makeTestTar(t, []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a", body: strings.Repeat("a", 1000)},
+		{name: "dir/b", body: "bbb"},
+		{name: "dir/c", body: "ccc"},
+		{name: "dir/d", body: "ddd"},
+	})
+	tarBytes, err := io.ReadAll(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzBuf bytes.Buffer
+	zw := gzip.NewWriter(&gzBuf)
+	zw.Write(tarBytes)
+	zw.Close()
+
+	for _, tc := range []struct {
+		name       string
+		format     Extractor
+		data       []byte
+		expectSeek bool
+	}{
+		{name: "plain tar", format: Tar{}, data: tarBytes, expectSeek: true},
+		{name: "compressed tar", format: CompressedArchive{Compression: Gz{}, Extraction: Tar{}}, data: gzBuf.Bytes()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := new(MemFS)
+			options := &ExtractOptions{Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")}
+
+			// first run is interrupted while writing dir/c
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := ExtractTo(ctx, tc.format, bytes.NewReader(tc.data), cancelFS{fsys, ".c.tmp", cancel}, options)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected extraction to be cancelled, got %v", err)
+			}
+			if _, err := os.Stat(options.Checkpoint); err != nil {
+				t.Fatalf("expected checkpoint file: %v", err)
+			}
+			if _, err := fsys.Stat("dir/c"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("dir/c should not have been written, got %v", err)
+			}
+
+			// files before the checkpoint must not be written again
+			if err := fsys.Remove("dir/a"); err != nil {
+				t.Fatal(err)
+			}
+
+			archive := &seekRecorder{ReadSeeker: bytes.NewReader(tc.data)}
+			if err := ExtractTo(context.Background(), tc.format, archive, fsys, options); err != nil {
+				t.Fatalf("resuming extraction: %v", err)
+			}
+			if _, err := fsys.Stat("dir/a"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("dir/a should have been skipped, got %v", err)
+			}
+			for _, name := range []string{"dir/b", "dir/c", "dir/d"} {
+				if got, err := fs.ReadFile(fsys, name); err != nil || len(got) != 3 {
+					t.Errorf("%s: got %q (err=%v)", name, got, err)
+				}
+			}
+			if sought := len(archive.seeks) > 0; sought != tc.expectSeek {
+				t.Errorf("expected seek=%v, got seeks %v", tc.expectSeek, archive.seeks)
+			}
+			// headers of dir/ and dir/a, plus the padded contents of dir/a
+			const offsetOfB = 2*512 + 1024
+			if tc.expectSeek && archive.seeks[0] != offsetOfB {
+				t.Errorf("expected to seek to the header of dir/b at %d, got %d", offsetOfB, archive.seeks[0])
+			}
+			if _, err := os.Stat(options.Checkpoint); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("checkpoint should be removed after success, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractCheckpointContinueOnError(t *testing.T) {
+	entries := []testTarEntry{
+		{name: "a", body: "aaa"},
+		{name: "b", body: "bbb"},
+		{name: "c", body: "ccc"},
+	}
+	fsys := new(MemFS)
+	options := &ExtractOptions{
+		Checkpoint:      filepath.Join(t.TempDir(), "checkpoint.json"),
+		ContinueOnError: true,
+	}
+
+	// b fails, but the extraction goes on to c
+	if err := ExtractTo(context.Background(), Tar{}, makeTestTar(t, entries), failFS{fsys, ".b.tmp"}, options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("c"); err != nil {
+		t.Fatalf("expected c to be extracted: %v", err)
+	}
+	if _, err := os.Stat(options.Checkpoint); err != nil {
+		t.Fatalf("expected checkpoint file to be kept after a failed entry: %v", err)
+	}
+
+	if err := ExtractTo(context.Background(), Tar{}, makeTestTar(t, entries), fsys, options); err != nil {
+		t.Fatalf("resuming extraction: %v", err)
+	}
+	if got, err := fs.ReadFile(fsys, "b"); err != nil || string(got) != "bbb" {
+		t.Errorf("expected b to be extracted on resuming, got %q (err=%v)", got, err)
+	}
+	if _, err := os.Stat(options.Checkpoint); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("checkpoint should be removed after success, got %v", err)
+	}
+}
+
+func TestExtractCheckpointMismatch(t *testing.T) {
+	entries := []testTarEntry{
+		{name: "a", body: "aaa"},
+		{name: "b", body: "bbb"},
+		{name: "c", body: "ccc"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(t *testing.T, fsys *MemFS) []testTarEntry
+	}{
+		{name: "size changed", change: func(t *testing.T, fsys *MemFS) []testTarEntry {
+			rewrite(t, fsys, "b", "truncated?")
+			return entries
+		}},
+		{name: "contents changed", change: func(t *testing.T, fsys *MemFS) []testTarEntry {
+			rewrite(t, fsys, "b", "xxx")
+			return entries
+		}},
+		{name: "different archive", change: func(t *testing.T, fsys *MemFS) []testTarEntry {
+			// the same layout, so seeking to the checkpoint lands on a header
+			return []testTarEntry{entries[0], {name: "x", body: "bbb"}, entries[2]}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := new(MemFS)
+			options := &ExtractOptions{Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := ExtractTo(ctx, Tar{}, makeTestTar(t, entries), cancelFS{fsys, ".c.tmp", cancel}, options)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected extraction to be cancelled, got %v", err)
+			}
+
+			err = ExtractTo(context.Background(), Tar{}, makeTestTar(t, tc.change(t, fsys)), fsys, options)
+			if !errors.Is(err, ErrCheckpointMismatch) {
+				t.Errorf("expected ErrCheckpointMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+// rewrite replaces the contents of the file name in fsys.
+func rewrite(t *testing.T, fsys *MemFS, name, contents string) {
+	t.Helper()
+	if err := fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	w, err := fsys.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(contents))
+	w.Close()
+}
@@ -0,0 +1,454 @@
+package archives
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Deflate64 ("enhanced deflate") is a variant of Deflate made by PKWARE
+// that Windows uses for large zip files. It differs only in that the
+// window is 64 KiB instead of 32 KiB, distance codes 30 and 31 are used
+// for distances up to 64 KiB, and length code 285 has 16 extra bits
+// instead of meaning exactly 258. It is not documented in APPNOTE.TXT
+// but is otherwise the same as RFC 1951, which describes the details.
+//
+// There is no encoder, because nothing should write Deflate64 today.
+
+const deflate64WindowSize = 1 << 16
+
+// deflate64Reader decompresses a Deflate64 stream.
+type deflate64Reader struct {
+	r     *bufio.Reader
+	bits  uint64 // bit buffer; next bit is lowest
+	nbits uint
+	eof   bool // the underlying reader is exhausted
+
+	window  []byte // the last deflate64WindowSize bytes of output
+	written int64  // total bytes of output
+
+	state   deflate64State
+	final   bool // current block is the last one
+	stored  int  // bytes remaining in stored block
+	litLen  deflate64Huffman
+	dist    deflate64Huffman
+	copyLen int // bytes remaining to copy for a match
+	copyDst int // distance of the match
+	err     error
+}
+
+type deflate64State int
+
+const (
+	deflate64BlockHeader deflate64State = iota
+	deflate64Stored
+	deflate64Compressed
+	deflate64Copy
+	deflate64Done
+)
+
+func newDeflate64Reader(r io.Reader) io.ReadCloser {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &deflate64Reader{r: br, window: make([]byte, deflate64WindowSize)}
+}
+
+func (d *deflate64Reader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) && d.err == nil {
+		switch d.state {
+		case deflate64BlockHeader:
+			d.err = d.readBlockHeader()
+		case deflate64Stored:
+			n += d.readStored(p[n:])
+		case deflate64Compressed:
+			n += d.decode(p[n:])
+		case deflate64Copy:
+			n += d.copyMatch(p[n:])
+		case deflate64Done:
+			d.err = io.EOF
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+func (d *deflate64Reader) Close() error {
+	if d.err == nil || d.err == io.EOF {
+		d.err = errors.New("deflate64: reader is closed")
+	}
+	return nil
+}
+
+// emit writes b to the output and the window.
+func (d *deflate64Reader) emit(p []byte, n int, b byte) {
+	p[n] = b
+	d.window[d.written&(deflate64WindowSize-1)] = b
+	d.written++
+}
+
+func (d *deflate64Reader) readBlockHeader() error {
+	if d.final {
+		d.state = deflate64Done
+		return nil
+	}
+	header, err := d.readBits(3)
+	if err != nil {
+		return err
+	}
+	d.final = header&1 == 1
+	switch header >> 1 {
+	case 0:
+		// stored blocks start at a byte boundary
+		d.bits >>= d.nbits % 8
+		d.nbits -= d.nbits % 8
+		lengths, err := d.readBits(32)
+		if err != nil {
+			return err
+		}
+		length, nlength := lengths&0xffff, lengths>>16
+		if length != ^nlength&0xffff {
+			return errDeflate64Corrupt
+		}
+		d.stored = int(length)
+		d.state = deflate64Stored
+	case 1:
+		if err := d.fixedTables(); err != nil {
+			return err
+		}
+		d.state = deflate64Compressed
+	case 2:
+		if err := d.dynamicTables(); err != nil {
+			return err
+		}
+		d.state = deflate64Compressed
+	default:
+		return errDeflate64Corrupt
+	}
+	return nil
+}
+
+func (d *deflate64Reader) readStored(p []byte) int {
+	var n int
+	for n < len(p) && d.stored > 0 {
+		var b byte
+		if d.nbits >= 8 {
+			b = byte(d.bits)
+			d.bits >>= 8
+			d.nbits -= 8
+		} else {
+			var err error
+			if b, err = d.r.ReadByte(); err != nil {
+				d.err = noEOF(err)
+				return n
+			}
+		}
+		d.emit(p, n, b)
+		n++
+		d.stored--
+	}
+	if d.stored == 0 {
+		d.state = deflate64BlockHeader
+	}
+	return n
+}
+
+func (d *deflate64Reader) decode(p []byte) int {
+	var n int
+	for n < len(p) {
+		sym, err := d.litLen.decode(d)
+		if err != nil {
+			d.err = err
+			return n
+		}
+		switch {
+		case sym < 256:
+			d.emit(p, n, byte(sym))
+			n++
+		case sym == 256:
+			d.state = deflate64BlockHeader
+			return n
+		default:
+			sym -= 257
+			if sym >= len(deflate64LengthBase) {
+				d.err = errDeflate64Corrupt
+				return n
+			}
+			extra, err := d.readBits(uint(deflate64LengthExtra[sym]))
+			if err != nil {
+				d.err = err
+				return n
+			}
+			d.copyLen = int(deflate64LengthBase[sym]) + int(extra)
+
+			sym, err = d.dist.decode(d)
+			if err != nil {
+				d.err = err
+				return n
+			}
+			if sym >= len(deflate64DistBase) {
+				d.err = errDeflate64Corrupt
+				return n
+			}
+			if extra, err = d.readBits(uint(deflate64DistExtra[sym])); err != nil {
+				d.err = err
+				return n
+			}
+			d.copyDst = int(deflate64DistBase[sym]) + int(extra)
+			if int64(d.copyDst) > d.written {
+				d.err = errDeflate64Corrupt
+				return n
+			}
+			d.state = deflate64Copy
+			return n + d.copyMatch(p[n:])
+		}
+	}
+	return n
+}
+
+func (d *deflate64Reader) copyMatch(p []byte) int {
+	var n int
+	for n < len(p) && d.copyLen > 0 {
+		d.emit(p, n, d.window[(d.written-int64(d.copyDst))&(deflate64WindowSize-1)])
+		n++
+		d.copyLen--
+	}
+	if d.copyLen == 0 {
+		d.state = deflate64Compressed
+	}
+	return n
+}
+
+func (d *deflate64Reader) fixedTables() error {
+	var lengths [288 + 32]uint8
+	for i := range 288 {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	for i := 288; i < len(lengths); i++ {
+		lengths[i] = 5
+	}
+	if err := d.litLen.init(lengths[:288]); err != nil {
+		return err
+	}
+	return d.dist.init(lengths[288:])
+}
+
+func (d *deflate64Reader) dynamicTables() error {
+	counts, err := d.readBits(14)
+	if err != nil {
+		return err
+	}
+	nlen := int(counts&0x1f) + 257
+	ndist := int(counts>>5&0x1f) + 1
+	ncode := int(counts>>10) + 4
+	if nlen > 286 {
+		return errDeflate64Corrupt
+	}
+
+	var codeLengths [19]uint8
+	for i := range ncode {
+		l, err := d.readBits(3)
+		if err != nil {
+			return err
+		}
+		codeLengths[deflate64CodeOrder[i]] = uint8(l)
+	}
+	var codes deflate64Huffman
+	if err := codes.init(codeLengths[:]); err != nil {
+		return err
+	}
+
+	// the literal/length and distance code lengths are one sequence,
+	// and repeats may cross from one to the other
+	lengths := make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := codes.decode(d)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var repeat uint64
+		var value uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errDeflate64Corrupt
+			}
+			value = lengths[i-1]
+			repeat, err = d.readBits(2)
+			repeat += 3
+		case 17:
+			repeat, err = d.readBits(3)
+			repeat += 3
+		default:
+			repeat, err = d.readBits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(repeat) > len(lengths) {
+			return errDeflate64Corrupt
+		}
+		for range repeat {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errDeflate64Corrupt // no end of block code
+	}
+
+	if err := d.litLen.init(lengths[:nlen]); err != nil {
+		return err
+	}
+	return d.dist.init(lengths[nlen:])
+}
+
+// fill tries to have at least n bits in the bit buffer; it
+// has fewer only if the end of the input has been reached.
+func (d *deflate64Reader) fill(n uint) error {
+	for d.nbits < n && !d.eof {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			d.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		d.bits |= uint64(b) << d.nbits
+		d.nbits += 8
+	}
+	return nil
+}
+
+func (d *deflate64Reader) readBits(n uint) (uint64, error) {
+	if err := d.fill(n); err != nil {
+		return 0, err
+	}
+	if d.nbits < n {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := d.bits & (1<<n - 1)
+	d.bits >>= n
+	d.nbits -= n
+	return v, nil
+}
+
+// deflate64Huffman is a canonical Huffman code, decoded with a table
+// indexed by the next maxLen bits of input.
+type deflate64Huffman struct {
+	table  []uint32 // symbol<<4 | code length, or 0 if no code
+	maxLen uint
+}
+
+func (h *deflate64Huffman) init(lengths []uint8) error {
+	var count [16]int
+	var maxLen uint8
+	for _, l := range lengths {
+		count[l]++
+		maxLen = max(maxLen, l)
+	}
+	count[0] = 0
+
+	// the code may be incomplete (as for a single distance code),
+	// but not over-subscribed
+	left := 1
+	var next [16]int
+	for l := 1; l < len(count); l++ {
+		left = left<<1 - count[l]
+		if left < 0 {
+			return errDeflate64Corrupt
+		}
+		next[l] = (next[l-1] + count[l-1]) << 1
+	}
+
+	h.maxLen = uint(maxLen)
+	size := 1 << maxLen
+	if cap(h.table) < size {
+		h.table = make([]uint32, size)
+	}
+	h.table = h.table[:size]
+	clear(h.table)
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		code := next[l]
+		next[l]++
+		// codes are packed starting with their most significant bit
+		for i := int(bits.Reverse16(uint16(code)) >> (16 - l)); i < size; i += 1 << l {
+			h.table[i] = uint32(sym)<<4 | uint32(l)
+		}
+	}
+	return nil
+}
+
+func (h *deflate64Huffman) decode(d *deflate64Reader) (int, error) {
+	if err := d.fill(h.maxLen); err != nil {
+		return 0, err
+	}
+	entry := h.table[d.bits&(1<<h.maxLen-1)]
+	l := uint(entry & 0xf)
+	if l == 0 {
+		if d.eof {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, errDeflate64Corrupt
+	}
+	if l > d.nbits {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.bits >>= l
+	d.nbits -= l
+	return int(entry >> 4), nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+var errDeflate64Corrupt = errors.New("deflate64: corrupt input")
+
+var (
+	deflate64CodeOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	// length code 285 is where Deflate64 differs from Deflate
+	deflate64LengthBase = [29]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3}
+	deflate64LengthExtra = [29]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16}
+
+	// as are distance codes 30 and 31
+	deflate64DistBase = [32]uint32{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145,
+		8193, 12289, 16385, 24577, 32769, 49153}
+	deflate64DistExtra = [32]uint8{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14}
+)
@@ -0,0 +1,159 @@
+package archives
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+)
+
+// bitWriter writes a Deflate bit stream, for making test streams
+// that use what only Deflate64 has.
+type bitWriter struct {
+	buf   bytes.Buffer
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	w.bits |= v << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes a Huffman code, which is packed most significant bit first.
+func (w *bitWriter) writeCode(code uint16, n uint) {
+	w.writeBits(uint64(bits.Reverse16(code)>>(16-n)), n)
+}
+
+// writeFixedLiteral writes a literal/length symbol with the fixed Huffman code.
+func (w *bitWriter) writeFixedLiteral(sym uint16) {
+	switch {
+	case sym < 144:
+		w.writeCode(0x30+sym, 8)
+	case sym < 256:
+		w.writeCode(0x190+sym-144, 9)
+	case sym < 280:
+		w.writeCode(sym-256, 7)
+	default:
+		w.writeCode(0xc0+sym-280, 8)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+// makeDeflate64 returns a Deflate64 stream that uses the longer length
+// code and distances beyond 32 KiB, and what it decompresses to.
+func makeDeflate64(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	stored := make([]byte, 40000)
+	rand.New(rand.NewSource(64)).Read(stored)
+
+	var w bitWriter
+	w.writeBits(0, 1) // not final
+	w.writeBits(0, 2) // stored
+	w.bytes()
+	w.writeBits(uint64(len(stored)), 16)
+	w.writeBits(uint64(^uint16(len(stored))), 16)
+	w.buf.Write(stored)
+
+	w.writeBits(1, 1) // final
+	w.writeBits(1, 2) // fixed Huffman codes
+	w.writeFixedLiteral(285)
+	w.writeBits(1000, 16) // length 3+1000
+	w.writeCode(30, 5)
+	w.writeBits(100, 14) // distance 32769+100
+	w.writeFixedLiteral('z')
+	w.writeFixedLiteral(256)
+
+	expect := append([]byte{}, stored...)
+	start := len(stored) - (32769 + 100)
+	expect = append(expect, stored[start:start+1003]...)
+	expect = append(expect, 'z')
+	return w.bytes(), expect
+}
+
+func TestDeflate64(t *testing.T) {
+	t.Run("deflate64", func(t *testing.T) {
+		compressed, expect := makeDeflate64(t)
+		got, err := io.ReadAll(newDeflate64Reader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expect) {
+			t.Errorf("expected %d bytes, got %d that differ", len(expect), len(got))
+		}
+	})
+
+	// Deflate streams without matches of length 258 are also Deflate64
+	// streams, which exercises dynamic Huffman codes
+	plain := bytes.Repeat([]byte("Deflate64 is Deflate with a bigger window. "), 2000)
+	for _, level := range []int{flate.NoCompression, flate.HuffmanOnly} {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(plain)
+		fw.Close()
+		got, err := io.ReadAll(newDeflate64Reader(&buf))
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("level %d: contents differ", level)
+		}
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		compressed, _ := makeDeflate64(t)
+		_, err := io.ReadAll(newDeflate64Reader(bytes.NewReader(compressed[:len(compressed)-1])))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+		}
+	})
+}
+
+func TestZipDeflate64(t *testing.T) {
+	compressed, expect := makeDeflate64(t)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "big.bin",
+		Method:             ZipMethodDeflate64,
+		CRC32:              crc32.ChecksumIEEE(expect),
+		CompressedSize64:   uint64(len(compressed)),
+		UncompressedSize64: uint64(len(expect)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, errs, err := extractEntries(t, Zip{}, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 || contents["big.bin"] != string(expect) {
+		t.Errorf("expected contents to match (errors: %v)", errs)
+	}
+}
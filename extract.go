@@ -0,0 +1,716 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// ExtractOptions specifies various options for extracting archive
+// entries to a directory or other WritableFS.
+type ExtractOptions struct {
+	// What to do when an entry would replace something that
+	// already exists in the destination. The default is
+	// ConflictOverwrite. Directories are always merged.
+	Conflict ConflictPolicy
+
+	// If true, the contents of each file are flushed to stable
+	// storage before the file is renamed into place. This is
+	// slower, but guarantees that files which appear complete
+	// after a crash really are.
+	Fsync bool
+
+	// If true, the permission bits of entries, including the setuid,
+	// setgid, and sticky bits, are applied to the files and directories
+	// that are created. Otherwise, files are created with mode 0644 and
+	// directories with mode 0755 (both subject to the process umask).
+	Permissions bool
+
+	// Permission bits to clear from entries' modes when Permissions
+	// is true, in the style of a umask (e.g. 022).
+	Umask fs.FileMode
+
+	// If true, modification times (and access times, if the archive
+	// records them) are restored. Times of directories are set after
+	// all of their contents have been written.
+	Times bool
+
+	// If true and the process is running as root, the owner and group
+	// recorded in the archive (if any) are applied to extracted entries.
+	// Numeric IDs are used unless OwnerNames is also true. When not
+	// running as root, ownership is not changed.
+	Ownership bool
+
+	// If true, user and group names recorded in the archive are looked
+	// up on this system and preferred over the numeric IDs when restoring
+	// ownership. Numeric IDs are used for names that do not exist here.
+	OwnerNames bool
+
+	// If true, character devices, block devices, and named pipes (FIFOs)
+	// are created. Otherwise they are skipped. Creating devices usually
+	// requires running as root, and is only supported on Linux.
+	SpecialFiles bool
+
+	// If true, blocks of zeros in extracted files are skipped over
+	// rather than written, leaving holes that make the files sparse
+	// on file systems that support it. This is useful for formats
+	// that cannot record holes themselves. Entries stored as sparse
+	// files in tar archives are always written with holes.
+	Sparse bool
+
+	// If set, rewrites the name of each entry before it is extracted,
+	// and the targets of hard links, which are also names of entries.
+	// Entries for which it returns an empty name are skipped. Names
+	// are checked for safety after they have been mapped.
+	NameMapper NameMapper
+
+	// If set, the name of a file in which the progress of the extraction
+	// is recorded after each entry, so that an extraction that was
+	// interrupted can be resumed by extracting the same archive to the
+	// same destination with the same options. Entries that were already
+	// extracted are skipped without being written, which for plain tar
+	// archives that can seek (such as an *os.File) is done by seeking
+	// straight to the last extracted entry. The file is removed once
+	// extraction succeeds. If the last extracted file has since changed
+	// size or contents (which can only be checked if the destination is
+	// also an fs.FS, like RootFS and MemFS), or the archive is a different
+	// one, an error wrapping ErrCheckpointMismatch is returned. With
+	// ContinueOnError, progress is not recorded past an entry that
+	// failed, and the file is kept, so that resuming tries it again.
+	Checkpoint string
+
+	// If true, errors encountered while writing an entry will be
+	// logged and extraction will continue with the remaining
+	// entries. Context cancellation always aborts extraction.
+	ContinueOnError bool
+}
+
+// ConflictPolicy decides what happens when an extracted entry
+// would replace a file that already exists in the destination.
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces the existing file.
+	ConflictOverwrite ConflictPolicy = iota
+
+	// ConflictSkip keeps the existing file and skips the entry.
+	ConflictSkip
+
+	// ConflictKeepNewer replaces the existing file only if the
+	// entry's modification time is newer than the file's.
+	ConflictKeepNewer
+
+	// ConflictRename keeps the existing file and writes the entry
+	// next to it with a numeric suffix, as in "name (1).txt".
+	ConflictRename
+
+	// ConflictFail aborts with an error wrapping fs.ErrExist.
+	ConflictFail
+)
+
+// ExtractToDisk walks the archive using format and writes its entries into
+// destDir, which is created if it does not already exist. Regular files,
+// directories, symbolic links, and hard links (entries that are not symbolic
+// links but have a LinkTarget, as produced by Tar) are created; devices and
+// named pipes are created if options.SpecialFiles is set, and other types of
+// entries are skipped. Other options control whether permissions, times, and
+// ownership are restored.
+//
+// Each file, symbolic link, and hard link is first created under a temporary
+// name in its destination directory and then renamed into place, so that a
+// cancelled context or a corrupt entry never leaves a partially written file
+// behind. Existing files are handled according to options.Conflict.
+//
+// All writes are confined to destDir by an [os.Root]. Entries with absolute
+// names, or names that would resolve outside destDir (such as "../x"), are
+// refused, as are links whose targets would resolve outside destDir. Since an
+// os.Root never follows a symbolic link out of its directory, entries cannot
+// be written through a link that points elsewhere on the file system either.
+// Refused entries cause an error that wraps ErrInsecurePath.
+func ExtractToDisk(ctx context.Context, format Extractor, archive io.Reader, destDir string, options *ExtractOptions) error {
+	if options == nil {
+		options = new(ExtractOptions)
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return fmt.Errorf("creating destination directory: %w", err)
+	}
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return fmt.Errorf("opening destination directory: %w", err)
+	}
+	defer root.Close()
+
+	return ExtractTo(ctx, format, archive, RootFS{Root: root}, options)
+}
+
+// ExtractTo is like ExtractToDisk, but writes the entries of the archive
+// into the given WritableFS, such as a RootFS or a MemFS. Entries whose
+// names or link targets are not local to the root of fsys are refused
+// with an error wrapping ErrInsecurePath, but it is up to fsys to make
+// sure that files are not written outside of it through symbolic links.
+// Ownership is restored only if fsys can change it (see WritableFS), and
+// special files only if fsys is a RootFS.
+func ExtractTo(ctx context.Context, format Extractor, archive io.Reader, fsys WritableFS, options *ExtractOptions) error {
+	if options == nil {
+		options = new(ExtractOptions)
+	}
+
+	fe := &fsExtractor{fsys: fsys, options: options}
+
+	var cp *checkpointer
+	if options.Checkpoint != "" {
+		var err error
+		cp, err = newCheckpointer(options.Checkpoint, options.Fsync)
+		if err != nil {
+			return err
+		}
+		archive, err = cp.resume(format, archive, fsys)
+		if err != nil {
+			return err
+		}
+	}
+
+	// whether an entry failed with ContinueOnError,
+	// after which the checkpoint is not advanced
+	var failed bool
+
+	err := format.Extract(ctx, archive, func(ctx context.Context, file FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err // honor context cancellation
+		}
+
+		var index int
+		var offset int64
+		if cp != nil {
+			var skip bool
+			var err error
+			index, skip, err = cp.next(file)
+			if err != nil {
+				return err
+			}
+			offset = cp.headerStart(file)
+			if skip {
+				if file.IsDir() {
+					// cheap, and lets the directory's metadata be restored
+					return fe.extractFile(ctx, file)
+				}
+				return nil
+			}
+		}
+
+		if err := fe.extractFile(ctx, file); err != nil {
+			if options.ContinueOnError && ctx.Err() == nil {
+				log.Printf("[ERROR] extracting %s: %v", file.NameInArchive, err)
+				failed = true
+				return nil
+			}
+			return fmt.Errorf("extracting %s: %w", file.NameInArchive, err)
+		}
+
+		if cp != nil && !failed {
+			return cp.save(index, file, fe, offset)
+		}
+		return nil
+	})
+
+	// now that nothing else will be written into them, finish directories
+	if dirErr := fe.finishDirs(); dirErr != nil {
+		err = errors.Join(err, dirErr)
+	}
+
+	if err == nil && cp != nil && !failed {
+		err = cp.remove()
+	}
+
+	return err
+}
+
+// fsExtractor writes archive entries into a file system.
+type fsExtractor struct {
+	fsys    WritableFS
+	options *ExtractOptions
+
+	// directories whose metadata is applied after extraction
+	dirs []pendingDir
+
+	// the path, size, and CRC-32 of the file that was last
+	// written by extractFile, for checkpoints
+	lastPath string
+	lastSize int64
+	lastCRC  uint32
+}
+
+// pendingDir is a directory whose metadata is to be applied once its
+// contents have been written, since writing contents changes the
+// directory's mtime and restrictive permissions could prevent writing.
+type pendingDir struct {
+	name string
+	file FileInfo
+}
+
+func (fe *fsExtractor) extractFile(ctx context.Context, file FileInfo) error {
+	fe.lastPath, fe.lastSize, fe.lastCRC = "", 0, 0
+
+	name, skip, err := fe.entryPath(&file)
+	if err != nil || skip {
+		return err
+	}
+
+	if file.IsDir() {
+		if err := fe.fsys.MkdirAll(name, 0o755); err != nil {
+			return err
+		}
+		fe.dirs = append(fe.dirs, pendingDir{name, file})
+		return nil
+	}
+
+	if dir := path.Dir(name); dir != "." {
+		if err := fe.fsys.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case isSymlink(file):
+		if err := checkSymlinkTarget(file.NameInArchive, file.LinkTarget); err != nil {
+			return err
+		}
+		if err := checkLinkedSymlink(name, file.LinkTarget, fe.isSymlink, fe.isDir); err != nil {
+			return err
+		}
+		return fe.commit(name, file, func(tmp string) error {
+			if err := fe.fsys.Symlink(file.LinkTarget, tmp); err != nil {
+				return err
+			}
+			return fe.applyOwner(tmp, file, true)
+		})
+
+	case file.LinkTarget != "":
+		// hard link targets are paths in the archive, not relative to the link
+		target, err := localPath(file.LinkTarget)
+		if err != nil {
+			return fmt.Errorf("hard link target: %w", err)
+		}
+		return fe.commit(name, file, func(tmp string) error {
+			return fe.fsys.Link(target, tmp)
+		})
+
+	case file.Mode().IsRegular():
+		return fe.commit(name, file, func(tmp string) error {
+			if err := fe.writeFile(ctx, tmp, file); err != nil {
+				return err
+			}
+			return fe.applyMetadata(tmp, file)
+		})
+
+	case file.Mode()&(fs.ModeDevice|fs.ModeNamedPipe) != 0 && fe.options.SpecialFiles:
+		nfs, ok := fe.fsys.(nodeFS)
+		if !ok {
+			return fmt.Errorf("creating special files: %w", errors.ErrUnsupported)
+		}
+		return fe.commit(name, file, func(tmp string) error {
+			if err := nfs.mknod(tmp, file); err != nil {
+				return err
+			}
+			return fe.applyMetadata(tmp, file)
+		})
+	}
+
+	return nil
+}
+
+// entryPath maps the names of file with the NameMapper, if any, and
+// returns the local path to write it to. It returns true if the entry
+// is to be skipped because it was dropped by the NameMapper or is the
+// root directory, which is the destination itself.
+func (fe *fsExtractor) entryPath(file *FileInfo) (string, bool, error) {
+	if fe.options.NameMapper != nil {
+		if file.NameInArchive = fe.options.NameMapper(file.NameInArchive); file.NameInArchive == "" {
+			return "", true, nil
+		}
+		if file.LinkTarget != "" && !isSymlink(*file) {
+			if file.LinkTarget = fe.options.NameMapper(file.LinkTarget); file.LinkTarget == "" {
+				return "", false, fmt.Errorf("hard link target was dropped by name mapper")
+			}
+		}
+	}
+
+	name, err := localPath(file.NameInArchive)
+	if err != nil {
+		return "", false, err
+	}
+	if name == "." {
+		if file.IsDir() {
+			return "", true, nil
+		}
+		return "", false, fmt.Errorf("%q: %w", file.NameInArchive, ErrInsecurePath)
+	}
+	return name, false, nil
+}
+
+// commit creates the entry under a temporary name in the same directory
+// as name by calling create, then renames it into place, subject to the
+// conflict policy. The temporary file is removed if anything fails. The
+// create function must fail with an error wrapping fs.ErrExist if the
+// temporary name is already taken.
+func (fe *fsExtractor) commit(name string, file FileInfo, create func(tmp string) error) error {
+	name, skip, err := fe.resolveConflict(name, file)
+	if err != nil || skip {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		tmp := path.Join(path.Dir(name), fmt.Sprintf(".%s.tmp%08x", path.Base(name), rand.Uint32()))
+		err := create(tmp)
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue // unlucky; try another name
+		}
+		if err == nil {
+			err = fe.fsys.Rename(tmp, name)
+		}
+		if err != nil {
+			fe.lastPath = ""
+			if rmErr := fe.fsys.Remove(tmp); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+				err = fmt.Errorf("%w; additionally, removing temporary file: %w", err, rmErr)
+			}
+			return err
+		}
+		if fe.lastPath == tmp {
+			fe.lastPath = name
+		}
+		return nil
+	}
+}
+
+// resolveConflict applies the conflict policy to name. It returns the
+// name to write the entry to, or true if the entry should be skipped.
+func (fe *fsExtractor) resolveConflict(name string, file FileInfo) (string, bool, error) {
+	info, err := fe.fsys.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return name, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	switch fe.options.Conflict {
+	case ConflictSkip:
+		return "", true, nil
+	case ConflictFail:
+		return "", false, fmt.Errorf("%s: %w", name, fs.ErrExist)
+	case ConflictKeepNewer:
+		if !file.ModTime().After(info.ModTime()) {
+			return "", true, nil
+		}
+	case ConflictRename:
+		return fe.unusedName(name)
+	}
+
+	if info.IsDir() {
+		return "", false, fmt.Errorf("%s: a directory already exists with that name", name)
+	}
+	return name, false, nil
+}
+
+// unusedName returns a variant of name with a numeric suffix
+// inserted before the extension that does not yet exist.
+func (fe *fsExtractor) unusedName(name string) (string, bool, error) {
+	for i := 1; ; i++ {
+		candidate := numberedName(name, i)
+		_, err := fe.fsys.Lstat(candidate)
+		if errors.Is(err, fs.ErrNotExist) {
+			return candidate, false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+}
+
+// writeFile creates the file tmp, which must not already exist, and
+// copies the contents of file into it.
+func (fe *fsExtractor) writeFile(ctx context.Context, tmp string, file FileInfo) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fe.fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = dst
+	if seekable, ok := dst.(interface {
+		io.WriteSeeker
+		Truncate(int64) error
+	}); ok && (fe.options.Sparse || isSparseTarEntry(file)) {
+		w = &holeWriter{f: seekable}
+	}
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(w, io.TeeReader(contextReader{ctx, src}, crc))
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	fe.lastPath, fe.lastSize, fe.lastCRC = tmp, n, crc.Sum32()
+	if hw, ok := w.(*holeWriter); ok {
+		if err := hw.Close(); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	if syncer, ok := dst.(interface{ Sync() error }); ok && fe.options.Fsync {
+		if err := syncer.Sync(); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	return dst.Close()
+}
+
+// applyMetadata applies the ownership, permissions, and times of
+// file to name, as configured by the options.
+func (fe *fsExtractor) applyMetadata(name string, file FileInfo) error {
+	// ownership first, since chown may clear the setuid and setgid bits
+	if err := fe.applyOwner(name, file, false); err != nil {
+		return err
+	}
+	if fe.options.Permissions {
+		if err := fe.fsys.Chmod(name, file.Mode()&^fe.options.Umask&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return fmt.Errorf("setting permissions: %w", err)
+		}
+	}
+	if fe.options.Times {
+		atime, mtime := entryTimes(file)
+		if err := fe.fsys.Chtimes(name, atime, mtime); err != nil {
+			return fmt.Errorf("setting times: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyOwner changes the owner of name to that of file if ownership
+// is to be restored. If link is true, name is a symbolic link and
+// the link itself is changed.
+func (fe *fsExtractor) applyOwner(name string, file FileInfo, link bool) error {
+	ofs, ok := fe.fsys.(ownerFS)
+	if !ok || !fe.options.Ownership || os.Geteuid() != 0 {
+		return nil
+	}
+	owner, ok := entryOwner(file)
+	if !ok {
+		return nil
+	}
+	uid, gid := owner.ids(fe.options.OwnerNames)
+	var err error
+	if link {
+		err = ofs.Lchown(name, uid, gid)
+	} else {
+		err = ofs.Chown(name, uid, gid)
+	}
+	if err != nil {
+		return fmt.Errorf("setting owner: %w", err)
+	}
+	return nil
+}
+
+// finishDirs applies the metadata of extracted directories,
+// deepest first, so that setting the times or permissions of
+// a directory is not undone or prevented by its subdirectories.
+func (fe *fsExtractor) finishDirs() error {
+	slices.SortStableFunc(fe.dirs, func(a, b pendingDir) int {
+		return strings.Count(b.name, "/") - strings.Count(a.name, "/")
+	})
+	var errs []error
+	for _, dir := range fe.dirs {
+		if err := fe.applyMetadata(dir.name, dir.file); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir.name, err))
+		}
+	}
+	fe.dirs = nil
+	return errors.Join(errs...)
+}
+
+// numberedName returns name with the numeric suffix i inserted
+// before its extension, as in "name (1).txt".
+func numberedName(name string, i int) string {
+	ext := path.Ext(name)
+	if ext == path.Base(name) {
+		ext = "" // dotfiles like ".profile" have no extension
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+}
+
+// entryTimes returns the access and modification times of file. If the
+// archive does not record an access time, the modification time is used.
+func entryTimes(file FileInfo) (atime, mtime time.Time) {
+	mtime = file.ModTime()
+	atime = mtime
+	switch hdr := file.Header.(type) {
+	case *tar.Header:
+		if !hdr.AccessTime.IsZero() {
+			atime = hdr.AccessTime
+		}
+	case zip.FileHeader:
+		if extra := ParseZipExtra(hdr.Extra); !extra.AccessTime.IsZero() {
+			atime = extra.AccessTime
+		}
+	}
+	return
+}
+
+// entryOwner returns the ownership information recorded for file,
+// or false if the archive format does not record any.
+func entryOwner(file FileInfo) (fileOwner, bool) {
+	if hdr, ok := file.Header.(*tar.Header); ok {
+		return fileOwner{Uid: hdr.Uid, Gid: hdr.Gid, Uname: hdr.Uname, Gname: hdr.Gname}, true
+	}
+	if hdr, ok := file.Header.(zip.FileHeader); ok {
+		if extra := ParseZipExtra(hdr.Extra); extra.HasOwner {
+			return fileOwner{Uid: extra.Uid, Gid: extra.Gid}, true
+		}
+	}
+	return fileOwner{}, false
+}
+
+// fileOwner is the ownership of an archive entry.
+type fileOwner struct {
+	Uid, Gid     int
+	Uname, Gname string
+}
+
+// ids returns the user and group IDs to use for the owner. If byName is
+// true, the user and group names are looked up on this system, falling
+// back to the numeric IDs if a name is empty or unknown.
+func (o fileOwner) ids(byName bool) (uid, gid int) {
+	uid, gid = o.Uid, o.Gid
+	if !byName {
+		return
+	}
+	if o.Uname != "" {
+		if u, err := user.Lookup(o.Uname); err == nil {
+			if id, err := strconv.Atoi(u.Uid); err == nil {
+				uid = id
+			}
+		}
+	}
+	if o.Gname != "" {
+		if g, err := user.LookupGroup(o.Gname); err == nil {
+			if id, err := strconv.Atoi(g.Gid); err == nil {
+				gid = id
+			}
+		}
+	}
+	return
+}
+
+// contextReader is an io.Reader that fails once its context is done,
+// so that long copies honor cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// localPath cleans a slash-separated name from an archive into a path
+// that is local to the extraction root, in the form accepted by
+// WritableFS. It returns an error wrapping ErrInsecurePath if the name
+// is absolute, escapes the root, is empty, or is otherwise not local on
+// this system (see [filepath.IsLocal]).
+func localPath(name string) (string, error) {
+	osName := filepath.FromSlash(name)
+	if !filepath.IsLocal(osName) {
+		return "", fmt.Errorf("%q: %w", name, ErrInsecurePath)
+	}
+	return filepath.ToSlash(filepath.Clean(osName)), nil
+}
+
+// checkSymlinkTarget returns an error wrapping ErrInsecurePath if the
+// symbolic link named name, pointing to target, would resolve to a path
+// outside the extraction root. Absolute targets are never allowed.
+func checkSymlinkTarget(name, target string) error {
+	if target == "" {
+		return fmt.Errorf("%s: empty symlink target", name)
+	}
+	resolved := path.Join(path.Dir(filepath.ToSlash(name)), filepath.ToSlash(target))
+	if path.IsAbs(filepath.ToSlash(target)) || filepath.IsAbs(target) || !filepath.IsLocal(filepath.FromSlash(resolved)) {
+		return fmt.Errorf("%s: symlink target %q: %w", name, target, ErrInsecurePath)
+	}
+	return nil
+}
+
+// checkLinkedSymlink returns an error wrapping ErrInsecurePath if the
+// symbolic link name, pointing to target, could resolve to a path outside
+// the extraction root by way of other symbolic links, which the lexical
+// check of checkSymlinkTarget can't see: if "d" is a link to ".", a link
+// "d/l" to "../outside" escapes, and so does a link "l" to "d/..". So the
+// directories that name is in must not be links, and target may only go
+// up a directory from directories that are already real ones, since a
+// path that doesn't exist yet could still become a link later in the
+// archive. isLink reports whether a path in the destination is a symbolic
+// link, and isDir whether it is a directory that is not one.
+func checkLinkedSymlink(name, target string, isLink, isDir func(string) bool) error {
+	dir := path.Dir(name)
+	for parent := dir; parent != "."; parent = path.Dir(parent) {
+		if isLink(parent) {
+			return fmt.Errorf("%s: parent directory %s is a symbolic link: %w", name, parent, ErrInsecurePath)
+		}
+	}
+
+	resolved, real := dir, true
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if !real {
+				return fmt.Errorf("%s: symlink target %q goes up from %s, which is not a directory: %w", name, target, resolved, ErrInsecurePath)
+			}
+			resolved = path.Dir(resolved)
+		default:
+			resolved = path.Join(resolved, elem)
+			real = real && isDir(resolved)
+		}
+	}
+	return nil
+}
+
+// isSymlink reports whether name is a symbolic link in the destination.
+func (fe *fsExtractor) isSymlink(name string) bool {
+	info, err := fe.fsys.Lstat(name)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// isDir reports whether name is a directory in the destination, and not
+// a symbolic link to one.
+func (fe *fsExtractor) isDir(name string) bool {
+	info, err := fe.fsys.Lstat(name)
+	return err == nil && info.IsDir()
+}
+
+// ErrInsecurePath is returned (wrapped) when an entry's name or link target
+// would resolve to a location outside of the extraction destination.
+var ErrInsecurePath = errors.New("insecure file path")
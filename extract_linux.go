@@ -0,0 +1,54 @@
+package archives
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// mknod creates the device or named pipe described by file at name
+// within root. The parent directory is opened through root so that
+// the node cannot be created outside of it.
+func mknod(root *os.Root, name string, file FileInfo) error {
+	var mode uint32
+	switch {
+	case file.Mode()&fs.ModeNamedPipe != 0:
+		mode = syscall.S_IFIFO
+	case file.Mode()&fs.ModeCharDevice != 0:
+		mode = syscall.S_IFCHR
+	case file.Mode()&fs.ModeDevice != 0:
+		mode = syscall.S_IFBLK
+	default:
+		return fmt.Errorf("%s: not a device or named pipe", name)
+	}
+	mode |= uint32(file.Mode().Perm())
+
+	var major, minor int64
+	if hdr, ok := file.Header.(*tar.Header); ok {
+		major, minor = hdr.Devmajor, hdr.Devminor
+	}
+
+	dir, err := root.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	err = syscall.Mknodat(int(dir.Fd()), filepath.Base(name), mode, int(mkdev(major, minor)))
+	if err != nil {
+		return &fs.PathError{Op: "mknodat", Path: name, Err: err}
+	}
+	return nil
+}
+
+// mkdev encodes a device number the way glibc's makedev does.
+func mkdev(major, minor int64) uint64 {
+	maj, mnr := uint64(major), uint64(minor)
+	return (maj&0x00000fff)<<8 |
+		(maj&0xfffff000)<<32 |
+		(mnr&0x000000ff)<<0 |
+		(mnr&0xffffff00)<<12
+}
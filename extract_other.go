@@ -0,0 +1,14 @@
+//go:build !linux
+
+package archives
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// mknod is only supported on Linux.
+func mknod(_ *os.Root, name string, _ FileInfo) error {
+	return fmt.Errorf("%s: creating devices and named pipes: %w", name, errors.ErrUnsupported)
+}
@@ -0,0 +1,397 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTarEntry describes an entry for makeTestTar.
+type testTarEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+	mode     int64
+}
+
+// makeTestTar builds an in-memory tar archive with the given entries.
+func makeTestTar(t *testing.T, entries []testTarEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     e.mode,
+			ModTime:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Mode = 0o755
+			}
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing header for %s: %v", e.name, err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatalf("writing body for %s: %v", e.name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestExtractToDisk(t *testing.T) {
+	entries := []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a.txt", body: "hello"},
+		{name: "b/c/d.txt", body: "nested"},
+		{name: "dir/hard.txt", typeflag: tar.TypeLink, linkname: "dir/a.txt"},
+	}
+	if runtime.GOOS != "windows" {
+		entries = append(entries,
+			testTarEntry{name: "dir/link.txt", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+			testTarEntry{name: "linkdir", typeflag: tar.TypeSymlink, linkname: "b/c"},
+			testTarEntry{name: "linkdir/e.txt", body: "through link"},
+		)
+	}
+	dest := filepath.Join(t.TempDir(), "out")
+
+	err := ExtractToDisk(context.Background(), Tar{}, makeTestTar(t, entries), dest, nil)
+	if err != nil {
+		t.Fatalf("ExtractToDisk: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"dir/a.txt":    "hello",
+		"b/c/d.txt":    "nested",
+		"dir/hard.txt": "hello",
+	} {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		target, err := os.Readlink(filepath.Join(dest, "dir", "link.txt"))
+		if err != nil {
+			t.Errorf("reading symlink: %v", err)
+		} else if target != "a.txt" {
+			t.Errorf("expected symlink target a.txt, got %s", target)
+		}
+		got, err := os.ReadFile(filepath.Join(dest, "b", "c", "e.txt"))
+		if err != nil || string(got) != "through link" {
+			t.Errorf("expected file written through in-root symlink, got %q (err=%v)", got, err)
+		}
+	}
+}
+
+func TestExtractToDiskRefusesEscapes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []testTarEntry
+		symlink bool
+	}{
+		{name: "dot-dot", entries: []testTarEntry{{name: "../evil.txt", body: "x"}}},
+		{name: "nested dot-dot", entries: []testTarEntry{{name: "a/../../evil.txt", body: "x"}}},
+		{name: "absolute", entries: []testTarEntry{{name: "/evil.txt", body: "x"}}},
+		{name: "hardlink escape", entries: []testTarEntry{{name: "h", typeflag: tar.TypeLink, linkname: "../evil.txt"}}},
+		{name: "absolute symlink", symlink: true, entries: []testTarEntry{{name: "l", typeflag: tar.TypeSymlink, linkname: "/etc"}}},
+		{name: "relative symlink escape", symlink: true, entries: []testTarEntry{{name: "a/l", typeflag: tar.TypeSymlink, linkname: "../../x"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.symlink && runtime.GOOS == "windows" {
+				t.Skip("symlinks require privileges on Windows")
+			}
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "out")
+			err := ExtractToDisk(context.Background(), Tar{}, makeTestTar(t, tc.entries), dest, nil)
+			if !errors.Is(err, ErrInsecurePath) {
+				t.Fatalf("expected ErrInsecurePath, got %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil.txt")); err == nil {
+				t.Fatal("file was written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractToDiskDoesNotFollowEscapingSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "out")
+	outside := filepath.Join(parent, "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a link left behind in the destination by something else
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := makeTestTar(t, []testTarEntry{{name: "escape/evil.txt", body: "x"}})
+	if err := ExtractToDisk(context.Background(), Tar{}, archive, dest, nil); err == nil {
+		t.Fatal("expected an error writing through an escaping symlink")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "evil.txt")); err == nil {
+		t.Fatal("file was written outside of the destination")
+	}
+}
+
+func TestExtractToDiskRefusesChainedSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	for _, tc := range []struct {
+		name     string
+		entries  []testTarEntry
+		rejected string
+	}{
+		{name: "link in parent", rejected: "d/l", entries: []testTarEntry{
+			{name: "d", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "d/l", typeflag: tar.TypeSymlink, linkname: "../outside"},
+		}},
+		{name: "link in target", rejected: "l", entries: []testTarEntry{
+			{name: "d", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "l", typeflag: tar.TypeSymlink, linkname: "d/../outside"},
+		}},
+		{name: "link in target made later", rejected: "l", entries: []testTarEntry{
+			{name: "l", typeflag: tar.TypeSymlink, linkname: "d/.."},
+			{name: "d", typeflag: tar.TypeSymlink, linkname: "."},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "out")
+			err := ExtractToDisk(context.Background(), Tar{}, makeTestTar(t, tc.entries), dest, nil)
+			if !errors.Is(err, ErrInsecurePath) {
+				t.Fatalf("expected ErrInsecurePath, got %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(dest, tc.rejected)); err == nil {
+				t.Fatal("escaping link was created")
+			}
+
+			plan, err := PlanExtraction(context.Background(), Tar{}, makeTestTar(t, tc.entries), filepath.Join(t.TempDir(), "plan"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan.Rejected) != 1 || plan.Rejected[0].Name != tc.rejected || !errors.Is(plan.Rejected[0].Err, ErrInsecurePath) {
+				t.Fatalf("expected link %s to be rejected by the plan, got %v", tc.rejected, plan.Rejected)
+			}
+		})
+	}
+}
+
+func TestExtractToDiskContinueOnError(t *testing.T) {
+	dest := t.TempDir()
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "../evil.txt", body: "x"},
+		{name: "good.txt", body: "ok"},
+	})
+	err := ExtractToDisk(context.Background(), Tar{}, archive, dest, &ExtractOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("ExtractToDisk: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "good.txt")); err != nil {
+		t.Errorf("expected good.txt to be extracted: %v", err)
+	}
+}
+
+func TestExtractToDiskConflicts(t *testing.T) {
+	newer := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) // mod time used by makeTestTar
+
+	for _, tc := range []struct {
+		policy    ConflictPolicy
+		oldMtime  time.Time
+		expectErr bool
+		expect    map[string]string
+	}{
+		{policy: ConflictOverwrite, expect: map[string]string{"f.txt": "new"}},
+		{policy: ConflictSkip, expect: map[string]string{"f.txt": "old"}},
+		{policy: ConflictKeepNewer, oldMtime: newer.Add(time.Hour), expect: map[string]string{"f.txt": "old"}},
+		{policy: ConflictKeepNewer, oldMtime: newer.Add(-time.Hour), expect: map[string]string{"f.txt": "new"}},
+		{policy: ConflictRename, expect: map[string]string{"f.txt": "old", "f (1).txt": "new"}},
+		{policy: ConflictFail, expectErr: true, expect: map[string]string{"f.txt": "old"}},
+	} {
+		dest := t.TempDir()
+		existing := filepath.Join(dest, "f.txt")
+		if err := os.WriteFile(existing, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if !tc.oldMtime.IsZero() {
+			if err := os.Chtimes(existing, tc.oldMtime, tc.oldMtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		archive := makeTestTar(t, []testTarEntry{{name: "f.txt", body: "new"}})
+		err := ExtractToDisk(context.Background(), Tar{}, archive, dest, &ExtractOptions{Conflict: tc.policy, Fsync: true})
+		if tc.expectErr {
+			if !errors.Is(err, fs.ErrExist) {
+				t.Errorf("policy %d: expected fs.ErrExist, got %v", tc.policy, err)
+			}
+		} else if err != nil {
+			t.Errorf("policy %d: unexpected error: %v", tc.policy, err)
+		}
+
+		for name, want := range tc.expect {
+			got, err := os.ReadFile(filepath.Join(dest, name))
+			if err != nil || string(got) != want {
+				t.Errorf("policy %d: %s: expected %q, got %q (err=%v)", tc.policy, name, want, got, err)
+			}
+		}
+		assertNoTempFiles(t, dest)
+	}
+}
+
+func TestExtractToDiskCancelLeavesNoPartialFile(t *testing.T) {
+	dest := t.TempDir()
+	archive := makeTestTar(t, []testTarEntry{{name: "big.txt", body: strings.Repeat("x", 1<<20)}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	format := extractorFunc(func(ctx context.Context, archive io.Reader, handleFile FileHandler) error {
+		return Tar{}.Extract(ctx, archive, func(ctx context.Context, info FileInfo) error {
+			open := info.Open
+			info.Open = func() (fs.File, error) {
+				f, err := open()
+				cancel() // cancel as soon as the file body starts being read
+				return f, err
+			}
+			return handleFile(ctx, info)
+		})
+	})
+
+	err := ExtractToDisk(ctx, format, archive, dest, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "big.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no file after cancellation, got err=%v", err)
+	}
+	assertNoTempFiles(t, dest)
+}
+
+// extractorFunc adapts a function to the Extractor interface.
+type extractorFunc func(ctx context.Context, archive io.Reader, handleFile FileHandler) error
+
+func (f extractorFunc) Extract(ctx context.Context, archive io.Reader, handleFile FileHandler) error {
+	return f(ctx, archive, handleFile)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("temporary file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestExtractToDiskMetadata(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) // mod time used by makeTestTar
+	entries := []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir, mode: 0o750},
+		{name: "dir/run.sh", body: "#!/bin/sh", mode: 0o777},
+	}
+	if runtime.GOOS == "linux" {
+		entries = append(entries, testTarEntry{name: "dir/pipe", typeflag: tar.TypeFifo, mode: 0o600})
+	}
+	dest := t.TempDir()
+	options := &ExtractOptions{
+		Permissions:  true,
+		Umask:        0o022,
+		Times:        true,
+		SpecialFiles: true,
+	}
+	if err := ExtractToDisk(context.Background(), Tar{}, makeTestTar(t, entries), dest, options); err != nil {
+		t.Fatalf("ExtractToDisk: %v", err)
+	}
+
+	for name, wantPerm := range map[string]fs.FileMode{
+		"dir":        0o750,
+		"dir/run.sh": 0o755,
+	} {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != wantPerm {
+			t.Errorf("%s: expected mode %v, got %v", name, wantPerm, info.Mode().Perm())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: expected mtime %v, got %v", name, mtime, info.ModTime())
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		info, err := os.Lstat(filepath.Join(dest, "dir", "pipe"))
+		if err != nil {
+			t.Fatalf("stat pipe: %v", err)
+		}
+		if info.Mode()&fs.ModeNamedPipe == 0 {
+			t.Errorf("expected a named pipe, got mode %v", info.Mode())
+		}
+	}
+}
+
+func TestExtractToDiskOwnership(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("restoring ownership requires running as root")
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{Name: "owned.txt", Mode: 0o644, Uid: 1234, Gid: 5678, Uname: "no-such-user-here"}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	dest := t.TempDir()
+	options := &ExtractOptions{Ownership: true, OwnerNames: true}
+	if err := ExtractToDisk(context.Background(), Tar{}, bytes.NewReader(buf.Bytes()), dest, options); err != nil {
+		t.Fatalf("ExtractToDisk: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "owned.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, gid, ok := statOwner(info)
+	if ok && (uid != 1234 || gid != 5678) {
+		t.Errorf("expected owner 1234:5678, got %d:%d", uid, gid)
+	}
+}
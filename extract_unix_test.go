@@ -0,0 +1,16 @@
+//go:build !windows
+
+package archives
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func statOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,5 @@
+package archives
+
+import "io/fs"
+
+func statOwner(fs.FileInfo) (uid, gid int, ok bool) { return 0, 0, false }
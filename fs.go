@@ -14,6 +14,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 )
 
 // FileSystem identifies the format of the input and returns a read-only file system.
@@ -93,7 +96,7 @@ func FileSystem(ctx context.Context, filename string, stream ReaderAtSeeker) (fs
 	case Extractor:
 		// if no stream was input, return an ArchiveFS that relies on the filepath
 		if stream == nil {
-			return &ArchiveFS{Path: filename, Format: fileFormat, Context: ctx}, nil
+			return &ArchiveFS{Path: filename, Format: fileFormat, Context: ctx, names: new(nameIndex)}, nil
 		}
 
 		// otherwise, if a stream was input, return an ArchiveFS that relies on that
@@ -107,7 +110,7 @@ func FileSystem(ctx context.Context, filename string, stream ReaderAtSeeker) (fs
 
 		sr := io.NewSectionReader(stream, 0, size)
 
-		return &ArchiveFS{Stream: sr, Format: fileFormat, Context: ctx}, nil
+		return &ArchiveFS{Stream: sr, Format: fileFormat, Context: ctx, names: new(nameIndex)}, nil
 
 	case Compression:
 		return FileFS{Path: filename, Compression: fileFormat}, nil
@@ -316,13 +319,40 @@ type ArchiveFS struct {
 	Path   string            // path to the archive file on disk, or...
 	Stream *io.SectionReader // ...stream from which to read archive
 
-	Format  Extractor       // the archive format
-	Prefix  string          // optional subdirectory in which to root the fs
-	Context context.Context // optional; mainly for cancellation
+	Format     Extractor       // the archive format
+	Prefix     string          // optional subdirectory in which to root the fs
+	Context    context.Context // optional; mainly for cancellation
+	Limits     Limits          // optional; resource limits enforced when reading the archive
+	Normalizer NameNormalizer  // optional; normalizes names of files, in addition to any done by Format
+
+	// If true, names are looked up regardless of case and Unicode
+	// normalization, as on typical macOS and Windows file systems, so
+	// that "Docs/README.md" finds "docs/readme.md". A name that matches
+	// an entry exactly is always found; otherwise, if it matches more
+	// than one entry, the lookup fails with ErrAmbiguousName. These
+	// lookups need an index of the archive. An ArchiveFS returned by
+	// FileSystem builds it on the first lookup and shares it with all
+	// of its copies (such as those that Open and Stat are called on);
+	// one made otherwise uses the index of ReadDir if it was called,
+	// and indexes the archive for each lookup if not.
+	CaseInsensitive bool
 
 	// amortizing cache speeds up walks (esp. ReadDir)
 	contents map[string]fs.FileInfo
 	dirs     map[string][]fs.DirEntry
+
+	// index for case-insensitive lookups; see FileSystem
+	names *nameIndex
+}
+
+// nameIndex is the index of the entries of an archive that
+// case-insensitive lookups use. It is built once and shared
+// by the copies of the ArchiveFS that it belongs to.
+type nameIndex struct {
+	once     sync.Once
+	contents map[string]fs.FileInfo
+	dirs     map[string][]fs.DirEntry
+	err      error
 }
 
 // context always return a context, preferring f.Context if not nil.
@@ -333,6 +363,12 @@ func (f ArchiveFS) context() context.Context {
 	return context.Background()
 }
 
+// wrapHandler returns a FileHandler that normalizes the names of files
+// and enforces the limits of the file system before calling handler.
+func (f ArchiveFS) wrapHandler(handler FileHandler) FileHandler {
+	return f.Normalizer.wrapHandler(newLimiter(f.Limits).wrapHandler(handler))
+}
+
 // Open opens the named file from within the archive. If name is "." then
 // the archive file itself will be opened as a directory file.
 func (f ArchiveFS) Open(name string) (fs.File, error) {
@@ -343,6 +379,11 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 	// apply prefix if fs is rooted in a subtree
 	name = path.Join(f.Prefix, name)
 
+	name, err := f.resolveName(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
 	// if we've already indexed the archive, we can know quickly if the file doesn't exist,
 	// and we can also return directory files with their entries instantly
 	if f.contents != nil {
@@ -362,7 +403,6 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 
 	// if a filename is specified, open the archive file
 	var archiveFile *os.File
-	var err error
 	if f.Stream == nil {
 		archiveFile, err = os.Open(f.Path)
 		if err != nil {
@@ -497,9 +537,9 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 		// bypass the CompressedArchive format's opening of the decompressor, since
 		// we already did it because we need to keep it open after returning.
 		// "I BYPASSED THE COMPRESSOR!" -Rey
-		err = ar.Extraction.Extract(f.context(), inputStream, handler)
+		err = ar.Extraction.Extract(f.context(), inputStream, f.wrapHandler(handler))
 	} else {
-		err = f.Format.Extract(f.context(), inputStream, handler)
+		err = f.Format.Extract(f.context(), inputStream, f.wrapHandler(handler))
 	}
 	if err != nil {
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("extract: %w", err)}
@@ -533,6 +573,11 @@ func (f ArchiveFS) Stat(name string) (fs.FileInfo, error) {
 	// apply prefix if fs is rooted in a subtree
 	name = path.Join(f.Prefix, name)
 
+	name, err := f.resolveName(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
 	// if archive has already been indexed, simply use it
 	if f.contents != nil {
 		if info, ok := f.contents[name]; ok {
@@ -547,7 +592,6 @@ func (f ArchiveFS) Stat(name string) (fs.FileInfo, error) {
 	}
 
 	var archiveFile *os.File
-	var err error
 	if f.Stream == nil {
 		archiveFile, err = os.Open(f.Path)
 		if err != nil {
@@ -578,7 +622,7 @@ func (f ArchiveFS) Stat(name string) (fs.FileInfo, error) {
 	if f.Stream != nil {
 		inputStream = io.NewSectionReader(f.Stream, 0, f.Stream.Size())
 	}
-	err = f.Format.Extract(f.context(), inputStream, handler)
+	err = f.Format.Extract(f.context(), inputStream, f.wrapHandler(handler))
 	if err != nil && result.FileInfo == nil {
 		return nil, &fs.PathError{Op: "stat", Path: name, Err: fmt.Errorf("stat(d) %s: %w", name, fs.ErrNotExist)}
 	}
@@ -603,6 +647,11 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	// apply prefix if fs is rooted in a subtree
 	name = path.Join(f.Prefix, name)
 
+	name, err := f.resolveName(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
 	// fs.WalkDir() calls ReadDir() once per directory, and for archives with
 	// lots of directories, that is very slow, since we have to traverse the
 	// entire archive in order to ensure that we got all the entries for a
@@ -616,7 +665,6 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	f.dirs = make(map[string][]fs.DirEntry)
 
 	var archiveFile *os.File
-	var err error
 	if f.Stream == nil {
 		archiveFile, err = os.Open(f.Path)
 		if err != nil {
@@ -707,7 +755,7 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 		inputStream = io.NewSectionReader(f.Stream, 0, f.Stream.Size())
 	}
 
-	err = f.Format.Extract(f.context(), inputStream, handler)
+	err = f.Format.Extract(f.context(), inputStream, f.wrapHandler(handler))
 	if err != nil {
 		// these being non-nil implies that we have indexed the archive,
 		// but if an error occurred, we likely only got part of the way
@@ -721,6 +769,76 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return f.dirs[name], nil
 }
 
+// resolveName returns the name of the entry in the archive that name,
+// which includes any prefix, refers to. Unless f is case-insensitive,
+// that is name itself. If no entry matches, name is returned so that
+// the caller reports it as not existing.
+func (f *ArchiveFS) resolveName(name string) (string, error) {
+	if !f.CaseInsensitive || name == "." {
+		return name, nil
+	}
+
+	// lookups walk the index one path component at a time
+	contents, dirs := f.contents, f.dirs
+	if len(dirs) == 0 {
+		idx := f.names
+		if idx == nil {
+			idx = new(nameIndex) // not shared, so only for this lookup
+		}
+		idx.once.Do(func() {
+			indexed := *f
+			indexed.Prefix, indexed.CaseInsensitive = "", false
+			_, idx.err = indexed.ReadDir(".")
+			idx.contents, idx.dirs = indexed.contents, indexed.dirs
+		})
+		if idx.err != nil {
+			return name, idx.err
+		}
+		contents, dirs = idx.contents, idx.dirs
+	}
+	if _, ok := contents[name]; ok {
+		return name, nil
+	}
+
+	resolved := "."
+	for component := range strings.SplitSeq(name, "/") {
+		want := foldName(component)
+		var exact string
+		var matches []string
+		for _, entry := range dirs[resolved] {
+			if entry.Name() == component {
+				exact = entry.Name()
+				break
+			}
+			if foldName(entry.Name()) == want {
+				matches = append(matches, path.Join(resolved, entry.Name()))
+			}
+		}
+		switch {
+		case exact != "":
+			resolved = path.Join(resolved, exact)
+		case len(matches) == 1:
+			resolved = matches[0]
+		case len(matches) > 1:
+			return name, fmt.Errorf("%w: %s", ErrAmbiguousName, strings.Join(matches, ", "))
+		default:
+			return name, nil
+		}
+	}
+
+	return resolved, nil
+}
+
+// foldName returns name in a form that is the same for all names that
+// differ only in case or Unicode normalization.
+func foldName(name string) string {
+	return norm.NFC.String(cases.Fold().String(norm.NFD.String(name)))
+}
+
+// ErrAmbiguousName is returned (wrapped) when a case-insensitive
+// lookup matches more than one file.
+var ErrAmbiguousName = errors.New("name matches more than one file")
+
 // Sub returns an FS corresponding to the subtree rooted at dir.
 func (f *ArchiveFS) Sub(dir string) (fs.FS, error) {
 	if !fs.ValidPath(dir) {
@@ -738,8 +856,12 @@ func (f *ArchiveFS) Sub(dir string) (fs.FS, error) {
 	// the reason we don't append to the Path field directly
 	// is because the input might be a stream rather than a
 	// path on disk, and the Prefix field is applied on both
+	prefix, err := f.resolveName(path.Join(f.Prefix, dir))
+	if err != nil {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: err}
+	}
 	result := *f
-	result.Prefix = path.Join(f.Prefix, dir)
+	result.Prefix = prefix
 	return &result, nil
 }
 
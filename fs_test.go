@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -338,6 +339,80 @@ func TestArchiveFS_Sub(t *testing.T) {
 	}
 }
 
+func TestArchiveFS_CaseInsensitive(t *testing.T) {
+	data := makeTestZip(t, []string{
+		"docs/",
+		"docs/readme.md",
+		"caf\u00e9/menu.txt",
+		"same/a.txt",
+		"same/A.txt",
+	}, nil)
+	made, err := FileSystem(context.Background(), "", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := made.(*ArchiveFS)
+	fsys.CaseInsensitive = true
+
+	var extractions int
+	fsys.Format = extractorFunc(func(ctx context.Context, archive io.Reader, handleFile FileHandler) error {
+		extractions++
+		return Zip{}.Extract(ctx, archive, handleFile)
+	})
+
+	lookups := map[string]string{
+		"Docs/README.md":      "docs/readme.md",
+		"docs/readme.md":      "docs/readme.md",
+		"CAFE\u0301/Menu.TXT": "caf\u00e9/menu.txt",
+		"same/A.txt":          "same/A.txt",
+		"same/a.txt":          "same/a.txt",
+	}
+	for name, want := range lookups {
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+			continue
+		}
+		if string(contents) != "contents of "+want {
+			t.Errorf("ReadFile(%q): expected contents of %s, got %q", name, want, contents)
+		}
+	}
+
+	// one extraction to open each file, and one to index the archive
+	if extractions != len(lookups)+1 {
+		t.Errorf("expected %d extractions, got %d", len(lookups)+1, extractions)
+	}
+
+	if _, err := fsys.Open("SAME/a.TXT"); !errors.Is(err, ErrAmbiguousName) {
+		t.Errorf("expected ErrAmbiguousName, got %v", err)
+	}
+	if _, err := fsys.Stat("docs/missing.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if info, err := fsys.Stat("DOCS"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(DOCS): expected directory, got %v (err=%v)", info, err)
+	}
+
+	entries, err := fsys.ReadDir("DOCS")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "readme.md" {
+		t.Errorf("ReadDir(DOCS): expected [readme.md], got %v (err=%v)", entries, err)
+	}
+
+	sub, err := fsys.Sub("Docs")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	if _, err := fs.ReadFile(sub, "ReadMe.md"); err != nil {
+		t.Errorf("ReadFile in sub: %v", err)
+	}
+
+	// lookups are exact unless enabled
+	exact := ArchiveFS{Stream: fsys.Stream, Format: fsys.Format}
+	if _, err := exact.Open("Docs/README.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist without CaseInsensitive, got %v", err)
+	}
+}
+
 func TestFileSystem(t *testing.T) {
 	ctx := context.Background()
 	filename := "testdata/test.zip"
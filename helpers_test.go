@@ -0,0 +1,49 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// extractEntries extracts all regular files from the archive, and
+// returns their contents or errors by name.
+func extractEntries(t *testing.T, format Extractor, data []byte) (map[string]string, map[string]error, error) {
+	t.Helper()
+	contents := make(map[string]string)
+	errs := make(map[string]error)
+	err := format.Extract(context.Background(), bytes.NewReader(data), func(ctx context.Context, file FileInfo) error {
+		if file.IsDir() {
+			return nil
+		}
+		f, err := file.Open()
+		if err != nil {
+			errs[file.NameInArchive] = err
+			return nil
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			errs[file.NameInArchive] = err
+			return nil
+		}
+		contents[file.NameInArchive] = string(b)
+		return nil
+	})
+	return contents, errs, err
+}
+
+// extractFileInfos returns the files of the archive, by name.
+func extractFileInfos(t *testing.T, format Extractor, data []byte) map[string]FileInfo {
+	t.Helper()
+	files := make(map[string]FileInfo)
+	err := format.Extract(context.Background(), bytes.NewReader(data), func(ctx context.Context, file FileInfo) error {
+		files[file.NameInArchive] = file
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,212 @@
+package archives
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// Limits restricts the resources that extracting an archive may use, to
+// guard against decompression bombs and other hostile archives. A zero
+// value for any limit means that it is not enforced.
+//
+// Sizes are checked against the sizes recorded in the archive as soon as
+// each entry is encountered, and against the number of bytes actually
+// decompressed as the entry is read through FileInfo.Open, since recorded
+// sizes cannot be trusted. When a limit is exceeded, extraction stops
+// (regardless of ContinueOnError) with a *LimitError, and reads from
+// files of the archive fail with it too.
+type Limits struct {
+	// Maximum number of entries in the archive.
+	MaxEntries int
+
+	// Maximum total uncompressed size of all entries, in bytes.
+	MaxTotalSize int64
+
+	// Maximum uncompressed size of any entry, in bytes.
+	MaxEntrySize int64
+
+	// Maximum ratio of the uncompressed size of an entry to its
+	// compressed size. This is only enforced for formats that record
+	// the compressed size of each entry, like Zip and Rar.
+	MaxCompressionRatio float64
+
+	// Maximum length of the name of an entry, in bytes.
+	MaxNameLength int
+
+	// Maximum depth of an entry's path; "a" is at depth 1,
+	// and "a/b/c" is at depth 3.
+	MaxDepth int
+}
+
+// LimitError is the error returned when an archive exceeds a limit.
+type LimitError struct {
+	Limit string // the name of the field of Limits that was exceeded
+	Entry string // the name of the entry in the archive that exceeded it
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: archive exceeds limit %s", e.Entry, e.Limit)
+}
+
+// limiter enforces Limits on the entries of one archive.
+type limiter struct {
+	limits  Limits
+	entries int
+	total   int64 // bytes charged against MaxTotalSize so far
+	err     *LimitError
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{limits: limits}
+}
+
+// check counts file against the limits, and, if the limits need it,
+// wraps file.Open so that the bytes read from the file are counted.
+// It must be called for every entry before the entry is handled.
+func (lim *limiter) check(file *FileInfo) error {
+	if lim.limits == (Limits{}) {
+		return nil
+	}
+	if lim.err != nil {
+		return lim.err
+	}
+	fail := func(limit string) error {
+		lim.err = &LimitError{Limit: limit, Entry: file.NameInArchive}
+		return lim.err
+	}
+
+	lim.entries++
+	if lim.limits.MaxEntries > 0 && lim.entries > lim.limits.MaxEntries {
+		return fail("MaxEntries")
+	}
+	if lim.limits.MaxNameLength > 0 && len(file.NameInArchive) > lim.limits.MaxNameLength {
+		return fail("MaxNameLength")
+	}
+	if lim.limits.MaxDepth > 0 && pathDepth(file.NameInArchive) > lim.limits.MaxDepth {
+		return fail("MaxDepth")
+	}
+
+	if file.IsDir() || file.Open == nil {
+		return nil
+	}
+
+	// charge the recorded size up front, then anything beyond it as it is read
+	declared := max(file.Size(), 0)
+	compressed, hasCompressed := compressedSize(*file)
+	if lim.limits.MaxEntrySize > 0 && declared > lim.limits.MaxEntrySize {
+		return fail("MaxEntrySize")
+	}
+	if lim.exceedsRatio(declared, compressed, hasCompressed) {
+		return fail("MaxCompressionRatio")
+	}
+	lim.total += declared
+	if lim.limits.MaxTotalSize > 0 && lim.total > lim.limits.MaxTotalSize {
+		return fail("MaxTotalSize")
+	}
+
+	open := file.Open
+	name := file.NameInArchive
+	file.Open = func() (fs.File, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &limitedFile{
+			File:          f,
+			lim:           lim,
+			name:          name,
+			declared:      declared,
+			compressed:    compressed,
+			hasCompressed: hasCompressed,
+		}, nil
+	}
+
+	return nil
+}
+
+func (lim *limiter) exceedsRatio(size, compressed int64, hasCompressed bool) bool {
+	if lim.limits.MaxCompressionRatio <= 0 || !hasCompressed || size == 0 {
+		return false
+	}
+	return compressed <= 0 || float64(size)/float64(compressed) > lim.limits.MaxCompressionRatio
+}
+
+// limitedFile counts the bytes read from a file in an archive.
+type limitedFile struct {
+	fs.File
+	lim           *limiter
+	name          string
+	declared      int64
+	compressed    int64
+	hasCompressed bool
+	read          int64
+}
+
+func (lf *limitedFile) Read(p []byte) (int, error) {
+	if lf.lim.err != nil {
+		return 0, lf.lim.err
+	}
+	n, err := lf.File.Read(p)
+	before := lf.read
+	lf.read += int64(n)
+
+	// only bytes beyond the recorded size haven't been charged yet
+	if lf.read > lf.declared {
+		lf.lim.total += lf.read - max(before, lf.declared)
+	}
+
+	var limit string
+	switch {
+	case lf.lim.limits.MaxEntrySize > 0 && lf.read > lf.lim.limits.MaxEntrySize:
+		limit = "MaxEntrySize"
+	case lf.lim.limits.MaxTotalSize > 0 && lf.lim.total > lf.lim.limits.MaxTotalSize:
+		limit = "MaxTotalSize"
+	case lf.lim.exceedsRatio(lf.read, lf.compressed, lf.hasCompressed):
+		limit = "MaxCompressionRatio"
+	}
+	if limit != "" {
+		lf.lim.err = &LimitError{Limit: limit, Entry: lf.name}
+		return n, lf.lim.err
+	}
+	return n, err
+}
+
+// wrapHandler returns a FileHandler that checks each file against
+// the limits before passing it to handleFile.
+func (lim *limiter) wrapHandler(handleFile FileHandler) FileHandler {
+	return func(ctx context.Context, file FileInfo) error {
+		if err := lim.check(&file); err != nil {
+			return err
+		}
+		return handleFile(ctx, file)
+	}
+}
+
+// compressedSize returns the compressed size of file, if
+// the archive format records it.
+func compressedSize(file FileInfo) (int64, bool) {
+	switch hdr := file.Header.(type) {
+	case zip.FileHeader:
+		return int64(hdr.CompressedSize64), true
+	case *zip.FileHeader:
+		return int64(hdr.CompressedSize64), true
+	case *rardecode.FileHeader:
+		return hdr.PackedSize, true
+	}
+	return 0, false
+}
+
+// pathDepth returns the number of components in the slash-separated name.
+func pathDepth(name string) int {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return 0
+	}
+	return strings.Count(name, "/")
+}
@@ -0,0 +1,130 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestLimitsTar(t *testing.T) {
+	entries := []testTarEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b/", typeflag: tar.TypeDir},
+		{name: "a/b/file.txt", body: strings.Repeat("x", 100)},
+		{name: "a/other.txt", body: strings.Repeat("y", 50)},
+	}
+
+	for _, tc := range []struct {
+		limits Limits
+		limit  string
+		entry  string
+	}{
+		{limits: Limits{MaxEntries: 3}, limit: "MaxEntries", entry: "a/other.txt"},
+		{limits: Limits{MaxNameLength: 10}, limit: "MaxNameLength", entry: "a/b/file.txt"},
+		{limits: Limits{MaxDepth: 2}, limit: "MaxDepth", entry: "a/b/file.txt"},
+		{limits: Limits{MaxEntrySize: 99}, limit: "MaxEntrySize", entry: "a/b/file.txt"},
+		{limits: Limits{MaxTotalSize: 120}, limit: "MaxTotalSize", entry: "a/other.txt"},
+		{limits: Limits{MaxEntries: 4, MaxDepth: 3, MaxEntrySize: 100, MaxTotalSize: 150}},
+	} {
+		var handled int
+		err := Tar{Limits: tc.limits}.Extract(context.Background(), makeTestTar(t, entries), func(ctx context.Context, file FileInfo) error {
+			handled++
+			return nil
+		})
+		if tc.limit == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tc.limits, err)
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%+v: expected LimitError, got %v", tc.limits, err)
+			continue
+		}
+		if limitErr.Limit != tc.limit || limitErr.Entry != tc.entry {
+			t.Errorf("%+v: expected %s on %s, got %s on %s", tc.limits, tc.limit, tc.entry, limitErr.Limit, limitErr.Entry)
+		}
+		if handled == len(entries) {
+			t.Errorf("%+v: entry exceeding limit was handled", tc.limits)
+		}
+	}
+}
+
+func TestLimitsCountDecompressedBytes(t *testing.T) {
+	// the recorded size is a lie; only the bytes read can be trusted
+	file := FileInfo{
+		FileInfo:      memFileInfo{name: "liar.txt", size: 4},
+		NameInArchive: "liar.txt",
+		Open: func() (fs.File, error) {
+			return fileInArchive{io.NopCloser(strings.NewReader(strings.Repeat("z", 1000))), nil}, nil
+		},
+	}
+	lim := newLimiter(Limits{MaxEntrySize: 500})
+	if err := lim.check(&file); err != nil {
+		t.Fatalf("recorded size is within limit, but got %v", err)
+	}
+	f, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, f)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxEntrySize" || limitErr.Entry != "liar.txt" {
+		t.Fatalf("expected MaxEntrySize error for liar.txt, got %v", err)
+	}
+	if n > 500+32*1024 {
+		t.Errorf("read %d bytes; expected reading to stop soon after the limit", n)
+	}
+	if lim.err == nil {
+		t.Error("limiter should remember that a limit was exceeded")
+	}
+}
+
+func TestLimitsZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("zeros.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 1<<20))
+	w, err = zw.Create("small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	zw.Close()
+	archive := bytes.NewReader(buf.Bytes())
+
+	// limits are enforced even when errors are otherwise tolerated
+	format := Zip{ContinueOnError: true, Limits: Limits{MaxCompressionRatio: 100}}
+	err = format.Extract(context.Background(), archive, func(ctx context.Context, file FileInfo) error {
+		t.Errorf("%s should not have been handled", file.NameInArchive)
+		return nil
+	})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxCompressionRatio" || limitErr.Entry != "zeros.bin" {
+		t.Errorf("expected MaxCompressionRatio error for zeros.bin, got %v", err)
+	}
+
+	fsys := &ArchiveFS{
+		Stream: io.NewSectionReader(archive, 0, archive.Size()),
+		Format: Zip{},
+		Limits: Limits{MaxTotalSize: 1000},
+	}
+	if _, err := fs.ReadFile(fsys, "zeros.bin"); !errors.As(err, &limitErr) || limitErr.Limit != "MaxTotalSize" {
+		t.Errorf("expected MaxTotalSize error from ArchiveFS, got %v", err)
+	}
+	fsys.Limits = Limits{MaxEntrySize: 1 << 20}
+	if data, err := fs.ReadFile(fsys, "zeros.bin"); err != nil || len(data) != 1<<20 {
+		t.Errorf("expected to read file within limits, got %d bytes (err=%v)", len(data), err)
+	}
+}
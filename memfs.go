@@ -0,0 +1,538 @@
+package archives
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory file system. It is a WritableFS, so archives can be
+// extracted into it with ExtractTo, and it is also a read-only [fs.FS] (as
+// well as an fs.ReadDirFS, fs.StatFS, and fs.ReadLinkFS), so that its
+// contents can be read back with the functions of package io/fs such as
+// [fs.WalkDir] and [fs.ReadFile].
+//
+// Symbolic links are followed when resolving names, but never outside of
+// the file system: links that are absolute or climb above its root cannot
+// be resolved. Hard links share their contents and metadata.
+//
+// The zero value is an empty file system ready to use. A MemFS is safe for
+// concurrent use, and must not be copied after first use.
+type MemFS struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // keyed by clean path; the root is "."
+}
+
+// memNode is a file, directory, or symbolic link in a MemFS.
+type memNode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte // contents of a regular file
+	target  string // target of a symbolic link
+}
+
+func (n *memNode) info(name string) memFileInfo {
+	return memFileInfo{
+		name:    path.Base(name),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+// init creates the root directory if needed. The caller must hold m.mu.
+func (m *MemFS) init() {
+	if m.nodes == nil {
+		m.nodes = map[string]*memNode{
+			".": {mode: fs.ModeDir | 0o755, modTime: time.Now()},
+		}
+	}
+}
+
+// resolve returns the key of the node for name, following any symbolic
+// links among the directories of name, and the link named by name itself
+// if follow is true. The node does not need to exist. The caller must
+// hold m.mu.
+func (m *MemFS) resolve(op, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	m.init()
+
+	resolved, rest := ".", name
+	if rest == "." {
+		rest = ""
+	}
+	for hops := 0; rest != ""; {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		next := path.Join(resolved, elem)
+
+		node := m.nodes[next]
+		if node != nil && node.mode&fs.ModeSymlink != 0 && (rest != "" || follow) {
+			if hops++; hops > 40 {
+				return "", &fs.PathError{Op: op, Path: name, Err: errTooManyLinks}
+			}
+			target := path.Join(resolved, node.target)
+			if path.IsAbs(node.target) || !fs.ValidPath(target) {
+				return "", &fs.PathError{Op: op, Path: name, Err: ErrInsecurePath}
+			}
+			if rest != "" {
+				target += "/" + rest
+			}
+			resolved, rest = ".", target
+			continue
+		}
+		if node != nil && rest != "" && !node.mode.IsDir() {
+			return "", &fs.PathError{Op: op, Path: name, Err: errNotDir}
+		}
+		resolved = next
+	}
+
+	return resolved, nil
+}
+
+// lookup resolves name and returns its node, which must exist.
+// The caller must hold m.mu.
+func (m *MemFS) lookup(op, name string, follow bool) (string, *memNode, error) {
+	key, err := m.resolve(op, name, follow)
+	if err != nil {
+		return "", nil, err
+	}
+	node, ok := m.nodes[key]
+	if !ok {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return key, node, nil
+}
+
+// prepare resolves name, which must not exist yet, and checks
+// that its parent is a directory. The caller must hold m.mu.
+func (m *MemFS) prepare(op, name string) (string, error) {
+	key, err := m.resolve(op, name, false)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := m.nodes[key]; ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+	parent, ok := m.nodes[path.Dir(key)]
+	if !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return "", &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return key, nil
+}
+
+// MkdirAll creates the directory name and any missing parents.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	if key == "." {
+		return nil
+	}
+	var dir string
+	for elem := range strings.SplitSeq(key, "/") {
+		dir = path.Join(dir, elem)
+		node, ok := m.nodes[dir]
+		if !ok {
+			m.nodes[dir] = &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+			continue
+		}
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+	}
+	return nil
+}
+
+// Create creates a new, empty file. The file's contents can be written,
+// and the returned io.WriteCloser is also an io.Seeker with a Truncate
+// method. It fails if name already exists.
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.prepare("open", name)
+	if err != nil {
+		return nil, err
+	}
+	node := &memNode{mode: 0o644, modTime: time.Now()}
+	m.nodes[key] = node
+	return &memWriter{fsys: m, node: node, name: name}, nil
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.prepare("symlink", newname)
+	if err != nil {
+		return err
+	}
+	m.nodes[key] = &memNode{mode: fs.ModeSymlink | 0o777, modTime: time.Now(), target: oldname}
+	return nil
+}
+
+// Link creates newname as a hard link to oldname. Directories
+// cannot be linked. If oldname is a symbolic link, the new
+// name links to the symbolic link, not its target.
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("link", oldname, false)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() {
+		return &fs.PathError{Op: "link", Path: oldname, Err: errIsDir}
+	}
+	key, err := m.prepare("link", newname)
+	if err != nil {
+		return err
+	}
+	m.nodes[key] = node
+	return nil
+}
+
+// Chmod changes the permission bits (including the setuid,
+// setgid, and sticky bits) of the file to those of mode.
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	const settable = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	node.mode = node.mode&^settable | mode&settable
+	return nil
+}
+
+// Chtimes changes the modification time of the file. Access times
+// are not recorded. As with os.Chtimes, a zero time is ignored.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, node, err := m.lookup("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		node.modTime = mtime
+	}
+	return nil
+}
+
+// Rename moves oldname to newname. If newname exists, it is
+// replaced, unless it is a directory.
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldKey, node, err := m.lookup("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	if oldKey == "." {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrInvalid}
+	}
+	newKey, err := m.resolve("rename", newname, false)
+	if err != nil {
+		return err
+	}
+	if newKey == oldKey {
+		return nil
+	}
+	if parent, ok := m.nodes[path.Dir(newKey)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrNotExist}
+	}
+	if existing, ok := m.nodes[newKey]; ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	if node.mode.IsDir() && strings.HasPrefix(newKey, oldKey+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+
+	if node.mode.IsDir() {
+		for key, child := range m.nodes {
+			if rest, ok := strings.CutPrefix(key, oldKey+"/"); ok {
+				delete(m.nodes, key)
+				m.nodes[newKey+"/"+rest] = child
+			}
+		}
+	}
+	delete(m.nodes, oldKey)
+	m.nodes[newKey] = node
+	return nil
+}
+
+// Remove removes the file or empty directory.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, node, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if key == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if node.mode.IsDir() && len(m.children(key)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	delete(m.nodes, key)
+	return nil
+}
+
+// Open opens the named file or directory for reading.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, node, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return &memDir{info: node.info(name), entries: m.dirEntries(key)}, nil
+	}
+	return &memFile{info: node.info(name), Reader: bytes.NewReader(bytes.Clone(node.data))}, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, node, err := m.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return m.dirEntries(key), nil
+}
+
+// Stat returns information about the file, following symbolic links.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, node, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+// Lstat returns information about the file without following
+// it if it is a symbolic link.
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, node, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+// ReadLink returns the target of the symbolic link.
+func (m *MemFS) ReadLink(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, node, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+// children returns the keys of the entries in the directory dir.
+// The caller must hold m.mu.
+func (m *MemFS) children(dir string) []string {
+	var keys []string
+	for key := range m.nodes {
+		if key != "." && path.Dir(key) == dir {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// dirEntries returns the entries of the directory dir, sorted by name.
+// The caller must hold m.mu.
+func (m *MemFS) dirEntries(dir string) []fs.DirEntry {
+	keys := m.children(dir)
+	entries := make([]fs.DirEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = fs.FileInfoToDirEntry(m.nodes[key].info(key))
+	}
+	return entries
+}
+
+// memWriter writes to a file in a MemFS.
+type memWriter struct {
+	fsys   *MemFS
+	node   *memNode
+	name   string
+	off    int64
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	if w.closed {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+	if end := w.off + int64(len(p)); end > int64(len(w.node.data)) {
+		w.node.data = append(w.node.data, make([]byte, end-int64(len(w.node.data)))...)
+	}
+	n := copy(w.node.data[w.off:], p)
+	w.off += int64(n)
+	w.node.modTime = time.Now()
+	return n, nil
+}
+
+func (w *memWriter) Seek(offset int64, whence int) (int64, error) {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += w.off
+	case io.SeekEnd:
+		offset += int64(len(w.node.data))
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: w.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: w.name, Err: fs.ErrInvalid}
+	}
+	w.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file, filling it with zeros if it grows.
+func (w *memWriter) Truncate(size int64) error {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: w.name, Err: fs.ErrInvalid}
+	}
+	if size <= int64(len(w.node.data)) {
+		w.node.data = w.node.data[:size]
+	} else {
+		w.node.data = append(w.node.data, make([]byte, size-int64(len(w.node.data)))...)
+	}
+	return nil
+}
+
+func (w *memWriter) Close() error {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	if w.closed {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+	}
+	w.closed = true
+	return nil
+}
+
+// memFileInfo describes a node in a MemFS.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+
+// memFile is a regular file in a MemFS opened for reading.
+// It reads a snapshot of the file's contents at the time
+// it was opened.
+type memFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memDir is a directory in a MemFS opened for reading.
+type memDir struct {
+	info    memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
+
+var (
+	errNotDir       = errors.New("not a directory")
+	errIsDir        = errors.New("is a directory")
+	errDirNotEmpty  = errors.New("directory not empty")
+	errTooManyLinks = errors.New("too many levels of symbolic links")
+)
+
+// Interface guards
+var (
+	_ WritableFS     = (*MemFS)(nil)
+	_ fs.ReadDirFS   = (*MemFS)(nil)
+	_ fs.StatFS      = (*MemFS)(nil)
+	_ fs.ReadLinkFS  = (*MemFS)(nil)
+	_ fs.ReadDirFile = (*memDir)(nil)
+	_ io.Seeker      = (*memWriter)(nil)
+	_ io.ReaderAt    = (*memFile)(nil)
+)
@@ -0,0 +1,172 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestExtractToMemFS(t *testing.T) {
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir, mode: 0o750},
+		{name: "dir/a.txt", body: "hello", mode: 0o600},
+		{name: "dir/sub/b.txt", body: "world"},
+		{name: "dir/link", typeflag: tar.TypeSymlink, linkname: "sub/b.txt"},
+		{name: "dir/hard", typeflag: tar.TypeLink, linkname: "dir/a.txt"},
+		{name: "../escape", body: "nope"},
+	})
+
+	var fsys MemFS
+	err := ExtractTo(context.Background(), Tar{}, archive, &fsys, &ExtractOptions{
+		Permissions:     true,
+		Times:           true,
+		ContinueOnError: true,
+	})
+	if err != nil {
+		t.Fatalf("ExtractTo: %v", err)
+	}
+
+	var names []string
+	err = fs.WalkDir(&fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	expect := []string{".", "dir", "dir/a.txt", "dir/hard", "dir/link", "dir/sub", "dir/sub/b.txt"}
+	if !slices.Equal(names, expect) {
+		t.Errorf("expected %v, got %v", expect, names)
+	}
+
+	for name, want := range map[string]string{
+		"dir/a.txt": "hello",
+		"dir/hard":  "hello",
+		"dir/link":  "world",
+	} {
+		if got, err := fs.ReadFile(&fsys, name); err != nil || string(got) != want {
+			t.Errorf("%s: expected %q, got %q (err=%v)", name, want, got, err)
+		}
+	}
+
+	if target, err := fsys.ReadLink("dir/link"); err != nil || target != "sub/b.txt" {
+		t.Errorf("expected link target sub/b.txt, got %q (err=%v)", target, err)
+	}
+	info, err := fsys.Stat("dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode())
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("expected mod time %v, got %v", want, info.ModTime())
+	}
+	if info, err := fsys.Stat("dir"); err != nil || info.Mode() != fs.ModeDir|0o750 {
+		t.Errorf("expected dir mode drwxr-x---, got %v (err=%v)", info.Mode(), err)
+	}
+
+	if err := fstest.TestFS(&fsys, "dir/a.txt", "dir/hard", "dir/link", "dir/sub/b.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractToMemFSSparse(t *testing.T) {
+	body := "data" + strings.Repeat("\x00", 3*holeBlockSize) + "end"
+	archive := makeTestTar(t, []testTarEntry{{name: "sparse", body: body}})
+
+	var fsys MemFS
+	if err := ExtractTo(context.Background(), Tar{}, archive, &fsys, &ExtractOptions{Sparse: true}); err != nil {
+		t.Fatalf("ExtractTo: %v", err)
+	}
+	if got, err := fs.ReadFile(&fsys, "sparse"); err != nil || string(got) != body {
+		t.Errorf("sparse file contents differ (err=%v)", err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	var fsys MemFS
+
+	if err := fsys.MkdirAll("a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := fsys.Create("a/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.Create("a/b/file"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("creating existing file: expected ErrExist, got %v", err)
+	}
+	if _, err := fsys.Create("missing/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("creating file in missing directory: expected ErrNotExist, got %v", err)
+	}
+	if err := fsys.MkdirAll("a/b/file/c", 0o755); err == nil {
+		t.Error("expected error creating directory under a file")
+	}
+	if err := fsys.Remove("a"); err == nil {
+		t.Error("expected error removing non-empty directory")
+	}
+
+	// names are resolved through symbolic links to directories...
+	if err := fsys.Symlink("a/b", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(&fsys, "link/file"); err != nil || string(got) != "contents" {
+		t.Errorf("reading through link: got %q (err=%v)", got, err)
+	}
+	if err := fsys.MkdirAll("link/c", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("a/b/c"); err != nil {
+		t.Errorf("directory created through link: %v", err)
+	}
+
+	// ...but never outside of the file system
+	if err := fsys.Symlink("../../outside", "a/escape"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("a/escape"); !errors.Is(err, ErrInsecurePath) {
+		t.Errorf("opening escaping link: expected ErrInsecurePath, got %v", err)
+	}
+	if err := fsys.Symlink("loop", "loop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("loop"); err == nil {
+		t.Error("expected error opening link loop")
+	}
+
+	if err := fsys.Rename("a/b", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(&fsys, "moved/file"); err != nil || string(got) != "contents" {
+		t.Errorf("reading renamed file: got %q (err=%v)", got, err)
+	}
+	if _, err := fsys.Stat("a/b/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected old name to be gone, got %v", err)
+	}
+	if err := fsys.Rename("moved", "moved/c/inside"); err == nil {
+		t.Error("expected error moving directory into itself")
+	}
+
+	for _, name := range []string{"/abs", "../up", "a//b", ""} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%q: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package archives
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NameMapper rewrites the slash-separated path of a file in an archive,
+// returning the path to use instead. If it returns an empty string, the
+// file is dropped. NameMappers can be given to ExtractToDisk, FilesFromDisk,
+// and FilesFromFS to rename files as they are extracted or archived.
+//
+// Dropping a directory only drops its own entry; the names of the files
+// within it are mapped separately.
+type NameMapper func(name string) string
+
+// StripComponents returns a NameMapper that removes the first n leading
+// components of each path, like the --strip-components flag of GNU tar.
+// Paths with n or fewer components are dropped.
+func StripComponents(n int) NameMapper {
+	return func(name string) string {
+		trimmed := strings.TrimLeft(name, "/")
+		for range n {
+			idx := strings.Index(trimmed, "/")
+			if idx < 0 {
+				return ""
+			}
+			trimmed = strings.TrimLeft(trimmed[idx+1:], "/")
+		}
+		return trimmed
+	}
+}
+
+// AddPrefix returns a NameMapper that puts each path inside the directory
+// prefix. Leading slashes are trimmed from the paths being prefixed.
+func AddPrefix(prefix string) NameMapper {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(name string) string {
+		if name == "" {
+			return ""
+		}
+		if prefix == "" {
+			return name
+		}
+		return prefix + "/" + strings.TrimLeft(name, "/")
+	}
+}
+
+// RegexpReplace returns a NameMapper that replaces all matches of re in each
+// path with repl, which may refer to submatches as in [regexp.Regexp.Expand].
+func RegexpReplace(re *regexp.Regexp, repl string) NameMapper {
+	return func(name string) string {
+		return re.ReplaceAllString(name, repl)
+	}
+}
+
+// SedTransform returns a NameMapper from a sed-style substitution expression
+// like those accepted by the --transform flag of GNU tar, for example
+// "s/^old/new/" or "s,\.txt$,.md,g". Any character may be used as the
+// delimiter. The pattern uses the syntax of package regexp (so groups are
+// written "(...)", not "\(...\)"). In the replacement, "&" stands for the
+// whole match and "\1" through "\9" for submatches. The flags "g" (replace
+// all matches rather than only the first) and "i" (match case-insensitively)
+// are supported.
+func SedTransform(expr string) (NameMapper, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return nil, fmt.Errorf("invalid transform expression %q: must start with 's' and a delimiter", expr)
+	}
+	delim := expr[1]
+	parts, err := splitSedExpr(expr[2:], delim)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform expression %q: %w", expr, err)
+	}
+	pattern, replacement, flags := parts[0], parts[1], parts[2]
+
+	var global bool
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, fmt.Errorf("invalid transform expression %q: unsupported flag %q", expr, flag)
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform expression %q: %w", expr, err)
+	}
+	template := sedReplacementToTemplate(replacement)
+
+	return func(name string) string {
+		if global {
+			return re.ReplaceAllString(name, template)
+		}
+		match := re.FindStringSubmatchIndex(name)
+		if match == nil {
+			return name
+		}
+		result := re.ExpandString(nil, template, name, match)
+		return name[:match[0]] + string(result) + name[match[1]:]
+	}, nil
+}
+
+// splitSedExpr splits the part of a substitution expression after its
+// first delimiter into the pattern, replacement, and flags. Escapes are
+// left alone so that an escaped delimiter stays literal, except that
+// escaped letters and digits used as delimiters are unescaped.
+func splitSedExpr(s string, delim byte) ([3]string, error) {
+	var parts [3]string
+	var current strings.Builder
+	var part int
+	alnum := delim >= 'a' && delim <= 'z' || delim >= 'A' && delim <= 'Z' || delim >= '0' && delim <= '9'
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim && alnum:
+			current.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			current.WriteByte(s[i])
+			current.WriteByte(s[i+1])
+			i++
+		case s[i] == delim && part < 2:
+			parts[part] = current.String()
+			current.Reset()
+			part++
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	if part != 2 {
+		return parts, fmt.Errorf("unterminated expression")
+	}
+	parts[2] = current.String()
+	return parts, nil
+}
+
+// sedReplacementToTemplate converts a sed replacement string
+// into a template for [regexp.Regexp.Expand].
+func sedReplacementToTemplate(repl string) string {
+	var sb strings.Builder
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '\\' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9':
+			sb.WriteString("${" + string(repl[i+1]) + "}")
+			i++
+		case c == '\\' && i+1 < len(repl):
+			if repl[i+1] == '$' {
+				sb.WriteString("$$")
+			} else {
+				sb.WriteByte(repl[i+1])
+			}
+			i++
+		case c == '&':
+			sb.WriteString("${0}")
+		case c == '$':
+			sb.WriteString("$$")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// ChainNameMappers returns a NameMapper that applies each of the mappers
+// in order. If any of them drops a file, the file is dropped.
+func ChainNameMappers(mappers ...NameMapper) NameMapper {
+	return func(name string) string {
+		for _, m := range mappers {
+			if name == "" {
+				break
+			}
+			name = m(name)
+		}
+		return name
+	}
+}
+
+// mapName applies mapper to name, if mapper is not nil.
+func mapName(mapper NameMapper, name string) string {
+	if mapper == nil {
+		return name
+	}
+	return mapper(name)
+}
@@ -0,0 +1,143 @@
+package archives
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestNameMappers(t *testing.T) {
+	sed := func(expr string) NameMapper {
+		m, err := SedTransform(expr)
+		if err != nil {
+			t.Fatalf("SedTransform(%q): %v", expr, err)
+		}
+		return m
+	}
+
+	for i, tc := range []struct {
+		mapper NameMapper
+		input  string
+		expect string
+	}{
+		{StripComponents(1), "top/a/b.txt", "a/b.txt"},
+		{StripComponents(2), "top/a/b.txt", "b.txt"},
+		{StripComponents(1), "/top/a/", "a/"},
+		{StripComponents(1), "top/", ""},
+		{StripComponents(3), "top/a/b.txt", ""},
+		{StripComponents(0), "top/a", "top/a"},
+		{AddPrefix("pre/"), "a/b.txt", "pre/a/b.txt"},
+		{AddPrefix("pre"), "/a", "pre/a"},
+		{RegexpReplace(regexp.MustCompile(`\.jpeg$`), ".jpg"), "x/y.jpeg", "x/y.jpg"},
+		{sed("s/^old/new/"), "old/old.txt", "new/old.txt"},
+		{sed("s/old/new/g"), "old/old.txt", "new/new.txt"},
+		{sed("s,\\.TXT$,.md,i"), "a/b.txt", "a/b.md"},
+		{sed(`s/(.*)\/(.*)/\2-&/`), "dir/file", "file-dir/file"},
+		{sed(`s|a\|b|x|`), "a|b", "x"},
+		{sed(`s/x/$1/`), "x", "$1"},
+		{sed(`s/^skip.*//`), "skip/me", ""},
+		{ChainNameMappers(StripComponents(1), AddPrefix("out")), "top/a", "out/a"},
+		{ChainNameMappers(StripComponents(1), AddPrefix("out")), "top", ""},
+	} {
+		if got := tc.mapper(tc.input); got != tc.expect {
+			t.Errorf("test %d: %q: expected %q, got %q", i, tc.input, tc.expect, got)
+		}
+	}
+
+	for _, bad := range []string{"", "x/a/b/", "s/a/b", "s/a/b/q", "s/(/x/"} {
+		if _, err := SedTransform(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestExtractToDiskNameMapper(t *testing.T) {
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "project-1.0/", typeflag: '5'},
+		{name: "project-1.0/src/main.go", body: "package main"},
+		{name: "project-1.0/src/link.go", typeflag: '1', linkname: "project-1.0/src/main.go"},
+		{name: "project-1.0/README", body: "readme"},
+	})
+	dest := t.TempDir()
+	options := &ExtractOptions{
+		NameMapper: ChainNameMappers(StripComponents(1), func(name string) string {
+			if name == "README" {
+				return ""
+			}
+			return name
+		}),
+	}
+	if err := ExtractToDisk(context.Background(), Tar{}, archive, dest, options); err != nil {
+		t.Fatalf("ExtractToDisk: %v", err)
+	}
+	for _, name := range []string{"src/main.go", "src/link.go"} {
+		if got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); err != nil || string(got) != "package main" {
+			t.Errorf("%s: expected extracted file, got %q (err=%v)", name, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "README")); err == nil {
+		t.Error("README should have been dropped")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "project-1.0")); err == nil {
+		t.Error("top-level directory should have been stripped")
+	}
+}
+
+func TestFilesFromDiskNameMapper(t *testing.T) {
+	dir := t.TempDir()
+	createDir(t, filepath.Join(dir, "docs"))
+	createFile(t, filepath.Join(dir, "docs", "a.txt"), "a")
+	createFile(t, filepath.Join(dir, "docs", "b.log"), "b")
+
+	mapper := ChainNameMappers(
+		func(name string) string {
+			if filepath.Ext(name) == ".log" {
+				return ""
+			}
+			return name
+		},
+		AddPrefix("release"),
+	)
+	files, err := FilesFromDisk(context.Background(), &FromDiskOptions{NameMapper: mapper}, map[string]string{
+		filepath.Join(dir, "docs"): "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	slices.Sort(names)
+	if expect := []string{"release/docs", "release/docs/a.txt"}; !slices.Equal(names, expect) {
+		t.Errorf("expected %v, got %v", expect, names)
+	}
+
+	files, err = FilesFromFS(context.Background(), os.DirFS(dir), &FromFSOptions{NameMapper: StripComponents(1)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = names[:0]
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	slices.Sort(names)
+	if expect := []string{"a.txt", "b.log"}; !slices.Equal(names, expect) {
+		t.Errorf("expected %v, got %v", expect, names)
+	}
+}
+
+func createDir(t *testing.T, path string) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatalf("failed to create directory %s: %v", path, err)
+	}
+}
+
+func createFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
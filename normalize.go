@@ -0,0 +1,229 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameNormalizer cleans up the names of entries as they are read from an
+// archive, so that archives made on one platform can be extracted on
+// another. The zero value leaves names exactly as they are in the archive.
+//
+// It can be set on any of the archive formats and on ArchiveFS. Names are
+// only rewritten, never validated: a normalized name may still be absolute
+// or contain ".." components, so it should be treated with the same care as
+// any other name in an archive. The original name is still available in
+// the format-specific FileInfo.Header.
+type NameNormalizer struct {
+	// Convert backslashes to forward slashes. Archivers on Windows
+	// sometimes use backslashes as path separators, but they are valid
+	// characters in file names on other platforms.
+	Separators bool
+
+	// Remove Windows drive letters ("C:"), UNC prefixes ("\\server\share")
+	// and device path prefixes ("\\?\"), and any leading separators, so
+	// that the name is relative.
+	StripVolume bool
+
+	// Convert names to Unicode Normalization Form C. Archives made on
+	// macOS often have names in decomposed form (NFD), which look the
+	// same as, but do not compare equal to, the composed names that
+	// most other systems use.
+	NFC bool
+
+	// Rewrite path components that are invalid on Windows: reserved
+	// device names like CON or aux.txt get an underscore appended to the
+	// part before the extension ("aux_.txt"), and characters that are not
+	// allowed in names (<>:"|?*\ and control characters) as well as
+	// trailing dots and spaces are replaced by underscores.
+	WindowsNames bool
+}
+
+// Normalize returns the normalized form of name, the slash-separated
+// name of an entry in an archive.
+func (n NameNormalizer) Normalize(name string) string {
+	if n.StripVolume {
+		name = stripVolume(name)
+	}
+	if n.Separators {
+		name = strings.ReplaceAll(name, `\`, "/")
+	}
+	if n.NFC {
+		name = norm.NFC.String(name)
+	}
+	if n.WindowsNames {
+		components := strings.Split(name, "/")
+		for i, component := range components {
+			components[i] = windowsName(component)
+		}
+		name = strings.Join(components, "/")
+	}
+	return name
+}
+
+// normalizeFile finishes normalizing file, whose NameInArchive must
+// already be normalized: it normalizes the target of the file if it is
+// a link, and makes the file's Name match the new name.
+//
+// Hard links point to other entries in the archive, so their targets are
+// normalized like names; symbolic links point to paths that are resolved
+// relative to the link, so only their separators and encoding are changed.
+func (n NameNormalizer) normalizeFile(file *FileInfo) {
+	if n == (NameNormalizer{}) {
+		return
+	}
+	if file.LinkTarget != "" {
+		if isSymlink(file) {
+			file.LinkTarget = NameNormalizer{Separators: n.Separators, NFC: n.NFC}.Normalize(file.LinkTarget)
+		} else {
+			file.LinkTarget = n.Normalize(file.LinkTarget)
+		}
+	}
+
+	// a trailing backslash that became a slash marks a directory,
+	// which the format could not have known
+	base := path.Base(file.NameInArchive)
+	isDir := strings.HasSuffix(file.NameInArchive, "/") && !file.IsDir()
+	if (base != file.Name() && base != "." && base != "/") || isDir {
+		file.FileInfo = normalizedFileInfo{file.FileInfo, base, isDir}
+	}
+}
+
+// normalizedFileInfo is a fs.FileInfo with the base
+// of the normalized name of a file as its name.
+type normalizedFileInfo struct {
+	fs.FileInfo
+	name  string
+	isDir bool
+}
+
+func (n normalizedFileInfo) Name() string { return n.name }
+func (n normalizedFileInfo) IsDir() bool  { return n.isDir || n.FileInfo.IsDir() }
+
+func (n normalizedFileInfo) Mode() fs.FileMode {
+	if n.isDir {
+		return fs.ModeDir | n.FileInfo.Mode().Perm() | 0o111
+	}
+	return n.FileInfo.Mode()
+}
+
+// wrapHandler returns a FileHandler that normalizes the names of files
+// before passing them to handleFile. Since the names seen by the format
+// may differ from the normalized ones, it keeps track of the directories
+// that handleFile skips by itself.
+func (n NameNormalizer) wrapHandler(handleFile FileHandler) FileHandler {
+	if n == (NameNormalizer{}) {
+		return handleFile
+	}
+
+	// important to initialize to non-nil, empty value due to how fileIsIncluded works
+	skipDirs := skipList{}
+
+	return func(ctx context.Context, file FileInfo) error {
+		file.NameInArchive = n.Normalize(file.NameInArchive)
+		n.normalizeFile(&file)
+		if fileIsIncluded(skipDirs, file.NameInArchive) {
+			return nil
+		}
+		err := handleFile(ctx, file)
+		if errors.Is(err, fs.SkipDir) && file.IsDir() {
+			skipDirs.add(file.NameInArchive)
+		}
+		return err
+	}
+}
+
+// stripVolume removes any volume name from the start of name, along with
+// the separators that follow it. Backslashes and slashes are both treated
+// as separators, except that UNC prefixes are only recognized with
+// backslashes, since a name like "//a/b" in an archive made on a Unix
+// system refers to the absolute path "/a/b", not to a network share.
+func stripVolume(name string) string {
+	isSep := func(c byte) bool { return c == '/' || c == '\\' }
+
+	// device paths: \\?\C:\dir, \\.\C:\dir, or \\?\UNC\server\share\dir
+	if len(name) >= 4 && name[0] == '\\' && name[1] == '\\' && (name[2] == '?' || name[2] == '.') && isSep(name[3]) {
+		name = name[4:]
+		if len(name) >= 4 && strings.EqualFold(name[:3], "UNC") && isSep(name[3]) {
+			name = `\\` + name[4:]
+		}
+	}
+
+	// UNC paths: \\server\share\dir
+	if strings.HasPrefix(name, `\\`) {
+		rest := name[2:]
+		for range 2 { // server, then share
+			idx := strings.IndexAny(rest, `/\`)
+			if idx < 0 {
+				return ""
+			}
+			rest = rest[idx+1:]
+		}
+		name = rest
+	}
+
+	// drive letters: C:\dir, or C:dir (relative to the current directory of the drive)
+	if len(name) >= 2 && name[1] == ':' && ('a' <= name[0]|0x20 && name[0]|0x20 <= 'z') {
+		name = name[2:]
+	}
+
+	return strings.TrimLeft(name, `/\`)
+}
+
+// windowsName returns component, a single component of a path, rewritten
+// so that it is a valid file name on Windows.
+func windowsName(component string) string {
+	if component == "" || component == "." || component == ".." {
+		return component
+	}
+
+	var sb strings.Builder
+	for _, r := range component {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) {
+			r = '_'
+		}
+		sb.WriteRune(r)
+	}
+	name := sb.String()
+
+	// Windows drops trailing dots and spaces, which would make
+	// names like "a." and "a" refer to the same file
+	trimmed := strings.TrimRight(name, ". ")
+	name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+
+	// reserved device names are reserved with any extension, and
+	// with spaces before the extension
+	stem, ext, _ := strings.Cut(name, ".")
+	if isReservedWindowsName(strings.TrimRight(stem, " ")) {
+		name = stem + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+
+	return name
+}
+
+// isReservedWindowsName returns true if stem is the name of a DOS device.
+func isReservedWindowsName(stem string) bool {
+	switch strings.ToUpper(stem) {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(stem) < 4 {
+		return false
+	}
+	if prefix := strings.ToUpper(stem[:3]); prefix != "COM" && prefix != "LPT" {
+		return false
+	}
+	switch stem[3:] {
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9", "\u00b9", "\u00b2", "\u00b3":
+		return true
+	}
+	return false
+}
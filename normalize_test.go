@@ -0,0 +1,140 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestNameNormalizer(t *testing.T) {
+	all := NameNormalizer{Separators: true, StripVolume: true, NFC: true, WindowsNames: true}
+
+	for i, tc := range []struct {
+		normalizer NameNormalizer
+		input      string
+		expect     string
+	}{
+		{NameNormalizer{}, `C:\dir\file.txt`, `C:\dir\file.txt`},
+		{NameNormalizer{Separators: true}, `dir\sub\file.txt`, "dir/sub/file.txt"},
+		{NameNormalizer{Separators: true}, `dir\`, "dir/"},
+		{NameNormalizer{StripVolume: true}, `C:\dir\file.txt`, `dir\file.txt`},
+		{NameNormalizer{StripVolume: true}, "c:dir/file.txt", "dir/file.txt"},
+		{NameNormalizer{StripVolume: true}, `\\server\share\dir\file.txt`, `dir\file.txt`},
+		{NameNormalizer{StripVolume: true}, `\\?\D:\dir\file.txt`, `dir\file.txt`},
+		{NameNormalizer{StripVolume: true}, `\\?\UNC\server\share\file.txt`, "file.txt"},
+		{NameNormalizer{StripVolume: true}, `\\server`, ""},
+		{NameNormalizer{StripVolume: true}, "/etc/passwd", "etc/passwd"},
+		{NameNormalizer{StripVolume: true}, "//a/b", "a/b"},
+		{NameNormalizer{StripVolume: true}, "1:2/file.txt", "1:2/file.txt"},
+		{NameNormalizer{NFC: true}, "cafe\u0301/re\u0301sume\u0301.txt", "caf\u00e9/r\u00e9sum\u00e9.txt"},
+		{NameNormalizer{WindowsNames: true}, "CON", "CON_"},
+		{NameNormalizer{WindowsNames: true}, "dir/aux.txt", "dir/aux_.txt"},
+		{NameNormalizer{WindowsNames: true}, "nul .tar.gz", "nul _.tar.gz"},
+		{NameNormalizer{WindowsNames: true}, "com1/lpt9.log", "com1_/lpt9_.log"},
+		{NameNormalizer{WindowsNames: true}, "com0/console.txt", "com0/console.txt"},
+		{NameNormalizer{WindowsNames: true}, `what?<now>:"x|y*\z`, "what__now___x_y__z"},
+		{NameNormalizer{WindowsNames: true}, "dir./file. .", "dir_/file___"},
+		{NameNormalizer{WindowsNames: true}, "../a/./b/", "../a/./b/"},
+		{NameNormalizer{WindowsNames: true}, "tab\there", "tab_here"},
+		{all, `C:\Users\me\Desktop\aux.txt`, "Users/me/Desktop/aux_.txt"},
+		{all, "\\\\server\\share\\Cafe\u0301 .\\", "Caf\u00e9__/"},
+	} {
+		if actual := tc.normalizer.Normalize(tc.input); actual != tc.expect {
+			t.Errorf("Test %d (%+v): %q: expected %q, got %q", i, tc.normalizer, tc.input, tc.expect, actual)
+		}
+	}
+}
+
+func TestNameNormalizerExtract(t *testing.T) {
+	normalizer := NameNormalizer{Separators: true, NFC: true}
+
+	zipData := makeTestZip(t, []string{`dir\`, `dir\cafe` + "\u0301", `dir\sub\file.txt`}, nil)
+	tarData := makeTestTar(t, []testTarEntry{
+		{name: `dir\`, typeflag: tar.TypeDir},
+		{name: `dir\cafe` + "\u0301", body: "contents"},
+		{name: `dir\sub\file.txt`, body: "contents"},
+		{name: "dir/link", typeflag: tar.TypeLink, linkname: `dir\cafe` + "\u0301"},
+	})
+
+	for _, tc := range []struct {
+		format  Extractor
+		archive io.Reader
+		expect  []string
+	}{
+		{Zip{Normalizer: normalizer}, bytes.NewReader(zipData), []string{"dir/", "dir/caf\u00e9", "dir/sub/file.txt"}},
+		{Tar{Normalizer: normalizer}, tarData, []string{"dir/", "dir/caf\u00e9", "dir/sub/file.txt", "dir/link"}},
+	} {
+		var names []string
+		err := tc.format.Extract(context.Background(), tc.archive, func(ctx context.Context, file FileInfo) error {
+			names = append(names, file.NameInArchive)
+			if file.LinkTarget != "" && file.LinkTarget != "dir/caf\u00e9" {
+				t.Errorf("%T: expected link target to be normalized, got %q", tc.format, file.LinkTarget)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%T: %v", tc.format, err)
+		}
+		if !slices.Equal(names, tc.expect) {
+			t.Errorf("%T: expected %q, got %q", tc.format, tc.expect, names)
+		}
+	}
+
+	// skipping a directory skips its contents by their normalized names
+	var names []string
+	err := Tar{Normalizer: normalizer}.Extract(context.Background(), makeTestTar(t, []testTarEntry{
+		{name: `dir\`, typeflag: tar.TypeDir},
+		{name: `dir\file.txt`, body: "contents"},
+		{name: "other.txt", body: "contents"},
+	}), func(ctx context.Context, file FileInfo) error {
+		names = append(names, file.NameInArchive)
+		if file.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"dir/", "other.txt"}; !slices.Equal(names, expect) {
+		t.Errorf("expected %q, got %q", expect, names)
+	}
+}
+
+func TestNameNormalizerArchiveFS(t *testing.T) {
+	data := makeTestZip(t, []string{`dir\`, `dir\sub\`, `dir\sub\file.txt`, `dir\aux.txt`}, nil)
+
+	fsys := &ArchiveFS{
+		Stream:     io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))),
+		Format:     Zip{},
+		Normalizer: NameNormalizer{Separators: true, WindowsNames: true},
+	}
+
+	contents, err := fs.ReadFile(fsys, "dir/sub/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != `contents of dir\sub\file.txt` {
+		t.Errorf("unexpected contents: %q", contents)
+	}
+
+	var names []string
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{".", "dir", "dir/aux_.txt", "dir/sub", "dir/sub/file.txt"}
+	if !slices.Equal(names, expect) {
+		t.Errorf("expected %q, got %q", expect, names)
+	}
+}
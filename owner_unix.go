@@ -0,0 +1,18 @@
+//go:build !windows
+
+package archives
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// sysOwner returns the user and group IDs of the owner of the file
+// that info describes, and false if they are unavailable.
+func sysOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
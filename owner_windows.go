@@ -0,0 +1,9 @@
+//go:build windows
+
+package archives
+
+import "io/fs"
+
+func sysOwner(_ fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,100 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PasswordFunc returns a password to try for an encrypted archive. It is
+// given the name of the archive, if known, and the name of the entry that
+// needs the password, or an empty string if it is needed to read the
+// archive itself, as when its headers are encrypted. The first call for a
+// given entry has attempt 1; if the password is wrong, it is called again
+// with the next attempt number, until it returns an empty password, which
+// gives up, or an error, which is returned from the operation.
+//
+// A password that has worked is tried first for the entries that follow,
+// so interactive tools are usually asked only once per archive.
+type PasswordFunc func(ctx context.Context, archiveName, entryName string, attempt int) (string, error)
+
+// passwords keeps track of the passwords to try for one archive: the
+// last one that worked, or the format's static Password, and then the
+// ones returned by its PasswordFunc.
+type passwords struct {
+	fn          PasswordFunc
+	archiveName string
+
+	mu      sync.Mutex
+	current string
+}
+
+func newPasswords(static string, fn PasswordFunc, archiveName string) *passwords {
+	return &passwords{fn: fn, archiveName: archiveName, current: static}
+}
+
+// try calls open with the current password, and then with each password
+// from the PasswordFunc, for as long as open returns an error that wraps
+// ErrWrongPassword or ErrPasswordRequired. When there are no more passwords
+// to try, the last such error is returned.
+func (p *passwords) try(ctx context.Context, entryName string, open func(password string) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := open(p.current)
+	for attempt := 1; isPasswordError(err) && p.fn != nil; attempt++ {
+		password, fnErr := p.fn(ctx, p.archiveName, entryName, attempt)
+		if fnErr != nil {
+			return fmt.Errorf("getting password: %w", fnErr)
+		}
+		if password == "" {
+			break
+		}
+		if err = open(password); err == nil {
+			p.current = password
+		}
+	}
+	return err
+}
+
+func isPasswordError(err error) bool {
+	return errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrPasswordRequired)
+}
+
+// passwordError returns ErrPasswordRequired if password is empty and
+// ErrWrongPassword otherwise, along with err, which is what the format
+// reported. It is for errors that can only be caused by the password.
+func passwordError(password string, err error) error {
+	if password == "" {
+		return fmt.Errorf("%w: %w", ErrPasswordRequired, err)
+	}
+	return fmt.Errorf("%w: %w", ErrWrongPassword, err)
+}
+
+// archiveName returns the name of the archive being read from r,
+// if it has one, like an *os.File.
+func archiveName(r io.Reader) string {
+	if named, ok := r.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+var (
+	// ErrPasswordRequired is returned (wrapped) when an archive or one of
+	// its entries is encrypted, and no password was given.
+	ErrPasswordRequired = errors.New("password required")
+
+	// ErrWrongPassword is returned (wrapped) when the password for an
+	// encrypted archive or entry is wrong. Depending on the format and
+	// the encryption method, it may not be detected until the entry has
+	// been read completely, or at all.
+	ErrWrongPassword = errors.New("wrong password")
+
+	// ErrEncryptedHeaders is returned (wrapped), along with ErrPasswordRequired
+	// or ErrWrongPassword, when the headers of an archive, which list its
+	// entries, are encrypted and can't be read.
+	ErrEncryptedHeaders = errors.New("archive headers are encrypted")
+)
@@ -0,0 +1,133 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+type passwordCall struct {
+	entryName string
+	attempt   int
+}
+
+// passwordsFunc returns a PasswordFunc that returns the given passwords
+// in order, and records how it was called.
+func passwordsFunc(calls *[]passwordCall, passwords ...string) PasswordFunc {
+	return func(ctx context.Context, archiveName, entryName string, attempt int) (string, error) {
+		*calls = append(*calls, passwordCall{entryName, attempt})
+		if len(*calls) > len(passwords) {
+			return "", nil
+		}
+		return passwords[len(*calls)-1], nil
+	}
+}
+
+func TestZipPasswordFunc(t *testing.T) {
+	data, err := os.ReadFile("testdata/encrypted.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every entry fails with the kind of error that says why
+	for password, want := range map[string]error{"": ErrPasswordRequired, "hunter3": ErrWrongPassword} {
+		_, errs, err := extractEntries(t, Zip{Password: password}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, err := range errs {
+			if !errors.Is(err, want) {
+				t.Errorf("password %q: %s: expected %v, got %v", password, name, want, err)
+			}
+		}
+	}
+
+	// the password that works is remembered for the following entries
+	var calls []passwordCall
+	contents, errs, err := extractEntries(t, Zip{PasswordFunc: passwordsFunc(&calls, "hunter3", "hunter2")}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 || len(contents) != 3 {
+		t.Errorf("expected 3 files and no errors, got %d files and %v", len(contents), errs)
+	}
+	if len(calls) != 2 || calls[0].attempt != 1 || calls[1].attempt != 2 || calls[1].entryName != calls[0].entryName {
+		t.Errorf("expected two attempts for the first entry, got %v", calls)
+	}
+
+	// giving up leaves the error in place
+	calls = nil
+	_, errs, err = extractEntries(t, Zip{PasswordFunc: passwordsFunc(&calls)}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 3 || len(calls) != 3 {
+		t.Errorf("expected 3 errors and 3 calls, got %v and %v", errs, calls)
+	}
+
+	// an error from the PasswordFunc is returned as is
+	errCanceled := errors.New("canceled by user")
+	_, errs, _ = extractEntries(t, Zip{PasswordFunc: func(context.Context, string, string, int) (string, error) {
+		return "", errCanceled
+	}}, data)
+	for name, err := range errs {
+		if !errors.Is(err, errCanceled) {
+			t.Errorf("%s: expected %v, got %v", name, errCanceled, err)
+		}
+	}
+}
+
+func TestSevenZipPasswords(t *testing.T) {
+	// copied from github.com/bodgit/sevenzip's testdata; password "password"
+	for _, tc := range []struct {
+		file             string
+		encryptedHeaders bool
+	}{
+		{"testdata/encrypted-headers.7z", true},
+		{"testdata/encrypted.7z", false},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := os.ReadFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for password, want := range map[string]error{"": ErrPasswordRequired, "wrong": ErrWrongPassword} {
+				_, errs, err := extractEntries(t, SevenZip{Password: password}, data)
+				if tc.encryptedHeaders {
+					if !errors.Is(err, want) || !errors.Is(err, ErrEncryptedHeaders) {
+						t.Errorf("password %q: expected %v with encrypted headers, got %v", password, want, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(errs) == 0 {
+					t.Errorf("password %q: expected errors", password)
+				}
+				for name, err := range errs {
+					if !errors.Is(err, want) {
+						t.Errorf("password %q: %s: expected %v, got %v", password, name, want, err)
+					}
+				}
+			}
+
+			var calls []passwordCall
+			contents, errs, err := extractEntries(t, SevenZip{PasswordFunc: passwordsFunc(&calls, "wrong", "password")}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) > 0 || len(contents) == 0 {
+				t.Errorf("expected files and no errors, got %d files and %v", len(contents), errs)
+			}
+			if len(calls) != 2 {
+				t.Fatalf("expected 2 calls, got %v", calls)
+			}
+			if tc.encryptedHeaders != (calls[0].entryName == "") {
+				t.Errorf("expected entry name to be empty only for encrypted headers, got %q", calls[0].entryName)
+			}
+		})
+	}
+}
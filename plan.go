@@ -0,0 +1,250 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ExtractionPlan describes what ExtractToDisk would do with an archive,
+// without writing anything. Paths are slash-separated and relative to
+// the destination directory.
+type ExtractionPlan struct {
+	// Entries that would be written to paths that do not exist
+	// yet, including directories. With ConflictRename, renamed
+	// entries are listed here under their new paths.
+	Create []PlannedEntry
+
+	// Entries that would replace files that already exist.
+	Overwrite []PlannedEntry
+
+	// Entries that would be skipped because of the conflict policy.
+	Skip []PlannedEntry
+
+	// Entries that would not be extracted because of an error.
+	Rejected []RejectedEntry
+
+	// The total uncompressed size, in bytes, of the files that
+	// would be written.
+	TotalSize int64
+
+	// The number of bytes available to an unprivileged user on the
+	// file system of the destination directory, or -1 if it could
+	// not be determined.
+	FreeSpace int64
+}
+
+// PlannedEntry is an entry of an archive that would be written.
+type PlannedEntry struct {
+	Name string      // name of the entry in the archive
+	Path string      // path the entry would be written to
+	Size int64       // uncompressed size of the entry
+	Mode fs.FileMode // mode (and type) of the entry
+}
+
+// RejectedEntry is an entry of an archive that would not be extracted.
+type RejectedEntry struct {
+	Name string // name of the entry in the archive
+	Err  error  // why the entry would be rejected
+}
+
+// HasEnoughSpace reports whether the free space at the destination is
+// known to be enough for the files that would be written. Note that file
+// systems use some additional space for each file, so an extraction may
+// still run out of space if the margin is small.
+func (p *ExtractionPlan) HasEnoughSpace() bool {
+	return p.FreeSpace >= 0 && p.TotalSize <= p.FreeSpace
+}
+
+// PlanExtraction walks the archive using format and reports what
+// ExtractToDisk would do when extracting it into destDir with the same
+// options, without creating or changing anything. Entries that would
+// be refused for security reasons (see ExtractToDisk) are rejected, as
+// are files with the same name as an earlier entry (ErrDuplicateName) and
+// entries whose names differ only in case from an earlier entry, since
+// they would collide on case-insensitive file systems (ErrCaseCollision).
+//
+// File contents are never opened, so for formats that have a central
+// directory, such as Zip and SevenZip, the archive is not decompressed.
+// Other formats, like Tar, may still need to read through the archive.
+func PlanExtraction(ctx context.Context, format Extractor, archive io.Reader, destDir string, options *ExtractOptions) (*ExtractionPlan, error) {
+	if options == nil {
+		options = new(ExtractOptions)
+	}
+
+	// stat the destination through a root, so that symbolic links
+	// are resolved just as ExtractToDisk would resolve them; if the
+	// destination does not exist yet, everything would be created
+	var fsys WritableFS = new(MemFS)
+	if root, err := os.OpenRoot(destDir); err == nil {
+		defer root.Close()
+		fsys = RootFS{Root: root}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("opening destination directory: %w", err)
+	}
+
+	pl := &planner{
+		fe:       &fsExtractor{fsys: fsys, options: options},
+		plan:     &ExtractionPlan{FreeSpace: -1},
+		written:  make(map[string]string),
+		folded:   make(map[string]string),
+		symlinks: make(map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+
+	err := format.Extract(ctx, archive, func(ctx context.Context, file FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err // honor context cancellation
+		}
+		if err := pl.planFile(file); err != nil {
+			pl.plan.Rejected = append(pl.plan.Rejected, RejectedEntry{Name: file.NameInArchive, Err: err})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if free, err := freeSpace(existingAncestor(destDir)); err == nil {
+		pl.plan.FreeSpace = free
+	}
+
+	return pl.plan, nil
+}
+
+// planner builds an ExtractionPlan.
+type planner struct {
+	fe   *fsExtractor
+	plan *ExtractionPlan
+
+	// the names of entries seen so far, keyed by path (written) and by
+	// case-folded path (folded), mapped to their paths
+	written map[string]string
+	folded  map[string]string
+
+	// the paths of the symbolic links and directories planned so far
+	symlinks map[string]bool
+	dirs     map[string]bool
+}
+
+// isSymlink reports whether name is a symbolic link in the destination
+// or will be one after the entries planned so far are extracted.
+func (pl *planner) isSymlink(name string) bool {
+	return pl.symlinks[name] || pl.fe.isSymlink(name)
+}
+
+// isDir reports whether name is a directory in the destination, and not
+// a symbolic link to one, or will be one after the entries planned so
+// far are extracted.
+func (pl *planner) isDir(name string) bool {
+	return !pl.isSymlink(name) && (pl.dirs[name] || pl.fe.isDir(name))
+}
+
+func (pl *planner) planFile(file FileInfo) error {
+	nameInArchive := file.NameInArchive
+	name, skip, err := pl.fe.entryPath(&file)
+	if err != nil || skip {
+		return err
+	}
+
+	folded := foldName(name)
+	if other, ok := pl.folded[folded]; ok && other != name {
+		return fmt.Errorf("%s and %s: %w", other, name, ErrCaseCollision)
+	}
+	pl.folded[folded] = name
+
+	// the directories the entry is in are created for it
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		pl.dirs[dir] = true
+	}
+
+	entry := PlannedEntry{Name: nameInArchive, Path: name, Mode: file.Mode()}
+
+	if file.IsDir() {
+		if _, seen := pl.written[name]; seen {
+			return nil // directories are merged
+		}
+		pl.written[name] = nameInArchive
+		pl.dirs[name] = true
+		if _, err := pl.fe.fsys.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			pl.plan.Create = append(pl.plan.Create, entry)
+		}
+		return nil
+	}
+
+	switch {
+	case isSymlink(file):
+		if err := checkSymlinkTarget(file.NameInArchive, file.LinkTarget); err != nil {
+			return err
+		}
+		if err := checkLinkedSymlink(name, file.LinkTarget, pl.isSymlink, pl.isDir); err != nil {
+			return err
+		}
+		pl.symlinks[name] = true
+	case file.LinkTarget != "":
+		if _, err := localPath(file.LinkTarget); err != nil {
+			return fmt.Errorf("hard link target: %w", err)
+		}
+	case file.Mode().IsRegular():
+		entry.Size = file.Size()
+	case file.Mode()&(fs.ModeDevice|fs.ModeNamedPipe) != 0 && pl.fe.options.SpecialFiles:
+	default:
+		return nil // not extracted
+	}
+
+	if first, seen := pl.written[name]; seen {
+		return fmt.Errorf("%s: same path as %s: %w", name, first, ErrDuplicateName)
+	}
+	pl.written[name] = nameInArchive
+
+	dest, skip, err := pl.fe.resolveConflict(name, file)
+	switch {
+	case err != nil:
+		return err
+	case skip:
+		pl.plan.Skip = append(pl.plan.Skip, entry)
+		return nil
+	case dest != name:
+		entry.Path = dest
+		pl.plan.Create = append(pl.plan.Create, entry)
+	default:
+		if _, err := pl.fe.fsys.Lstat(name); err == nil {
+			pl.plan.Overwrite = append(pl.plan.Overwrite, entry)
+		} else {
+			pl.plan.Create = append(pl.plan.Create, entry)
+		}
+	}
+	pl.plan.TotalSize += entry.Size
+
+	return nil
+}
+
+// existingAncestor returns dir or its closest ancestor that exists.
+func existingAncestor(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+var (
+	// ErrDuplicateName is returned (wrapped) when an archive
+	// contains more than one file with the same name.
+	ErrDuplicateName = errors.New("duplicate name")
+
+	// ErrCaseCollision is returned (wrapped) when names in an
+	// archive differ only in case or Unicode normalization.
+	ErrCaseCollision = errors.New("names differ only in case")
+)
@@ -0,0 +1,140 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestPlanExtraction(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "existing.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a.txt", body: "hello"},
+		{name: "existing.txt", body: "new!"},
+		{name: "../escape", body: "nope"},
+		{name: "dir/link", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"},
+		{name: "dir/a.txt", body: "again"},
+		{name: "DIR/A.TXT", body: "shout"},
+		{name: "dir/", typeflag: tar.TypeDir},
+	})
+
+	plan, err := PlanExtraction(context.Background(), Tar{}, archive, dest, nil)
+	if err != nil {
+		t.Fatalf("PlanExtraction: %v", err)
+	}
+
+	var created, overwritten []string
+	for _, e := range plan.Create {
+		created = append(created, e.Path)
+	}
+	for _, e := range plan.Overwrite {
+		overwritten = append(overwritten, e.Path)
+	}
+	if expect := []string{"dir", "dir/a.txt"}; !slices.Equal(created, expect) {
+		t.Errorf("expected to create %v, got %v", expect, created)
+	}
+	if expect := []string{"existing.txt"}; !slices.Equal(overwritten, expect) {
+		t.Errorf("expected to overwrite %v, got %v", expect, overwritten)
+	}
+	if plan.TotalSize != 9 {
+		t.Errorf("expected total size 9, got %d", plan.TotalSize)
+	}
+
+	expectRejected := []struct {
+		name string
+		err  error
+	}{
+		{"../escape", ErrInsecurePath},
+		{"dir/link", ErrInsecurePath},
+		{"dir/a.txt", ErrDuplicateName},
+		{"DIR/A.TXT", ErrCaseCollision},
+	}
+	if len(plan.Rejected) != len(expectRejected) {
+		t.Fatalf("expected %d rejected entries, got %v", len(expectRejected), plan.Rejected)
+	}
+	for i, e := range expectRejected {
+		if got := plan.Rejected[i]; got.Name != e.name || !errors.Is(got.Err, e.err) {
+			t.Errorf("rejected entry %d: expected %s (%v), got %s (%v)", i, e.name, e.err, got.Name, got.Err)
+		}
+	}
+
+	if runtime.GOOS == "linux" && (plan.FreeSpace <= 0 || !plan.HasEnoughSpace()) {
+		t.Errorf("expected free space to be known, got %d", plan.FreeSpace)
+	}
+
+	// nothing should have been written
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected destination to be untouched, found %d entries", len(entries))
+	}
+}
+
+func TestPlanExtractionConflictPolicy(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "a.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy                  ConflictPolicy
+		create, overwrite, skip int
+		rejected                int
+		createPath              string
+	}{
+		{policy: ConflictOverwrite, overwrite: 1},
+		{policy: ConflictSkip, skip: 1},
+		{policy: ConflictRename, create: 1, createPath: "a (1).txt"},
+		{policy: ConflictFail, rejected: 1},
+	} {
+		archive := makeTestTar(t, []testTarEntry{{name: "a.txt", body: "new"}})
+		plan, err := PlanExtraction(context.Background(), Tar{}, archive, dest, &ExtractOptions{Conflict: tc.policy})
+		if err != nil {
+			t.Fatalf("policy %d: %v", tc.policy, err)
+		}
+		if len(plan.Create) != tc.create || len(plan.Overwrite) != tc.overwrite ||
+			len(plan.Skip) != tc.skip || len(plan.Rejected) != tc.rejected {
+			t.Errorf("policy %d: unexpected plan %+v", tc.policy, plan)
+		}
+		if tc.createPath != "" && plan.Create[0].Path != tc.createPath {
+			t.Errorf("policy %d: expected path %q, got %q", tc.policy, tc.createPath, plan.Create[0].Path)
+		}
+	}
+
+	// a destination that does not exist yet is fine too
+	archive := makeTestTar(t, []testTarEntry{{name: "a.txt", body: "new"}})
+	plan, err := PlanExtraction(context.Background(), Tar{}, archive, filepath.Join(dest, "new", "dir"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Create) != 1 {
+		t.Errorf("expected 1 file to be created, got %+v", plan)
+	}
+}
+
+func TestPlanExtractionNormalizationCollision(t *testing.T) {
+	// the same name, composed and decomposed, as extraction folds them
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "café.txt", body: "nfc"},
+		{name: "Café.txt", body: "nfd"},
+	})
+	plan, err := PlanExtraction(context.Background(), Tar{}, archive, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Rejected) != 1 || !errors.Is(plan.Rejected[0].Err, ErrCaseCollision) {
+		t.Errorf("expected a collision, got %v", plan.Rejected)
+	}
+}
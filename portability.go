@@ -0,0 +1,183 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PortabilityIssue is a problem that would keep an entry of an archive
+// from being extracted the same way on every platform.
+type PortabilityIssue struct {
+	Name string // name of the entry in the archive
+	Err  error  // what is wrong with it
+}
+
+func (p PortabilityIssue) String() string {
+	return fmt.Sprintf("%s: %v", p.Name, p.Err)
+}
+
+// CheckPortability checks whether the files, as they would be given to the
+// Archive method of format, would extract cleanly on Linux, macOS, and
+// Windows. It reports:
+//
+//   - names that differ only in case or Unicode normalization, which
+//     collide on case-insensitive file systems (ErrCaseCollision)
+//   - names that are reserved on Windows, like CON or aux.txt (ErrReservedName)
+//   - names with characters that are not allowed on Windows, like ':', '*',
+//     and '?', or that end with a dot or a space (ErrInvalidName)
+//   - paths longer than 260 characters, the traditional limit on Windows
+//     (ErrPathTooLong)
+//   - names that are not valid UTF-8 (ErrNonUTF8Name)
+//   - symbolic links that point outside of the archive's tree (ErrInsecurePath)
+//   - for zip archives, modification times that cannot be stored as MS-DOS
+//     timestamps, which only cover the years 1980 to 2107 (ErrTimeOutOfRange)
+//
+// If format is nil, all checks are done. Issues are returned in the order of
+// the files; files may have more than one issue.
+func CheckPortability(format Format, files []FileInfo) []PortabilityIssue {
+	pc := newPortabilityChecker(format)
+	for _, file := range files {
+		pc.check(file)
+	}
+	return pc.issues
+}
+
+// CheckArchivePortability is like CheckPortability, but checks the entries
+// of an existing archive, which is read using format. File contents are
+// never opened.
+func CheckArchivePortability(ctx context.Context, format Extractor, archive io.Reader) ([]PortabilityIssue, error) {
+	f, _ := format.(Format)
+	pc := newPortabilityChecker(f)
+	err := format.Extract(ctx, archive, func(ctx context.Context, file FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err // honor context cancellation
+		}
+		pc.check(file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pc.issues, nil
+}
+
+// portabilityChecker accumulates the portability issues of a list of files.
+type portabilityChecker struct {
+	dosTimes bool
+	issues   []PortabilityIssue
+
+	// paths seen so far, including the directories they are in,
+	// keyed by their folded form (see foldName), and the paths
+	// that have been reported as colliding with them
+	folded   map[string]string
+	reported map[string]bool
+}
+
+func newPortabilityChecker(format Format) *portabilityChecker {
+	pc := &portabilityChecker{
+		folded:   make(map[string]string),
+		reported: make(map[string]bool),
+	}
+	switch format.(type) {
+	case nil, Zip, *Zip:
+		pc.dosTimes = true
+	}
+	return pc
+}
+
+func (pc *portabilityChecker) check(file FileInfo) {
+	nameInArchive := file.NameInArchive
+	if nameInArchive == "" {
+		nameInArchive = file.Name() // same default as the formats
+	}
+	report := func(err error) {
+		pc.issues = append(pc.issues, PortabilityIssue{Name: nameInArchive, Err: err})
+	}
+
+	name := path.Clean(nameInArchive)
+	if name == "." || name == "/" {
+		return
+	}
+
+	if !utf8.ValidString(name) {
+		report(fmt.Errorf("%q: %w", name, ErrNonUTF8Name))
+	}
+
+	if n := len(utf16.Encode([]rune(name))); n > maxPortablePathLength {
+		report(fmt.Errorf("%d characters: %w", n, ErrPathTooLong))
+	}
+
+	// check each component, and each path leading to the file
+	// for collisions; a collision in a directory name is reported
+	// once, for the first entry that is in the other directory
+	components := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, component := range components {
+		if component == ".." {
+			continue
+		}
+		stem, _, _ := strings.Cut(component, ".")
+		switch {
+		case isReservedWindowsName(strings.TrimRight(stem, " ")):
+			report(fmt.Errorf("%q: %w", component, ErrReservedName))
+		case utf8.ValidString(component) && windowsName(component) != component:
+			report(fmt.Errorf("%q: %w", component, ErrInvalidName))
+		}
+
+		prefix := strings.Join(components[:i+1], "/")
+		folded := foldName(prefix)
+		if other, ok := pc.folded[folded]; !ok {
+			pc.folded[folded] = prefix
+		} else if other != prefix && !pc.reported[prefix] {
+			report(fmt.Errorf("%s and %s: %w", other, prefix, ErrCaseCollision))
+			pc.reported[prefix] = true
+		}
+	}
+
+	if isSymlink(file) && symlinkEscapes(name, file.LinkTarget) {
+		report(fmt.Errorf("symlink target %q: %w", file.LinkTarget, ErrInsecurePath))
+	}
+
+	if pc.dosTimes && !file.ModTime().IsZero() {
+		if year := file.ModTime().Year(); year < 1980 || year > 2107 {
+			report(fmt.Errorf("modification time %s: %w", file.ModTime(), ErrTimeOutOfRange))
+		}
+	}
+}
+
+// symlinkEscapes returns true if the target of the symbolic link name is
+// absolute, on any platform, or resolves to a path outside of the tree.
+func symlinkEscapes(name, target string) bool {
+	target = strings.ReplaceAll(target, `\`, "/")
+	if path.IsAbs(target) || stripVolume(target) != target {
+		return true
+	}
+	resolved := path.Join(path.Dir(name), target)
+	return resolved == ".." || strings.HasPrefix(resolved, "../") || path.IsAbs(resolved)
+}
+
+// maxPortablePathLength is the traditional maximum length of
+// a path on Windows (MAX_PATH), in UTF-16 code units.
+const maxPortablePathLength = 260
+
+var (
+	// ErrReservedName is returned (wrapped) for names that are reserved on Windows.
+	ErrReservedName = errors.New("name is reserved on Windows")
+
+	// ErrInvalidName is returned (wrapped) for names that are not allowed on Windows.
+	ErrInvalidName = errors.New("name is not valid on Windows")
+
+	// ErrPathTooLong is returned (wrapped) for paths longer than Windows traditionally allows.
+	ErrPathTooLong = errors.New("path is too long for Windows")
+
+	// ErrNonUTF8Name is returned (wrapped) for names that are not valid UTF-8.
+	ErrNonUTF8Name = errors.New("name is not valid UTF-8")
+
+	// ErrTimeOutOfRange is returned (wrapped) for times that a format cannot store.
+	ErrTimeOutOfRange = errors.New("time out of range")
+)
@@ -0,0 +1,93 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPortability(t *testing.T) {
+	file := func(name string, typeflag byte, linkTarget string, modTime time.Time) FileInfo {
+		hdr := &tar.Header{Name: name, Typeflag: typeflag, Linkname: linkTarget, ModTime: modTime}
+		return FileInfo{FileInfo: hdr.FileInfo(), NameInArchive: name, LinkTarget: linkTarget}
+	}
+	ok := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []FileInfo{
+		file("Docs/", tar.TypeDir, "", ok),
+		file("Docs/readme.md", tar.TypeReg, "", ok),
+		file("docs/README.md", tar.TypeReg, "", ok),
+		file("docs/other.md", tar.TypeReg, "", ok),
+		file("src/aux.go", tar.TypeReg, "", ok),
+		file("src/what?.txt", tar.TypeReg, "", ok),
+		file("src/trailing.", tar.TypeReg, "", ok),
+		file("src/"+strings.Repeat("x", 300), tar.TypeReg, "", ok),
+		file("src/\xff\xfe", tar.TypeReg, "", ok),
+		file("src/link", tar.TypeSymlink, "../../etc/passwd", ok),
+		file("src/abs", tar.TypeSymlink, `C:\Windows`, ok),
+		file("src/fine", tar.TypeSymlink, "../Docs/readme.md", ok),
+		file("src/old.txt", tar.TypeReg, "", time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)),
+		file("src/fine.txt", tar.TypeReg, "", ok),
+	}
+
+	type issue struct {
+		name string
+		err  error
+	}
+	expectAll := []issue{
+		{"docs/README.md", ErrCaseCollision}, // docs vs Docs
+		{"docs/README.md", ErrCaseCollision}, // README.md vs readme.md
+		{"src/aux.go", ErrReservedName},
+		{"src/what?.txt", ErrInvalidName},
+		{"src/trailing.", ErrInvalidName},
+		{"src/" + strings.Repeat("x", 300), ErrPathTooLong},
+		{"src/\xff\xfe", ErrNonUTF8Name},
+		{"src/link", ErrInsecurePath},
+		{"src/abs", ErrInsecurePath},
+		{"src/old.txt", ErrTimeOutOfRange},
+	}
+
+	for _, tc := range []struct {
+		format Format
+		expect []issue
+	}{
+		{nil, expectAll},
+		{Zip{}, expectAll},
+		{Tar{}, expectAll[:len(expectAll)-1]},
+	} {
+		issues := CheckPortability(tc.format, files)
+		if len(issues) != len(tc.expect) {
+			t.Errorf("%T: expected %d issues, got %d: %v", tc.format, len(tc.expect), len(issues), issues)
+			continue
+		}
+		for i, want := range tc.expect {
+			if issues[i].Name != want.name || !errors.Is(issues[i].Err, want.err) {
+				t.Errorf("%T: issue %d: expected %s: %v, got %v", tc.format, i, want.name, want.err, issues[i])
+			}
+		}
+	}
+}
+
+func TestCheckArchivePortability(t *testing.T) {
+	archive := makeTestTar(t, []testTarEntry{
+		{name: "a/CON", body: "x"},
+		{name: "a/b.txt", body: "x"},
+		{name: "A/B.txt", body: "x"},
+		{name: "a/link", typeflag: tar.TypeSymlink, linkname: "../.."},
+	})
+	issues, err := CheckArchivePortability(context.Background(), Tar{}, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []error{ErrReservedName, ErrCaseCollision, ErrCaseCollision, ErrInsecurePath}
+	if len(issues) != len(expect) {
+		t.Fatalf("expected %d issues, got %v", len(expect), issues)
+	}
+	for i, want := range expect {
+		if !errors.Is(issues[i].Err, want) {
+			t.Errorf("issue %d: expected %v, got %v", i, want, issues[i])
+		}
+	}
+}
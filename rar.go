@@ -1,6 +1,7 @@
 package archives
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -32,9 +33,23 @@ type Rar struct {
 	// operation will continue on remaining files.
 	ContinueOnError bool
 
+	// Limits on the resources that extraction may use, to guard
+	// against malicious archives. By default, there are no limits.
+	Limits Limits
+
+	// How to normalize the names of files as they are read from
+	// the archive. By default, names are left exactly as they are.
+	Normalizer NameNormalizer
+
 	// Password to open archives.
 	Password string
 
+	// If set, called for more passwords to try when Password, or the
+	// last password that worked, is wrong. See PasswordFunc. Since RAR
+	// archives are read as a stream, it is only used if the archive is
+	// opened by Name or the reader given to Extract is an io.Seeker.
+	PasswordFunc PasswordFunc
+
 	// Name for a multi-volume archive. When Name is specified,
 	// the named file is extracted (rather than any io.Reader that
 	// may be passed to Extract). If the archive is a multi-volume
@@ -83,55 +98,56 @@ func (r Rar) Match(_ context.Context, filename string, stream io.Reader) (MatchR
 // Archive is not implemented for RAR because it is patent-encumbered.
 
 func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
-	var options []rardecode.Option
-	if r.Password != "" {
-		options = append(options, rardecode.Password(r.Password))
+	src := &rarSource{r: r, sourceArchive: sourceArchive, start: -1}
+	if r.Name == "" {
+		if seeker, ok := sourceArchive.(io.Seeker); ok {
+			if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+				src.start = offset
+			}
+		}
 	}
+	defer src.close()
 
-	if r.FS != nil {
-		options = append(options, rardecode.FileSystem(r.FS))
+	// RAR archives are read as a stream, so they can only be read again
+	// with another password if they can be opened again
+	var passwordFunc PasswordFunc
+	if r.Name != "" || src.start >= 0 {
+		passwordFunc = r.PasswordFunc
 	}
-
-	var (
-		rr  rarReader
-		err error
-	)
-
-	// If a name has been provided, then the sourceArchive stream is ignored
-	// and the archive is opened directly via the filesystem (or provided FS).
-	if r.Name != "" {
-		var or *rardecode.ReadCloser
-		if or, err = rardecode.OpenReader(r.Name, options...); err == nil {
-			rr = or
-			defer or.Close()
-		}
-	} else {
-		rr, err = rardecode.NewReader(sourceArchive, options...)
-	}
-	if err != nil {
-		return err
+	name := r.Name
+	if name == "" {
+		name = archiveName(sourceArchive)
 	}
+	passwords := newPasswords(r.Password, passwordFunc, name)
+
+	lim := newLimiter(r.Limits)
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
 
-	for {
+	// the first header is read right away, since it is what fails if the
+	// headers of the archive are encrypted
+	firstErr := passwords.try(ctx, "", func(password string) error {
+		return src.open(password, 0)
+	})
+	for err := firstErr; ; err = src.next() {
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
 
-		hdr, err := rr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			if r.ContinueOnError {
+			if r.ContinueOnError && src.rr != nil {
 				log.Printf("[ERROR] Advancing to next file in rar archive: %v", err)
 				continue
 			}
 			return err
 		}
-		if fileIsIncluded(skipDirs, hdr.Name) {
+		hdr, entry := src.hdr, src.entry
+		name := r.Normalizer.Normalize(hdr.Name)
+		if fileIsIncluded(skipDirs, name) {
 			continue
 		}
 
@@ -139,17 +155,41 @@ func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		file := FileInfo{
 			FileInfo:      info,
 			Header:        hdr,
-			NameInArchive: hdr.Name,
+			NameInArchive: name,
+			Encrypted:     hdr.Encrypted,
 			Open: func() (fs.File, error) {
-				return fileInArchive{io.NopCloser(rr), info}, nil
+				if entry != src.entry {
+					return nil, fmt.Errorf("rar archives can only be read sequentially; %s is no longer available", hdr.Name)
+				}
+				if hdr.Encrypted && !hdr.IsDir {
+					err := passwords.try(ctx, hdr.Name, func(password string) error {
+						if password != src.password {
+							if err := src.open(password, entry); err != nil {
+								return err
+							}
+						}
+						return src.peek()
+					})
+					if err != nil {
+						return nil, err
+					}
+				}
+				return fileInArchive{io.NopCloser(src), info}, nil
 			},
 		}
+		r.Normalizer.normalizeFile(&file)
+		if err := lim.check(&file); err != nil {
+			return err
+		}
 
 		err = handleFile(ctx, file)
+		if lim.err != nil {
+			return lim.err
+		}
 		if errors.Is(err, fs.SkipAll) {
 			break
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
-			skipDirs.add(hdr.Name)
+			skipDirs.add(name)
 		} else if err != nil {
 			return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
 		}
@@ -158,6 +198,130 @@ func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 	return nil
 }
 
+// rarSource reads a RAR archive with a given password, and can open
+// it again with another one if it was opened by name or is seekable.
+type rarSource struct {
+	r             Rar
+	sourceArchive io.Reader
+	start         int64 // offset of the archive in sourceArchive, or -1 if not seekable
+
+	password string
+	rr       rarReader
+	closer   io.Closer
+	hdr      *rardecode.FileHeader
+	entry    int           // index of hdr in the archive
+	br       *bufio.Reader // for reading the current entry, once peeked
+}
+
+// open opens the archive with password and advances it to the
+// given entry.
+func (s *rarSource) open(password string, entry int) error {
+	var options []rardecode.Option
+	if password != "" {
+		options = append(options, rardecode.Password(password))
+	}
+	if s.r.FS != nil {
+		options = append(options, rardecode.FileSystem(s.r.FS))
+	}
+
+	s.close()
+	var err error
+	// If a name has been provided, then the sourceArchive stream is ignored
+	// and the archive is opened directly via the filesystem (or provided FS).
+	if s.r.Name != "" {
+		var or *rardecode.ReadCloser
+		if or, err = rardecode.OpenReader(s.r.Name, options...); err == nil {
+			s.rr, s.closer = or, or
+		}
+	} else {
+		if s.rr != nil {
+			if s.start < 0 {
+				return fmt.Errorf("rar archive can't be read again: source is not seekable")
+			}
+			if _, err := s.sourceArchive.(io.Seeker).Seek(s.start, io.SeekStart); err != nil {
+				return fmt.Errorf("seeking to start of rar archive: %w", err)
+			}
+		}
+		s.rr, err = rardecode.NewReader(s.sourceArchive, options...)
+	}
+	s.password = password
+	if err != nil {
+		s.rr = nil
+		return rarHeaderError(password, err)
+	}
+
+	s.entry = -1
+	for s.entry < entry {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next advances to the next entry in the archive.
+func (s *rarSource) next() error {
+	hdr, err := s.rr.Next()
+	if err != nil {
+		return rarHeaderError(s.password, err)
+	}
+	s.hdr, s.br = hdr, nil
+	s.entry++
+	return nil
+}
+
+// peek reads the start of the current entry, which is when
+// rardecode reports missing or wrong passwords for it.
+func (s *rarSource) peek() error {
+	if s.br == nil {
+		s.br = bufio.NewReader(s.rr)
+	}
+	if _, err := s.br.Peek(1); err != nil && err != io.EOF {
+		return rarPasswordError(s.password, err)
+	}
+	return nil
+}
+
+func (s *rarSource) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if s.br != nil {
+		n, err = s.br.Read(p)
+	} else {
+		n, err = s.rr.Read(p)
+	}
+	return n, rarPasswordError(s.password, err)
+}
+
+func (s *rarSource) close() {
+	if s.closer != nil {
+		s.closer.Close()
+		s.closer = nil
+	}
+}
+
+// rarPasswordError returns err, or if it is one of rardecode's
+// errors about encryption, the corresponding password error.
+func rarPasswordError(password string, err error) error {
+	switch {
+	case errors.Is(err, rardecode.ErrArchiveEncrypted):
+		return fmt.Errorf("%w: %w", ErrEncryptedHeaders, passwordError(password, err))
+	case errors.Is(err, rardecode.ErrArchivedFileEncrypted),
+		errors.Is(err, rardecode.ErrBadPassword):
+		return passwordError(password, err)
+	}
+	return err
+}
+
+// rarHeaderError is like rarPasswordError, for errors reading headers,
+// which are only checked against the password if they are encrypted.
+func rarHeaderError(password string, err error) error {
+	if errors.Is(err, rardecode.ErrBadPassword) {
+		return fmt.Errorf("%w: %w", ErrEncryptedHeaders, passwordError(password, err))
+	}
+	return rarPasswordError(password, err)
+}
+
 // rarFileInfo satisfies the fs.FileInfo interface for RAR entries.
 type rarFileInfo struct {
 	fh *rardecode.FileHeader
@@ -0,0 +1,364 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sparseEntry is a region of a file: either a region
+// containing data, or a hole, depending on the context.
+type sparseEntry struct {
+	Offset, Length int64
+}
+
+// sparseData returns the data regions of file, which is expected to be
+// open for reading, if it is a regular file on disk with holes in it.
+// It returns nil if the file has no holes or if holes cannot be
+// detected on this platform or file system. The read offset of the
+// file is undefined when this returns.
+func sparseData(file fs.File, size int64) ([]sparseEntry, error) {
+	regions, err := dataRegions(file, size)
+	if err != nil || regions == nil {
+		return nil, err
+	}
+	var dataSize int64
+	for _, r := range regions {
+		dataSize += r.Length
+	}
+	if dataSize == size {
+		return nil, nil // no holes
+	}
+	// GNU tar expects the map to extend to the end of the file, which is
+	// marked by an empty region if the file ends in a hole
+	if len(regions) == 0 || regions[len(regions)-1].Offset+regions[len(regions)-1].Length < size {
+		regions = append(regions, sparseEntry{Offset: size})
+	}
+	return regions, nil
+}
+
+// isSparseTarEntry returns true if the entry was stored
+// in the archive as a GNU or PAX sparse file.
+func isSparseTarEntry(file FileInfo) bool {
+	hdr, ok := file.Header.(*tar.Header)
+	if !ok {
+		return false
+	}
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTarSparseEntry writes hdr and the data regions of src to out
+// as a sparse file in the GNU PAX 1.0 format. The standard library's
+// tar writer cannot produce sparse files, so the headers are encoded
+// here; tw must be flushed so that out is at a block boundary, and
+// out must be the same writer that tw writes to. The realsize, name,
+// and any attributes that do not fit in the USTAR header are recorded
+// as PAX records.
+func writeTarSparseEntry(out io.Writer, hdr *tar.Header, src io.ReaderAt, regions []sparseEntry) error {
+	// the sparse map precedes the data, padded to a block boundary
+	var sparseMap []byte
+	var dataSize int64
+	sparseMap = append(strconv.AppendInt(sparseMap, int64(len(regions)), 10), '\n')
+	for _, r := range regions {
+		sparseMap = append(strconv.AppendInt(sparseMap, r.Offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, r.Length, 10), '\n')
+		dataSize += r.Length
+	}
+	sparseMap = append(sparseMap, make([]byte, tarBlockPadding(int64(len(sparseMap))))...)
+	physicalSize := int64(len(sparseMap)) + dataSize
+
+	records := make(map[string]string, len(hdr.PAXRecords)+8)
+	for k, v := range hdr.PAXRecords {
+		records[k] = v
+	}
+	records["GNU.sparse.major"] = "1"
+	records["GNU.sparse.minor"] = "0"
+	records["GNU.sparse.name"] = hdr.Name
+	records["GNU.sparse.realsize"] = strconv.FormatInt(hdr.Size, 10)
+
+	dir, file := path.Split(hdr.Name)
+	main := ustarHeader{
+		name:     path.Join(dir, "GNUSparseFile.0", file),
+		mode:     hdr.Mode & 0o7777,
+		uid:      int64(hdr.Uid),
+		gid:      int64(hdr.Gid),
+		size:     physicalSize,
+		mtime:    hdr.ModTime.Unix(),
+		typeflag: tar.TypeReg,
+		uname:    hdr.Uname,
+		gname:    hdr.Gname,
+	}
+	main.overflowToPAX(records)
+
+	// extended header with the PAX records
+	var paxData bytes.Buffer
+	for _, k := range sortedKeys(records) {
+		paxData.WriteString(formatPAXRecord(k, records[k]))
+	}
+	paxHdr := ustarHeader{
+		name:     path.Join(dir, "PaxHeaders.0", file),
+		mode:     0o644,
+		size:     int64(paxData.Len()),
+		mtime:    main.mtime,
+		typeflag: tar.TypeXHeader,
+	}
+	paxHdr.overflowToPAX(nil)
+
+	for _, block := range [][]byte{
+		paxHdr.encode(),
+		paxData.Bytes(),
+		make([]byte, tarBlockPadding(int64(paxData.Len()))),
+		main.encode(),
+		sparseMap,
+	} {
+		if _, err := out.Write(block); err != nil {
+			return err
+		}
+	}
+	for _, r := range regions {
+		if _, err := io.Copy(out, io.NewSectionReader(src, r.Offset, r.Length)); err != nil {
+			return fmt.Errorf("writing data at offset %d: %w", r.Offset, err)
+		}
+	}
+	_, err := out.Write(make([]byte, tarBlockPadding(dataSize)))
+	return err
+}
+
+// ustarHeader holds the fields of a USTAR header block that
+// are needed to write sparse entries.
+type ustarHeader struct {
+	name           string
+	mode, uid, gid int64
+	size, mtime    int64
+	typeflag       byte
+	uname, gname   string
+}
+
+// overflowToPAX moves values that cannot be represented in a USTAR header
+// into records (if not nil), and clamps them in the header. The name is
+// split across the prefix and name fields if it is too long; if it still
+// does not fit, it is truncated, since readers take the real name from
+// the PAX records of sparse files.
+func (h *ustarHeader) overflowToPAX(records map[string]string) {
+	const maxOctal7, maxOctal11 = 1<<21 - 1, 1<<33 - 1
+	for _, field := range []struct {
+		key string
+		val *int64
+		max int64
+	}{
+		{"uid", &h.uid, maxOctal7},
+		{"gid", &h.gid, maxOctal7},
+		{"size", &h.size, maxOctal11},
+		{"mtime", &h.mtime, maxOctal11},
+	} {
+		if *field.val < 0 || *field.val > field.max {
+			if records != nil {
+				records[field.key] = strconv.FormatInt(*field.val, 10)
+			}
+			*field.val = 0
+		}
+	}
+	for _, field := range []struct {
+		key string
+		val *string
+	}{
+		{"uname", &h.uname},
+		{"gname", &h.gname},
+	} {
+		if len(*field.val) > 32 || !isUSTARString(*field.val) {
+			if records != nil {
+				records[field.key] = *field.val
+			}
+			*field.val = ""
+		}
+	}
+}
+
+// encode returns the header as a 512-byte block.
+func (h ustarHeader) encode() []byte {
+	blk := make([]byte, tarBlockSize)
+	prefix, name := splitUSTARPath(h.name)
+	copy(blk[0:100], name)
+	putOctal(blk[100:108], h.mode)
+	putOctal(blk[108:116], h.uid)
+	putOctal(blk[116:124], h.gid)
+	putOctal(blk[124:136], h.size)
+	putOctal(blk[136:148], h.mtime)
+	blk[156] = h.typeflag
+	copy(blk[257:263], "ustar\x00")
+	copy(blk[263:265], "00")
+	copy(blk[265:297], h.uname)
+	copy(blk[297:329], h.gname)
+	copy(blk[345:500], prefix)
+
+	// the checksum is computed with the checksum field set to spaces
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, b := range blk {
+		sum += int64(b)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return blk
+}
+
+// splitUSTARPath splits name into the prefix and name fields of a USTAR
+// header. If the name cannot be split to fit, it is truncated.
+func splitUSTARPath(name string) (prefix, suffix string) {
+	if len(name) <= 100 {
+		return "", name
+	}
+	for i := len(name) - 1; i > 0; i-- {
+		if name[i] == '/' && i <= 155 && len(name)-i-1 <= 100 {
+			return name[:i], name[i+1:]
+		}
+	}
+	return "", name[:100]
+}
+
+// putOctal writes n as a NUL-terminated, zero-padded octal number.
+func putOctal(b []byte, n int64) {
+	s := strconv.FormatInt(n, 8)
+	s = strings.Repeat("0", len(b)-1-len(s)) + s
+	copy(b, s)
+	b[len(b)-1] = 0
+}
+
+// formatPAXRecord formats a single PAX record, prefixed by its length,
+// which includes the length of the length itself.
+func formatPAXRecord(k, v string) string {
+	const padding = 3 // extra padding for ' ', '=', and '\n'
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	// the final length may have more digits than our estimate
+	if len(record) != size {
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// tarBlockPadding returns the number of bytes needed to pad n bytes
+// to a multiple of the tar block size.
+func tarBlockPadding(n int64) int64 {
+	return -n & (tarBlockSize - 1)
+}
+
+// isUSTARString returns true if s can be stored in a USTAR string field.
+func isUSTARString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || s[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// holeWriter writes to a file, skipping over blocks that consist
+// entirely of zeros instead of writing them, which leaves holes in
+// the file on file systems that support sparse files. Close must be
+// called to extend the file to its full size if it ends in a hole.
+type holeWriter struct {
+	f interface {
+		io.WriteSeeker
+		Truncate(size int64) error
+	}
+	buf  []byte // partial block awaiting more data
+	off  int64  // logical size written so far
+	skip int64  // pending bytes of zeros not yet seeked over
+}
+
+// holeBlockSize is the size of the blocks that are checked for zeros.
+// It matches the block size of most file systems.
+const holeBlockSize = 4096
+
+func (hw *holeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// fill up a block before deciding what to do with it
+		if len(hw.buf) > 0 || len(p) < holeBlockSize {
+			take := min(holeBlockSize-len(hw.buf), len(p))
+			hw.buf = append(hw.buf, p[:take]...)
+			p = p[take:]
+			if len(hw.buf) < holeBlockSize {
+				break
+			}
+			if err := hw.writeBlock(hw.buf); err != nil {
+				return 0, err
+			}
+			hw.buf = hw.buf[:0]
+			continue
+		}
+		if err := hw.writeBlock(p[:holeBlockSize]); err != nil {
+			return 0, err
+		}
+		p = p[holeBlockSize:]
+	}
+	return n, nil
+}
+
+func (hw *holeWriter) writeBlock(block []byte) error {
+	hw.off += int64(len(block))
+	if isZeros(block) {
+		hw.skip += int64(len(block))
+		return nil
+	}
+	if hw.skip > 0 {
+		if _, err := hw.f.Seek(hw.skip, io.SeekCurrent); err != nil {
+			return err
+		}
+		hw.skip = 0
+	}
+	_, err := hw.f.Write(block)
+	return err
+}
+
+// Close writes any remaining partial block and sets the final
+// size of the file. It does not close the underlying file.
+func (hw *holeWriter) Close() error {
+	if len(hw.buf) > 0 {
+		if err := hw.writeBlock(hw.buf); err != nil {
+			return err
+		}
+		hw.buf = nil
+	}
+	if hw.skip > 0 {
+		hw.skip = 0
+		return hw.f.Truncate(hw.off)
+	}
+	return nil
+}
+
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+const tarBlockSize = 512
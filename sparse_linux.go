@@ -0,0 +1,57 @@
+package archives
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// whence values for lseek(2) on Linux
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataRegions uses SEEK_DATA and SEEK_HOLE to find the regions of file
+// that contain data. It returns nil if file is not an *os.File or if the
+// file system does not support seeking for holes.
+func dataRegions(file fs.File, size int64) ([]sparseEntry, error) {
+	f, ok := file.(*os.File)
+	if !ok || size == 0 {
+		return nil, nil
+	}
+	defer f.Seek(0, io.SeekStart)
+
+	var regions []sparseEntry
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // only a hole remains
+		}
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return nil, nil // not supported here
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data >= size {
+			break
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		regions = append(regions, sparseEntry{Offset: data, Length: hole - data})
+		off = hole
+	}
+	if regions == nil {
+		// the file is entirely a hole; still record it as sparse
+		regions = []sparseEntry{}
+	}
+	return regions, nil
+}
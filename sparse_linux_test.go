@@ -0,0 +1,105 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestTarSparseRoundTrip(t *testing.T) {
+	const size = 8 << 20
+	srcDir := t.TempDir()
+	src := filepath.Join(srcDir, "disk.img")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("boot sector"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("some data in the middle"), size/2); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	regions, err := dataRegions(mustOpen(t, src), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regions == nil {
+		t.Skip("file system does not support SEEK_DATA/SEEK_HOLE")
+	}
+
+	ctx := context.Background()
+	files, err := FilesFromDisk(ctx, nil, map[string]string{src: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := (Tar{Sparse: true}).Archive(ctx, &archive, files); err != nil {
+		t.Fatalf("archiving: %v", err)
+	}
+	if archive.Len() > 1<<20 {
+		t.Errorf("expected holes to be omitted from archive, but it is %d bytes", archive.Len())
+	}
+
+	// the standard library reader expands the holes
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "disk.img" || hdr.Size != size {
+		t.Fatalf("unexpected header: name=%s size=%d", hdr.Name, hdr.Size)
+	}
+	want, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("archived content does not match source file")
+	}
+
+	// and extracting to disk restores them
+	dest := t.TempDir()
+	if err := ExtractToDisk(ctx, Tar{}, bytes.NewReader(archive.Bytes()), dest, nil); err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+	out := filepath.Join(dest, "disk.img")
+	got, err = os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("extracted content does not match source file")
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := info.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= size/2 {
+		t.Errorf("expected extracted file to be sparse, but %d bytes are allocated", allocated)
+	}
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
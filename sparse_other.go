@@ -0,0 +1,11 @@
+//go:build !linux
+
+package archives
+
+import "io/fs"
+
+// dataRegions is only implemented on Linux; elsewhere,
+// files are assumed to have no holes.
+func dataRegions(fs.File, int64) ([]sparseEntry, error) {
+	return nil, nil
+}
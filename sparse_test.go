@@ -0,0 +1,113 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTarSparseEntry(t *testing.T) {
+	const size = 3 * holeBlockSize
+	content := make([]byte, size)
+	copy(content[100:], "first")
+	copy(content[2*holeBlockSize:], "second")
+	regions := []sparseEntry{
+		{Offset: 0, Length: holeBlockSize},
+		{Offset: 2 * holeBlockSize, Length: holeBlockSize},
+	}
+
+	longName := strings.Repeat("d/", 60) + "sparse.bin"
+	hdr := &tar.Header{
+		Name:    longName,
+		Mode:    0o640,
+		Size:    size,
+		Uid:     1 << 22, // too big for USTAR
+		Uname:   "someone",
+		ModTime: time.Unix(1600000000, 0),
+	}
+
+	var buf bytes.Buffer
+	if err := writeTarSparseEntry(&buf, hdr, bytes.NewReader(content), regions); err != nil {
+		t.Fatalf("writing sparse entry: %v", err)
+	}
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "after.txt", Mode: 0o644, Size: 2}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("ok"))
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	got, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading sparse header: %v", err)
+	}
+	if got.Name != longName || got.Size != size || got.Uid != hdr.Uid || got.Uname != hdr.Uname ||
+		got.Mode != hdr.Mode || !got.ModTime.Equal(hdr.ModTime) {
+		t.Errorf("unexpected header: %+v", got)
+	}
+	if !isSparseTarEntry(FileInfo{Header: got}) {
+		t.Error("entry should be recognized as sparse")
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("reading sparse data: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("sparse file content does not match")
+	}
+
+	next, err := tr.Next()
+	if err != nil || next.Name != "after.txt" {
+		t.Fatalf("expected following entry, got %v (err=%v)", next, err)
+	}
+}
+
+func TestHoleWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content []byte
+	}{
+		{"trailing hole", append([]byte("data"), make([]byte, 5*holeBlockSize)...)},
+		{"leading hole", append(make([]byte, 3*holeBlockSize), []byte("data")...)},
+		{"no hole", bytes.Repeat([]byte("x"), 2*holeBlockSize+10)},
+		{"all zeros", make([]byte, 2*holeBlockSize+10)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			hw := &holeWriter{f: f}
+			// write in odd-sized pieces to exercise buffering
+			for rest := tc.content; len(rest) > 0; {
+				n := 1000
+				if n > len(rest) {
+					n = len(rest)
+				}
+				if _, err := hw.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := hw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.content) {
+				t.Errorf("content mismatch: expected %d bytes, got %d", len(tc.content), len(got))
+			}
+		})
+	}
+}
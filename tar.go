@@ -29,11 +29,26 @@ type Tar struct {
 	// If true, preserve only numeric user and group id
 	NumericUIDGID bool
 
+	// If true, regular files on disk that have holes in them are
+	// written as sparse files in the GNU PAX 1.0 format, storing
+	// only their data regions. Holes are only detected on Linux.
+	// This has no effect if Format is set to something other
+	// than PAX.
+	Sparse bool
+
 	// If true, errors encountered during reading or writing
 	// a file within an archive will be logged and the
 	// operation will continue on remaining files.
 	ContinueOnError bool
 
+	// Limits on the resources that extraction may use, to guard
+	// against malicious archives. By default, there are no limits.
+	Limits Limits
+
+	// How to normalize the names of files as they are read from
+	// the archive. By default, names are left exactly as they are.
+	Normalizer NameNormalizer
+
 	// User ID of the file owner
 	Uid int
 
@@ -77,7 +92,7 @@ func (t Tar) Archive(ctx context.Context, output io.Writer, files []FileInfo) er
 	defer tw.Close()
 
 	for _, file := range files {
-		if err := t.writeFileToArchive(ctx, tw, file); err != nil {
+		if err := t.writeFileToArchive(ctx, tw, output, file); err != nil {
 			if t.ContinueOnError && ctx.Err() == nil { // context errors should always abort
 				log.Printf("[ERROR] %v", err)
 				continue
@@ -94,13 +109,15 @@ func (t Tar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan Arc
 	defer tw.Close()
 
 	for job := range jobs {
-		job.Result <- t.writeFileToArchive(ctx, tw, job.File)
+		job.Result <- t.writeFileToArchive(ctx, tw, output, job.File)
 	}
 
 	return nil
 }
 
-func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileInfo) error {
+// writeFileToArchive writes file to tw. The output must be the writer that
+// tw writes to, which is needed to write sparse files.
+func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, output io.Writer, file FileInfo) error {
 	if err := ctx.Err(); err != nil {
 		return err // honor context cancellation
 	}
@@ -154,6 +171,16 @@ func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileIn
 		hdr.Gname = t.Gname
 	}
 
+	if t.Sparse && hdr.Typeflag == tar.TypeReg && (hdr.Format == tar.FormatUnknown || hdr.Format == tar.FormatPAX) {
+		written, err := t.writeSparseFile(tw, output, hdr, file)
+		if err != nil {
+			return fmt.Errorf("file %s: writing sparse file: %w", file.NameInArchive, err)
+		}
+		if written {
+			return nil
+		}
+	}
+
 	if err := tw.WriteHeader(hdr); err != nil {
 		return fmt.Errorf("file %s: writing header: %w", file.NameInArchive, err)
 	}
@@ -171,6 +198,31 @@ func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileIn
 	return nil
 }
 
+// writeSparseFile writes the file as a sparse entry if it has holes.
+// It returns false, without writing anything, if it does not.
+func (t Tar) writeSparseFile(tw *tar.Writer, output io.Writer, hdr *tar.Header, file FileInfo) (bool, error) {
+	f, err := file.Open()
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	regions, err := sparseData(f, hdr.Size)
+	if err != nil || regions == nil {
+		return false, err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return false, nil
+	}
+
+	// pad the previous entry so the output is at a block boundary
+	if err := tw.Flush(); err != nil {
+		return false, err
+	}
+	return true, writeTarSparseEntry(output, hdr, ra, regions)
+}
+
 func (t Tar) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileInfo) error {
 	// Tar files may end with some, none, or a lot of zero-byte padding. The spec says
 	// it should end with two 512-byte trailer records consisting solely of null/0
@@ -228,7 +280,7 @@ func (t Tar) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileIn
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
-		err = t.writeFileToArchive(ctx, tw, file)
+		err = t.writeFileToArchive(ctx, tw, into, file)
 		if err != nil {
 			if t.ContinueOnError && ctx.Err() == nil {
 				log.Printf("[ERROR] appending file %d into archive: %s: %v", i, file.Name(), err)
@@ -243,6 +295,7 @@ func (t Tar) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileIn
 
 func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
 	tr := tar.NewReader(sourceArchive)
+	lim := newLimiter(t.Limits)
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -263,7 +316,8 @@ func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 			}
 			return err
 		}
-		if fileIsIncluded(skipDirs, hdr.Name) {
+		name := t.Normalizer.Normalize(hdr.Name)
+		if fileIsIncluded(skipDirs, name) {
 			continue
 		}
 		if hdr.Typeflag == tar.TypeXGlobalHeader {
@@ -275,14 +329,21 @@ func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		file := FileInfo{
 			FileInfo:      info,
 			Header:        hdr,
-			NameInArchive: hdr.Name,
+			NameInArchive: name,
 			LinkTarget:    hdr.Linkname,
 			Open: func() (fs.File, error) {
 				return fileInArchive{io.NopCloser(tr), info}, nil
 			},
 		}
+		t.Normalizer.normalizeFile(&file)
+		if err := lim.check(&file); err != nil {
+			return err
+		}
 
 		err = handleFile(ctx, file)
+		if lim.err != nil {
+			return lim.err
+		}
 		if errors.Is(err, fs.SkipAll) {
 			// At first, I wasn't sure if fs.SkipAll implied that the rest of the entries
 			// should still be iterated and just "skipped" (i.e. no-ops) or if the walk
@@ -292,7 +353,7 @@ func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 			// https://github.com/golang/go/issues/47209 -- anyway, the walk should stop.
 			break
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
-			skipDirs.add(hdr.Name)
+			skipDirs.add(name)
 		} else if err != nil {
 			return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
 		}
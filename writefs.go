@@ -0,0 +1,134 @@
+package archives
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WritableFS is a file system that archive entries can be extracted into
+// with ExtractTo. Like with [fs.FS], names are slash-separated, unrooted
+// paths such as "dir/file.txt" (see [fs.ValidPath]), even on Windows.
+// Implementations should return errors that wrap the io/fs sentinel
+// errors (such as fs.ErrExist and fs.ErrNotExist) where appropriate.
+//
+// If the files returned by Create also implement io.Seeker and a method
+// Truncate(size int64) error, extracted files can be written sparsely; if
+// they implement a method Sync() error, it is called when fsync is desired.
+// If the file system also has Chown(name string, uid, gid int) error and
+// Lchown(name string, uid, gid int) error methods, ownership of extracted
+// entries can be restored.
+type WritableFS interface {
+	// MkdirAll creates the directory name along with any
+	// parents that do not exist yet, using perm for each.
+	MkdirAll(name string, perm fs.FileMode) error
+
+	// Create creates a new, empty file for writing. It must fail
+	// with an error wrapping fs.ErrExist if name already exists.
+	Create(name string) (io.WriteCloser, error)
+
+	// Symlink creates newname as a symbolic link to oldname,
+	// which is slash-separated and relative to newname's directory.
+	Symlink(oldname, newname string) error
+
+	// Link creates newname as a hard link to the file oldname.
+	Link(oldname, newname string) error
+
+	// Chmod changes the mode of the file to mode.
+	Chmod(name string, mode fs.FileMode) error
+
+	// Chtimes changes the access and modification times of the file.
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// Lstat returns information about the file without
+	// following it if it is a symbolic link.
+	Lstat(name string) (fs.FileInfo, error)
+
+	// Rename moves oldname to newname, replacing newname
+	// if it already exists and is not a directory.
+	Rename(oldname, newname string) error
+
+	// Remove removes the file or empty directory.
+	Remove(name string) error
+}
+
+// RootFS is a WritableFS that writes to the directory of an [os.Root].
+// As with all operations on an os.Root, files cannot be created outside
+// of the directory, even by following symbolic links.
+type RootFS struct {
+	Root *os.Root
+}
+
+func (r RootFS) MkdirAll(name string, perm fs.FileMode) error {
+	return r.Root.MkdirAll(filepath.FromSlash(name), perm)
+}
+
+func (r RootFS) Create(name string) (io.WriteCloser, error) {
+	return r.Root.OpenFile(filepath.FromSlash(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+}
+
+func (r RootFS) Symlink(oldname, newname string) error {
+	return r.Root.Symlink(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (r RootFS) Link(oldname, newname string) error {
+	return r.Root.Link(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (r RootFS) Chmod(name string, mode fs.FileMode) error {
+	return r.Root.Chmod(filepath.FromSlash(name), mode)
+}
+
+func (r RootFS) Chtimes(name string, atime, mtime time.Time) error {
+	return r.Root.Chtimes(filepath.FromSlash(name), atime, mtime)
+}
+
+func (r RootFS) Chown(name string, uid, gid int) error {
+	return r.Root.Chown(filepath.FromSlash(name), uid, gid)
+}
+
+func (r RootFS) Lchown(name string, uid, gid int) error {
+	return r.Root.Lchown(filepath.FromSlash(name), uid, gid)
+}
+
+func (r RootFS) Lstat(name string) (fs.FileInfo, error) {
+	return r.Root.Lstat(filepath.FromSlash(name))
+}
+
+func (r RootFS) Open(name string) (fs.File, error) {
+	return r.Root.Open(filepath.FromSlash(name))
+}
+
+func (r RootFS) Rename(oldname, newname string) error {
+	return r.Root.Rename(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (r RootFS) Remove(name string) error {
+	return r.Root.Remove(filepath.FromSlash(name))
+}
+
+// mknod creates a device or named pipe; see ExtractOptions.SpecialFiles.
+func (r RootFS) mknod(name string, file FileInfo) error {
+	return mknod(r.Root, filepath.FromSlash(name), file)
+}
+
+// ownerFS is a WritableFS that can change the ownership of files.
+type ownerFS interface {
+	Chown(name string, uid, gid int) error
+	Lchown(name string, uid, gid int) error
+}
+
+// nodeFS is a WritableFS that can create devices and named pipes.
+type nodeFS interface {
+	mknod(name string, file FileInfo) error
+}
+
+// Interface guards
+var (
+	_ WritableFS = RootFS{}
+	_ fs.FS      = RootFS{}
+	_ ownerFS    = RootFS{}
+	_ nodeFS     = RootFS{}
+)
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"log"
@@ -12,11 +13,15 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	szip "github.com/STARRY-S/zip"
 	"golang.org/x/text/encoding"
 
 	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/zip"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
@@ -25,38 +30,120 @@ import (
 func init() {
 	RegisterFormat(Zip{})
 
-	// TODO: What about custom flate levels too
-	zip.RegisterCompressor(ZipMethodBzip2, func(out io.Writer) (io.WriteCloser, error) {
-		return bzip2.NewWriter(out, &bzip2.WriterConfig{ /*TODO: Level: z.CompressionLevel*/ })
+	registerZipCompressor(ZipMethodBzip2, func(out io.Writer) (io.WriteCloser, error) {
+		return bzip2.NewWriter(out, nil)
 	})
-	zip.RegisterCompressor(ZipMethodZstd, func(out io.Writer) (io.WriteCloser, error) {
+	registerZipCompressor(ZipMethodZstd, func(out io.Writer) (io.WriteCloser, error) {
 		return zstd.NewWriter(out)
 	})
-	zip.RegisterCompressor(ZipMethodXz, func(out io.Writer) (io.WriteCloser, error) {
+	registerZipCompressor(ZipMethodXz, lazyCompressor(func(out io.Writer) (io.WriteCloser, error) {
 		return xz.NewWriter(out)
+	}))
+	registerZipCompressor(ZipMethodLzma, zipLzmaCompressor(0))
+	szip.RegisterCompressor(zipMethodHandoff, func(out io.Writer) (io.WriteCloser, error) {
+		return szipHandoff.comp(out)
 	})
 
-	zip.RegisterDecompressor(ZipMethodBzip2, func(r io.Reader) io.ReadCloser {
+	registerZipDecompressor(ZipMethodDeflate64, newDeflate64Reader)
+	registerZipDecompressor(ZipMethodBzip2, func(r io.Reader) io.ReadCloser {
 		bz2r, err := bzip2.NewReader(r, nil)
 		if err != nil {
 			return nil
 		}
 		return bz2r
 	})
-	zip.RegisterDecompressor(ZipMethodZstd, func(r io.Reader) io.ReadCloser {
+	registerZipDecompressor(ZipMethodZstd, func(r io.Reader) io.ReadCloser {
 		zr, err := zstd.NewReader(r)
 		if err != nil {
 			return nil
 		}
 		return zr.IOReadCloser()
 	})
-	zip.RegisterDecompressor(ZipMethodXz, func(r io.Reader) io.ReadCloser {
+	registerZipDecompressor(ZipMethodXz, func(r io.Reader) io.ReadCloser {
 		xr, err := xz.NewReader(r)
 		if err != nil {
 			return nil
 		}
 		return io.NopCloser(xr)
 	})
+	registerZipDecompressor(ZipMethodLzma, func(r io.Reader) io.ReadCloser {
+		return newZipLzmaReader(r, -1)
+	})
+	registerZipDecompressor(ZipMethodPPMd, func(r io.Reader) io.ReadCloser {
+		return newZipPPMdReader(r, -1)
+	})
+}
+
+// zipCompressors are the compressors for each zip method that this
+// package knows about, for the same reason as zipDecompressors: they
+// are needed to write encrypted entries.
+var zipCompressors = map[uint16]zip.Compressor{
+	zip.Store: func(out io.Writer) (io.WriteCloser, error) {
+		return nopWriteCloser{out}, nil
+	},
+	zip.Deflate: flateCompressor(flate.DefaultCompression),
+}
+
+// registerZipCompressor registers comp with the zip package
+// and remembers it for writing encrypted entries.
+func registerZipCompressor(method uint16, comp zip.Compressor) {
+	zip.RegisterCompressor(method, comp)
+	zipCompressors[method] = comp
+}
+
+// zipDecompressors are the decompressors for each zip method that this
+// package knows about. The zip package has no way to look up the ones
+// registered with it, and they are needed to read encrypted entries,
+// which it can't do by itself.
+var zipDecompressors = map[uint16]zip.Decompressor{
+	zip.Store:   io.NopCloser,
+	zip.Deflate: flate.NewReader,
+}
+
+// registerZipDecompressor registers dcomp with the zip package
+// and remembers it for reading encrypted entries.
+func registerZipDecompressor(method uint16, dcomp zip.Decompressor) {
+	zip.RegisterDecompressor(method, dcomp)
+	zipDecompressors[method] = dcomp
+}
+
+// zipDecompressor returns the decompressor for method, for an entry of
+// size bytes, which the ones registered with the zip package aren't
+// given: PPMd data doesn't say where it ends, so without the size only
+// data that ends with an end marker can be read, which 7-Zip doesn't
+// write; and LZMA data says how large a dictionary to allocate, which
+// without the size can't be limited to what the entry needs.
+func zipDecompressor(method uint16, size uint64) zip.Decompressor {
+	switch method {
+	case ZipMethodPPMd:
+		return func(r io.Reader) io.ReadCloser {
+			return newZipPPMdReader(r, int64(size))
+		}
+	case ZipMethodLzma:
+		return func(r io.Reader) io.ReadCloser {
+			return newZipLzmaReader(r, int64(size))
+		}
+	}
+	return zipDecompressors[method]
+}
+
+// openZipFile opens f like (*zip.File).Open, but with the decompressor
+// from zipDecompressor.
+func openZipFile(f *zip.File) (io.ReadCloser, error) {
+	if (f.Method != ZipMethodPPMd && f.Method != ZipMethodLzma) || strings.HasSuffix(f.Name, "/") {
+		return f.Open()
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return &zipChecksumReader{
+		rc:       zipDecompressor(f.Method, f.UncompressedSize64)(raw),
+		hash:     crc32.NewIEEE(),
+		checkCRC: true,
+		crc32:    f.CRC32,
+		size:     f.UncompressedSize64,
+	}, nil
 }
 
 type Zip struct {
@@ -68,15 +155,130 @@ type Zip struct {
 	// The method or algorithm for compressing stored files.
 	Compression uint16
 
+	// The level of compression, for the methods that have levels. What
+	// the levels are depends on the method:
+	//
+	//   - Deflate: the levels of the flate package, from -2 (HuffmanOnly) to 9
+	//   - Bzip2: 1 to 9
+	//   - Zstd: 1 to 22, as with the zstd command
+	//   - Xz and Lzma: 0 to 9, as with the xz command, which choose the dictionary size
+	//
+	// If 0, the default level of the method is used, rather than none.
+	CompressionLevel int
+
+	// Compression levels by method, which take precedence over
+	// CompressionLevel for the methods they have. Since levels mean
+	// different things for each method, this is how to set them when
+	// files are compressed with more than one method.
+	CompressionLevels map[uint16]int
+
+	// If set, called for each file that is not a directory to choose
+	// the method and level of compression for it, instead of Compression
+	// and SelectiveCompression. A level of 0 means the level given by
+	// CompressionLevels or CompressionLevel.
+	CompressionFunc func(file FileInfo) (method uint16, level int)
+
+	// If true, the start of each file is compressed before the file is
+	// added, and the file is stored without compression if that doesn't
+	// make it meaningfully smaller. Unlike SelectiveCompression, this
+	// works for files whose names don't tell what they contain. It
+	// applies after the method is chosen, including by CompressionFunc.
+	SelectiveCompressionByContent bool
+
 	// If true, errors encountered during reading or writing
 	// a file within an archive will be logged and the
 	// operation will continue on remaining files.
 	ContinueOnError bool
 
+	// The password for encrypted entries. When extracting, both
+	// the traditional PKWARE cipher (ZipCrypto) and WinZip's AES
+	// encryption (AE-1 and AE-2) are supported. When archiving, if
+	// set, the contents of files are encrypted with AES-256 (AE-2),
+	// which 7-Zip and WinZip can read; names, directories, and
+	// symbolic links are never encrypted.
+	Password string
+
+	// If set, chooses the password to encrypt each file with when
+	// archiving, instead of Password. Files for which it returns an
+	// empty password are not encrypted. It is not called for
+	// directories and symbolic links.
+	FilePassword func(ctx context.Context, file FileInfo) (string, error)
+
+	// If set, called for more passwords to try when extracting an
+	// encrypted entry for which Password, or the last password that
+	// worked, is wrong. See PasswordFunc.
+	PasswordFunc PasswordFunc
+
+	// If true, archives with malformed entries are rejected by Extract
+	// before any of their files are handled. Otherwise, malformed
+	// entries are flagged by setting FileInfo.Malformed, and it is up to
+	// the FileHandler what to do with them. See Extract for details.
+	Strict bool
+
+	// Limits on the resources that extraction may use, to guard
+	// against malicious archives. By default, there are no limits.
+	Limits Limits
+
+	// How to normalize the names of files as they are read from
+	// the archive. By default, names are left exactly as they are.
+	Normalizer NameNormalizer
+
 	// For files in zip archives that do not have UTF-8
 	// encoded filenames and comments, specify the character
 	// encoding here.
 	TextEncoding encoding.Encoding
+
+	// The comment of the archive, to write when archiving. When
+	// inserting, the comment of the archive is replaced if this is
+	// set. To read it, use ReadComment. The comments of each file
+	// are in FileInfo.Comment.
+	Comment string
+
+	// If true, each file is written with an NTFS extra field, which has
+	// its times to 100 nanoseconds, and is what Windows programs read.
+	// The extended timestamp field, which has the modification time to
+	// the second, and the Unix field, which has the owner of the file
+	// if it is known, are always written. See ParseZipExtra.
+	NTFSTimes bool
+
+	// The time zone of the DOS date and time in zip file headers, which
+	// have none of their own and are usually in the local time of the
+	// program that wrote them. Files are written with their modification
+	// time in this time zone, or in the time zone of the time itself if
+	// this is nil. It is also how the DOS date and time of files is read
+	// if they have no extra field with a time, which defaults to UTC.
+	TimeZone *time.Location
+
+	// If greater than 1, the contents of files that are stored without
+	// compression start at a multiple of this many bytes in the archive,
+	// like zipalign does for Android, so that they can be memory-mapped
+	// straight out of it. The local file headers are padded with the
+	// extra field that Android's tools use (0xd935). When inserting,
+	// files in the archive that are moved, which is what happens to the
+	// ones after a file that is replaced, may no longer be aligned.
+	Alignment int
+
+	// The alignment of stored shared libraries (files ending in .so),
+	// if different from Alignment; usually the page size, 4096 or 16384.
+	SharedLibraryAlignment int
+
+	// If true, files that come from a zip archive, as the ones that
+	// Extract returns do, are copied into the archive in their compressed
+	// form when archiving, instead of being decompressed and compressed
+	// again. Their checksum, compression method, encryption, and extra
+	// fields are kept, and only their NameInArchive and Comment can be
+	// changed. The archive they come from must not be closed until they
+	// are copied. Files with the Header of a file in a zip archive whose
+	// FileInfo was replaced by one that isn't from Extract can't be copied
+	// this way, and cause an error. Merge always copies files this way.
+	CopyRaw bool
+
+	// What Merge does with a file that has the same name as one from an
+	// earlier archive. The default is ConflictOverwrite, which keeps the
+	// file from the last archive, in the place of the first one. With
+	// ConflictFail, the error wraps ErrDuplicateName. Directories are
+	// always merged.
+	MergeConflict ConflictPolicy
 }
 
 func (Zip) Extension() string { return ".zip" }
@@ -106,11 +308,15 @@ func (z Zip) Match(_ context.Context, filename string, stream io.Reader) (MatchR
 }
 
 func (z Zip) Archive(ctx context.Context, output io.Writer, files []FileInfo) error {
-	zw := zip.NewWriter(output)
+	out := &countingWriter{w: output}
+	zw := zip.NewWriter(out)
+	if err := zw.SetComment(z.Comment); err != nil {
+		return err
+	}
 	defer zw.Close()
 
 	for i, file := range files {
-		if err := z.archiveOneFile(ctx, zw, i, file); err != nil {
+		if err := z.archiveOneFile(ctx, zw, out, i, file); err != nil {
 			return err
 		}
 	}
@@ -119,74 +325,252 @@ func (z Zip) Archive(ctx context.Context, output io.Writer, files []FileInfo) er
 }
 
 func (z Zip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan ArchiveAsyncJob) error {
-	zw := zip.NewWriter(output)
+	out := &countingWriter{w: output}
+	zw := zip.NewWriter(out)
+	if err := zw.SetComment(z.Comment); err != nil {
+		return err
+	}
 	defer zw.Close()
 
 	var i int
 	for job := range jobs {
-		job.Result <- z.archiveOneFile(ctx, zw, i, job.File)
+		job.Result <- z.archiveOneFile(ctx, zw, out, i, job.File)
 		i++
 	}
 
 	return nil
 }
 
-func (z Zip) archiveOneFile(ctx context.Context, zw *zip.Writer, idx int, file FileInfo) error {
+// archiveOneFile writes file to zw, which writes to out.
+func (z Zip) archiveOneFile(ctx context.Context, zw *zip.Writer, out *countingWriter, idx int, file FileInfo) error {
 	if err := ctx.Err(); err != nil {
 		return err // honor context cancellation
 	}
 
+	if z.CopyRaw {
+		if f := zipSourceFile(file.FileInfo); f != nil {
+			return z.copyRawFile(zw, idx, file, f)
+		}
+		if _, ok := file.Header.(zip.FileHeader); ok {
+			return fmt.Errorf("copying file %d: %s: %w", idx, file.NameInArchive, errZipNotRaw)
+		}
+	}
+
+	entry, err := z.newZipEntry(ctx, idx, file)
+	if err != nil {
+		return err
+	}
+	defer entry.close()
+	hdr, comp := entry.hdr, entry.comp
+
+	if entry.password != "" {
+		w, err := createEncrypted(zw, hdr, entry.password, comp)
+		if err != nil {
+			return fmt.Errorf("creating header for file %d: %s: %w", idx, file.Name(), err)
+		}
+		if err := entry.writeContents(w); err != nil {
+			return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("encrypting file %d: %s: %w", idx, file.Name(), err)
+		}
+		return nil
+	}
+
+	extraLen := len(hdr.Extra)
+	if entry.align > 1 && comp != nil {
+		comp = aligningCompressor(comp, &hdr.Extra, len(hdr.Name), entry.align, func() (int64, error) {
+			// whatever the writer has buffered is before the local header
+			return out.n, zw.Flush()
+		})
+	}
+
+	// the compressor of the writer is set for each file, since
+	// the level may be different for each one
+	if comp != nil {
+		zw.RegisterCompressor(hdr.Method, comp)
+	}
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("creating header for file %d: %s: %w", idx, file.Name(), err)
+	}
+	hdr.Extra = hdr.Extra[:extraLen] // alignment is only needed in the local header
+
+	if err := entry.writeContents(w); err != nil {
+		return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), err)
+	}
+
+	return nil
+}
+
+// zipEntry is a file to write into a zip archive, with its header and what
+// it is written with, which are the same whether the archive is being
+// created or inserted into.
+type zipEntry struct {
+	file     FileInfo
+	hdr      *zip.FileHeader
+	comp     zip.Compressor // nil if the method is not one this package knows
+	password string         // empty if not encrypted
+	align    int            // what the contents are aligned to, if more than 1
+	sample   *sampledFile   // what file is opened with, if its contents were sampled
+}
+
+// newZipEntry prepares file, the idx-th one, to be written into a zip
+// archive. The entry must be closed once it has been written.
+func (z Zip) newZipEntry(ctx context.Context, idx int, file FileInfo) (*zipEntry, error) {
 	hdr, err := zip.FileInfoHeader(file)
 	if err != nil {
-		return fmt.Errorf("getting info for file %d: %s: %w", idx, file.Name(), err)
+		return nil, fmt.Errorf("getting info for file %d: %s: %w", idx, file.Name(), err)
 	}
 	hdr.Name = file.NameInArchive // complete path, since FileInfoHeader() only has base name
 	if hdr.Name == "" {
 		hdr.Name = file.Name() // assume base name of file I guess
 	}
+	hdr.Comment = file.Comment
+	hdr.Extra, hdr.ModifiedDate, hdr.ModifiedTime = z.zipExtra(file)
+	hdr.Modified = time.Time{} // the extended timestamp field is in Extra
 
 	// customize header based on file properties
-	if file.IsDir() {
-		if !strings.HasSuffix(hdr.Name, "/") {
-			hdr.Name += "/" // required
+	if file.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/" // required
+	}
+	entry := &zipEntry{file: file, hdr: hdr}
+	var level int
+	hdr.Method, level = z.compressionMethod(file)
+	if hdr.Method != zip.Store && z.SelectiveCompressionByContent && file.Mode().IsRegular() {
+		entry.sample, err = sampleFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("sampling file %d: %s: %w", idx, file.Name(), err)
 		}
-		hdr.Method = zip.Store
-	} else if z.SelectiveCompression {
-		// only enable compression on compressable files
-		ext := strings.ToLower(path.Ext(hdr.Name))
-		if _, ok := compressedFormats[ext]; ok {
+		entry.file.Open = entry.sample.open
+		if !entry.sample.compressible() {
 			hdr.Method = zip.Store
-		} else {
-			hdr.Method = z.Compression
 		}
-	} else {
-		hdr.Method = z.Compression
 	}
+	if hdr.Method == ZipMethodLzma {
+		hdr.Flags |= zipFlagLzmaEOS
+	}
+	entry.comp = z.compressor(hdr.Method, level)
 
-	w, err := zw.CreateHeader(hdr)
+	entry.password, err = z.filePassword(ctx, file)
 	if err != nil {
-		return fmt.Errorf("creating header for file %d: %s: %w", idx, file.Name(), err)
+		entry.close()
+		return nil, fmt.Errorf("getting password for file %d: %s: %w", idx, file.Name(), err)
+	}
+	if entry.password == "" && hdr.Method == zip.Store && file.Mode().IsRegular() {
+		entry.align = z.alignment(file)
 	}
+	return entry, nil
+}
 
+// writeContents writes the contents of the entry to w: those of the file,
+// the target of a symbolic link, or nothing for a directory.
+func (e *zipEntry) writeContents(w io.Writer) error {
 	// file won't be considered a symlink if FollowSymlinks in FilesFromDisk is true
-	if isSymlink(file) {
-		_, err := w.Write([]byte(file.LinkTarget))
-		if err != nil {
-			return fmt.Errorf("writing link target for file %d: %s: %w", idx, file.Name(), err)
+	if isSymlink(e.file) {
+		if _, err := w.Write([]byte(e.file.LinkTarget)); err != nil {
+			return fmt.Errorf("writing link target: %w", err)
 		}
 		return nil
 	}
 
 	// directories have no file body
-	if file.IsDir() {
+	if e.file.IsDir() {
 		return nil
 	}
 
-	if err := openAndCopyFile(file, w); err != nil {
-		return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), err)
+	return openAndCopyFile(e.file, w)
+}
+
+// close closes the sample of the file, if there is one.
+func (e *zipEntry) close() {
+	if e.sample != nil {
+		e.sample.Close()
 	}
+}
 
-	return nil
+// compressionMethod returns the method and level of compression
+// for file, before SelectiveCompressionByContent is considered.
+func (z Zip) compressionMethod(file FileInfo) (uint16, int) {
+	var method uint16
+	var level int
+	switch {
+	case file.IsDir():
+		return zip.Store, 0
+	case z.CompressionFunc != nil:
+		method, level = z.CompressionFunc(file)
+	case z.SelectiveCompression && isCompressedFormat(file.Name()):
+		// only enable compression on compressable files
+		method = zip.Store
+	default:
+		method = z.Compression
+	}
+	if level == 0 {
+		level = z.compressionLevel(method)
+	}
+	return method, level
+}
+
+// compressionLevel returns the compression level for method,
+// or 0 for its default level.
+func (z Zip) compressionLevel(method uint16) int {
+	if level, ok := z.CompressionLevels[method]; ok {
+		return level
+	}
+	return z.CompressionLevel
+}
+
+// compressor returns the compressor for method at level, or
+// nil if the method is unknown. Level 0 is the default level.
+func (z Zip) compressor(method uint16, level int) zip.Compressor {
+	if level == 0 {
+		return zipCompressors[method]
+	}
+	switch method {
+	case zip.Deflate:
+		return flateCompressor(level)
+	case ZipMethodBzip2:
+		return func(out io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(out, &bzip2.WriterConfig{Level: level})
+		}
+	case ZipMethodZstd:
+		return func(out io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+	case ZipMethodXz:
+		return lazyCompressor(func(out io.Writer) (io.WriteCloser, error) {
+			dictCap, err := xzDictCap(level)
+			if err != nil {
+				return nil, err
+			}
+			return xz.WriterConfig{DictCap: dictCap}.NewWriter(out)
+		})
+	case ZipMethodLzma:
+		return zipLzmaCompressor(level)
+	}
+	return zipCompressors[method]
+}
+
+// xzDictCap returns the dictionary size of the xz command's preset for
+// level, since the xz package has no levels of its own.
+func xzDictCap(level int) (int, error) {
+	presets := [...]int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+	if level < 0 || level >= len(presets) {
+		return 0, fmt.Errorf("invalid xz compression level: %d", level)
+	}
+	return presets[level], nil
+}
+
+// filePassword returns the password to encrypt file with when
+// archiving, or an empty string if it should not be encrypted.
+func (z Zip) filePassword(ctx context.Context, file FileInfo) (string, error) {
+	if file.IsDir() || isSymlink(file) {
+		return "", nil
+	}
+	if z.FilePassword != nil {
+		return z.FilePassword(ctx, file)
+	}
+	return z.Password, nil
 }
 
 // Extract extracts files from z, implementing the Extractor interface. Uniquely, however,
@@ -195,6 +579,14 @@ func (z Zip) archiveOneFile(ctx context.Context, zw *zip.Writer, idx int, file F
 // the interface because we figure you can Read() from anything you can ReadAt() or Seek()
 // with. Due to the nature of the zip archive format, if sourceArchive is not an io.Seeker
 // and io.ReaderAt, an error is returned.
+//
+// Before any files are handled, the central directory is checked against the local file
+// headers to find entries whose data overlaps that of other entries (as in zip bombs that
+// reuse the same compressed data to get around limits on the size of each entry), entries
+// with the same name as an earlier one, entries whose local file header is missing or has
+// a different name, and entries whose compressed data extends past the end of the archive.
+// Such entries are flagged with FileInfo.Malformed, or if z.Strict is set, the archive is
+// rejected with an error wrapping ErrMalformedEntry.
 func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
 	sra, ok := sourceArchive.(seekReaderAt)
 	if !ok {
@@ -210,6 +602,29 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 	if err != nil {
 		return err
 	}
+	lim := newLimiter(z.Limits)
+	passwords := newPasswords(z.Password, z.PasswordFunc, archiveName(sourceArchive))
+
+	// check the central directory against the local file headers before
+	// handing out any entries; if that can't be done at all (unlikely,
+	// since zip.NewReader could read it), only strict mode cares
+	malformed, err := checkZipEntries(sra, size)
+	if err == nil && len(malformed) != len(zr.File) {
+		err = fmt.Errorf("found %d entries in central directory, expected %d", len(malformed), len(zr.File))
+	}
+	if err != nil {
+		if z.Strict {
+			return fmt.Errorf("checking central directory: %w: %w", ErrMalformedEntry, err)
+		}
+		malformed = nil
+	}
+	if z.Strict {
+		for i, err := range malformed {
+			if err != nil {
+				return fmt.Errorf("file %d: %s: %w", i, zr.File[i].Name, err)
+			}
+		}
+	}
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -222,11 +637,12 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		// ensure filename and comment are UTF-8 encoded (issue #147 and PR #305)
 		z.decodeText(&f.FileHeader)
 
-		if fileIsIncluded(skipDirs, f.Name) {
+		name := z.Normalizer.Normalize(f.Name)
+		if fileIsIncluded(skipDirs, name) {
 			continue
 		}
 
-		info := f.FileInfo()
+		info := z.fileInfo(f)
 		linkTarget, err := z.getLinkTarget(f)
 		if err != nil {
 			return fmt.Errorf("getting link target for file %d: %s: %w", i, f.Name, err)
@@ -235,22 +651,44 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		file := FileInfo{
 			FileInfo:      info,
 			Header:        f.FileHeader,
-			NameInArchive: f.Name,
+			NameInArchive: name,
 			LinkTarget:    linkTarget,
+			Encrypted:     isEncryptedZipEntry(&f.FileHeader),
+			Comment:       f.Comment,
 			Open: func() (fs.File, error) {
-				openedFile, err := f.Open()
+				var openedFile io.ReadCloser
+				var err error
+				if isEncryptedZipEntry(&f.FileHeader) {
+					err = passwords.try(ctx, f.Name, func(password string) error {
+						var err error
+						openedFile, err = openEncrypted(f, password)
+						return err
+					})
+				} else {
+					openedFile, err = openZipFile(f)
+				}
 				if err != nil {
 					return nil, err
 				}
 				return fileInArchive{openedFile, info}, nil
 			},
 		}
+		z.Normalizer.normalizeFile(&file)
+		if malformed != nil {
+			file.Malformed = malformed[i]
+		}
+		if err := lim.check(&file); err != nil {
+			return err
+		}
 
 		err = handleFile(ctx, file)
+		if lim.err != nil {
+			return lim.err
+		}
 		if errors.Is(err, fs.SkipAll) {
 			break
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
-			skipDirs.add(f.Name)
+			skipDirs.add(name)
 		} else if err != nil {
 			if z.ContinueOnError {
 				log.Printf("[ERROR] %s: %v", f.Name, err)
@@ -263,6 +701,30 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 	return nil
 }
 
+// ReadComment returns the comment of the zip archive, which is at its
+// end, decoded with TextEncoding if it is set and the comment is not
+// valid UTF-8.
+func (z Zip) ReadComment(ctx context.Context, sourceArchive io.Reader) (string, error) {
+	sra, ok := sourceArchive.(seekReaderAt)
+	if !ok {
+		return "", fmt.Errorf("input type must be an io.ReaderAt and io.Seeker because of zip format constraints")
+	}
+	size, err := streamSizeBySeeking(sra)
+	if err != nil {
+		return "", fmt.Errorf("determining stream size: %w", err)
+	}
+	zr, err := zip.NewReader(sra, size)
+	if err != nil {
+		return "", err
+	}
+	if z.TextEncoding != nil && !utf8.ValidString(zr.Comment) {
+		if comment, err := z.TextEncoding.NewDecoder().String(zr.Comment); err == nil {
+			return comment, nil
+		}
+	}
+	return zr.Comment, nil
+}
+
 // decodeText decodes the name and comment fields from hdr into UTF-8.
 // It is a no-op if the text is already UTF-8 encoded or if z.TextEncoding
 // is not specified.
@@ -290,7 +752,7 @@ func (z Zip) getLinkTarget(f *zip.File) (string, error) {
 	}
 
 	// Open the file and read the link target
-	file, err := f.Open()
+	file, err := openZipFile(f)
 	if err != nil {
 		return "", err
 	}
@@ -313,52 +775,36 @@ func (z Zip) getLinkTarget(f *zip.File) (string, error) {
 // If the filename already exists in the archive, it will be replaced.
 func (z Zip) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileInfo) error {
 	// following very simple example at https://github.com/STARRY-S/zip?tab=readme-ov-file#usage
-	zu, err := szip.NewUpdater(into)
+	crc := &zipCRCEraser{w: into}
+	zu, err := szip.NewUpdater(seekingZipCRCEraser{crc, into})
 	if err != nil {
 		return err
 	}
 	defer zu.Close()
+	if z.Comment != "" {
+		if err := zu.SetComment(z.Comment); err != nil {
+			return err
+		}
+	}
+
+	// when an entry is replaced, the updater moves the entries after it,
+	// and clears their extra fields, which may hold what is needed to
+	// decrypt them; those of the entries inserted here can be restored,
+	// since the updater keeps their headers
+	inserted := make(map[*szip.FileHeader][]byte)
 
 	for idx, file := range files {
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
 
-		hdr, err := szip.FileInfoHeader(file)
+		entry, w, err := z.insertHeader(ctx, zu, crc, into, inserted, idx, file)
 		if err != nil {
-			return fmt.Errorf("getting info for file %d: %s: %w", idx, file.NameInArchive, err)
-		}
-		hdr.Name = file.NameInArchive // complete path, since FileInfoHeader() only has base name
-		if hdr.Name == "" {
-			hdr.Name = file.Name() // assume base name of file I guess
-		}
-
-		// customize header based on file properties
-		if file.IsDir() {
-			if !strings.HasSuffix(hdr.Name, "/") {
-				hdr.Name += "/" // required
-			}
-			hdr.Method = zip.Store
-		} else if z.SelectiveCompression {
-			// only enable compression on compressable files
-			ext := strings.ToLower(path.Ext(hdr.Name))
-			if _, ok := compressedFormats[ext]; ok {
-				hdr.Method = zip.Store
-			} else {
-				hdr.Method = z.Compression
-			}
+			return err
 		}
-
-		w, err := zu.AppendHeader(hdr, szip.APPEND_MODE_OVERWRITE)
+		err = entry.writeContents(w)
+		entry.close()
 		if err != nil {
-			return fmt.Errorf("inserting file header: %d: %s: %w", idx, file.Name(), err)
-		}
-
-		// directories have no file body
-		if file.IsDir() {
-			return nil
-		}
-		if err := openAndCopyFile(file, w); err != nil {
 			if z.ContinueOnError && ctx.Err() == nil {
 				log.Printf("[ERROR] appending file %d into archive: %s: %v", idx, file.Name(), err)
 				continue
@@ -370,6 +816,134 @@ func (z Zip) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileIn
 	return nil
 }
 
+// insertHeader appends the header of file, the idx-th one, to zu, which
+// writes to into through crc, and returns the entry and the writer for
+// its contents. The headers of the files inserted so far are in inserted,
+// with their extra fields. The entry must be closed once it is written.
+func (z Zip) insertHeader(ctx context.Context, zu *szip.Updater, crc *zipCRCEraser, into io.Seeker, inserted map[*szip.FileHeader][]byte, idx int, file FileInfo) (*zipEntry, io.Writer, error) {
+	entry, err := z.newZipEntry(ctx, idx, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	shdr := szip.FileHeader(*entry.hdr)
+	hdr, comp := &shdr, entry.comp
+
+	var w io.Writer
+	if entry.password != "" {
+		if comp == nil {
+			entry.close()
+			return nil, nil, fmt.Errorf("encrypting file %d: %s: %w", idx, file.Name(), zip.ErrAlgorithm)
+		}
+		hdr.Extra = append(hdr.Extra, zipAESExtra(zipAESInfo{version: 2, strength: 3, method: hdr.Method})...)
+		hdr.Flags |= zipFlagEncrypted
+		w, err = appendWithCompressor(zu, hdr, newZipAESCompressor(comp, entry.password,
+			func() { hdr.Method, hdr.ReaderVersion = zipMethodAES, zipVersionAES },
+			func() error {
+				crc.erased = func() { hdr.CRC32 = 0 }
+				return nil
+			}))
+	} else if comp != nil {
+		extraLen := len(hdr.Extra)
+		if entry.align > 1 {
+			comp = aligningCompressor(comp, &hdr.Extra, len(hdr.Name), entry.align, func() (int64, error) {
+				return into.Seek(0, io.SeekCurrent)
+			})
+		}
+		method := hdr.Method
+		w, err = appendWithCompressor(zu, hdr, func(out io.Writer) (io.WriteCloser, error) {
+			hdr.Method = method
+			return comp(out)
+		})
+		hdr.Extra = hdr.Extra[:extraLen] // alignment is only needed in the local header
+	} else {
+		w, err = zu.AppendHeader(hdr, szip.APPEND_MODE_OVERWRITE)
+	}
+	if err != nil {
+		entry.close()
+		return nil, nil, fmt.Errorf("inserting file header: %d: %s: %w", idx, file.Name(), err)
+	}
+	for h, extra := range inserted {
+		h.Extra = extra
+	}
+	inserted[hdr] = hdr.Extra
+
+	return entry, w, nil
+}
+
+// seekingZipCRCEraser is a zipCRCEraser for the updater that Insert uses.
+type seekingZipCRCEraser struct {
+	*zipCRCEraser
+	io.ReadSeeker
+}
+
+// The updater that Insert uses can't be given compressors of its own, only
+// global ones, which would affect every other updater in the program. So
+// the only compressor registered with it is one for a method that is never
+// written, zipMethodHandoff, and it hands off to the compressor that
+// appendWithCompressor was given, which must set the real method.
+const zipMethodHandoff = 0xffff
+
+var szipHandoff struct {
+	sync.Mutex
+	comp zip.Compressor
+}
+
+// appendWithCompressor appends the file with header hdr to zu, like
+// AppendHeader, but compresses its contents with comp.
+func appendWithCompressor(zu *szip.Updater, hdr *szip.FileHeader, comp zip.Compressor) (io.Writer, error) {
+	szipHandoff.Lock()
+	defer szipHandoff.Unlock()
+	szipHandoff.comp = comp
+	defer func() { szipHandoff.comp = nil }()
+	hdr.Method = zipMethodHandoff
+	return zu.AppendHeader(hdr, szip.APPEND_MODE_OVERWRITE)
+}
+
+// lazyCompressor returns a compressor that makes its writer with comp
+// when it is first written to or closed. The zip package makes the
+// compressor of an entry before it writes the local file header, so
+// compressors whose writers write as soon as they are made, like xz's
+// with its stream header, would write before it.
+func lazyCompressor(comp zip.Compressor) zip.Compressor {
+	return func(out io.Writer) (io.WriteCloser, error) {
+		return &lazyWriteCloser{out: out, comp: comp}, nil
+	}
+}
+
+type lazyWriteCloser struct {
+	out  io.Writer
+	comp zip.Compressor
+	w    io.WriteCloser
+}
+
+func (l *lazyWriteCloser) start() error {
+	if l.w != nil {
+		return nil
+	}
+	var err error
+	l.w, err = l.comp(l.out)
+	return err
+}
+
+func (l *lazyWriteCloser) Write(p []byte) (int, error) {
+	if err := l.start(); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
+
+func (l *lazyWriteCloser) Close() error {
+	if err := l.start(); err != nil {
+		return err
+	}
+	return l.w.Close()
+}
+
+// nopWriteCloser is the compressing writer of zip.Store.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
 type seekReaderAt interface {
 	io.ReaderAt
 	io.Seeker
@@ -378,11 +952,14 @@ type seekReaderAt interface {
 // Additional compression methods not offered by archive/zip.
 // See https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT section 4.4.5.
 const (
-	ZipMethodBzip2 = 12
-	// TODO: LZMA: Disabled - because 7z isn't able to unpack ZIP+LZMA ZIP+LZMA2 archives made this way - and vice versa.
-	// ZipMethodLzma     = 14
-	ZipMethodZstd = 93
-	ZipMethodXz   = 95
+	// Deflate64 can be read, but not written.
+	ZipMethodDeflate64 = 9
+	ZipMethodBzip2     = 12
+	ZipMethodLzma      = 14
+	ZipMethodZstd      = 93
+	ZipMethodXz        = 95
+	// PPMd (variant I) can be read, but not written.
+	ZipMethodPPMd = 98
 )
 
 // compressedFormats is a (non-exhaustive) set of lowercased
@@ -424,6 +1001,13 @@ var compressedFormats = map[string]struct{}{
 	".zipx": {},
 }
 
+// isCompressedFormat returns true if the extension of filename
+// is that of a format in compressedFormats.
+func isCompressedFormat(filename string) bool {
+	_, ok := compressedFormats[strings.ToLower(path.Ext(filename))]
+	return ok
+}
+
 var zipHeaders = [][]byte{
 	[]byte("PK\x03\x04"), // normal
 	[]byte("PK\x05\x06"), // empty
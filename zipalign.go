@@ -0,0 +1,63 @@
+package archives
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// zipExtraAlignment is the extra field that Android's tools pad local file
+// headers with to align the contents of files: the alignment, as 2 bytes,
+// then the padding, which is zeros.
+const zipExtraAlignment = 0xd935
+
+// alignment returns what the contents of file are aligned to
+// if it is stored; 0 or 1 is no alignment.
+func (z Zip) alignment(file FileInfo) int {
+	if z.SharedLibraryAlignment > 0 && strings.HasSuffix(file.Name(), ".so") {
+		return z.SharedLibraryAlignment
+	}
+	return z.Alignment
+}
+
+// aligningCompressor returns comp made to first append an alignment field
+// to extra, the extra fields of a stored file whose name is nameLen bytes.
+// Zip writers make the compressor of a file after finishing the one before
+// it and before writing its local header, so when it is made, offset can
+// tell where the header will be.
+func aligningCompressor(comp zip.Compressor, extra *[]byte, nameLen, align int, offset func() (int64, error)) zip.Compressor {
+	return func(out io.Writer) (io.WriteCloser, error) {
+		off, err := offset()
+		if err != nil {
+			return nil, err
+		}
+		if *extra, err = zipAlign(*extra, nameLen, off, align); err != nil {
+			return nil, err
+		}
+		return comp(out)
+	}
+}
+
+// zipAlign returns extra with an alignment field appended, so that
+// the contents of a file with a name of nameLen bytes and extra fields
+// extra, whose local header is at offset, start at a multiple of align.
+func zipAlign(extra []byte, nameLen int, offset int64, align int) ([]byte, error) {
+	if align > math.MaxUint16 {
+		return nil, fmt.Errorf("alignment %d is greater than %d", align, math.MaxUint16)
+	}
+	// the contents follow the fixed-size part of the local header,
+	// the name, the other extra fields, and this one
+	start := offset + 30 + int64(nameLen) + int64(len(extra)) + 6
+	padding := int((int64(align) - start%int64(align)) % int64(align))
+	if len(extra)+6+padding > math.MaxUint16 {
+		return nil, fmt.Errorf("extra fields would be %d bytes, more than the %d allowed", len(extra)+6+padding, math.MaxUint16)
+	}
+	extra = binary.LittleEndian.AppendUint16(extra, zipExtraAlignment)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(2+padding))
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(align))
+	return append(extra, make([]byte, padding)...), nil
+}
@@ -0,0 +1,94 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipAlignment(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":           {Data: []byte(strings.Repeat("compressed ", 100))},
+		"assets/b.bin":    {Data: []byte("stored, at an odd offset")},
+		"lib/libfoo.so":   {Data: []byte("shared library")},
+		"assets/long.png": {Data: []byte("stored with a longer name")},
+		"lib/libbar.so":   {Data: []byte("inserted shared library")},
+	}
+	names := []string{"a.txt", "assets/b.bin", "lib/libfoo.so", "assets/long.png", "lib/libbar.so"}
+	z := Zip{
+		Alignment:              4096,
+		SharedLibraryAlignment: 16384,
+		CompressionFunc: func(file FileInfo) (uint16, int) {
+			if path.Ext(file.Name()) == ".txt" {
+				return zip.Deflate, 0
+			}
+			return zip.Store, 0
+		},
+	}
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := z.Archive(context.Background(), f, mapFSFiles(t, fsys, names[:4]...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Insert(context.Background(), f, mapFSFiles(t, fsys, names[4:]...)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		offset, err := zf.DataOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		align := int64(4096)
+		if strings.HasSuffix(zf.Name, ".so") {
+			align = 16384
+		}
+		for extra := zf.Extra; len(extra) >= 4; extra = extra[4+binary.LittleEndian.Uint16(extra[2:]):] {
+			if binary.LittleEndian.Uint16(extra) == zipExtraAlignment {
+				t.Errorf("%s: expected padding only in the local header", zf.Name)
+			}
+		}
+		if zf.Method == zip.Store && offset%align != 0 {
+			t.Errorf("%s: expected contents aligned to %d, got offset %d", zf.Name, align, offset)
+		}
+		if zf.Method != zip.Store && offset%align == 0 {
+			t.Errorf("%s: expected compressed file not to be aligned", zf.Name)
+		}
+	}
+
+	if len(zr.File) != len(names) {
+		t.Errorf("expected %d files, got %d", len(names), len(zr.File))
+	}
+	contents, errs, err := extractEntries(t, Zip{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if contents[name] != string(fsys[name].Data) {
+			t.Errorf("%s: contents differ (errors: %v)", name, errs)
+		}
+	}
+
+	if err := (Zip{Alignment: 1 << 16, Compression: zip.Store}).Archive(context.Background(), io.Discard, mapFSFiles(t, fsys, "a.txt")); err == nil {
+		t.Error("expected error for alignment that is too large")
+	}
+}
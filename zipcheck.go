@@ -0,0 +1,256 @@
+package archives
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// zipCentralRecord is the part of a record of a zip archive's
+// central directory that is needed to check its entry.
+type zipCentralRecord struct {
+	name       []byte
+	compressed uint64
+	offset     int64 // of the local file header, from the start of the file
+}
+
+// checkZipEntries compares the central directory of the zip archive r
+// with the local file headers it points to, and returns the problems
+// with each entry, in the order of the central directory. Entries
+// without problems have a nil error. It detects entries whose data
+// overlaps other entries (the hallmark of non-recursive zip bombs),
+// duplicate names, local file headers that are missing or that have
+// different names than their central directory records, and data that
+// extends past the end of the archive or into its central directory.
+func checkZipEntries(r io.ReaderAt, size int64) ([]error, error) {
+	records, dirStart, err := readZipCentralDirectory(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([][]error, len(records))
+	flag := func(i int, format string, a ...any) {
+		problems[i] = append(problems[i], fmt.Errorf(format, a...))
+	}
+
+	// extents of each entry, from its local header to the end of its data
+	type extent struct {
+		index      int
+		start, end int64
+	}
+	extents := make([]extent, 0, len(records))
+
+	seen := make(map[string]int, len(records))
+	var local [30]byte
+	for i, rec := range records {
+		if first, ok := seen[string(rec.name)]; ok {
+			flag(i, "duplicate of entry %d", first)
+		} else {
+			seen[string(rec.name)] = i
+		}
+
+		if rec.offset < 0 || rec.offset+int64(len(local)) > dirStart {
+			flag(i, "local file header at offset %d is outside of the file data", rec.offset)
+			continue
+		}
+		if _, err := r.ReadAt(local[:], rec.offset); err != nil {
+			flag(i, "reading local file header: %v", err)
+			continue
+		}
+		if binary.LittleEndian.Uint32(local[0:4]) != zipLocalHeaderSignature {
+			flag(i, "no local file header at offset %d", rec.offset)
+			continue
+		}
+		nameLen := int64(binary.LittleEndian.Uint16(local[26:28]))
+		extraLen := int64(binary.LittleEndian.Uint16(local[28:30]))
+		localName := make([]byte, nameLen)
+		if _, err := r.ReadAt(localName, rec.offset+int64(len(local))); err != nil {
+			flag(i, "reading local file name: %v", err)
+			continue
+		}
+		if !bytes.Equal(localName, rec.name) {
+			flag(i, "local file header has a different name: %q", localName)
+		}
+
+		dataStart := rec.offset + int64(len(local)) + nameLen + extraLen
+		dataEnd := size + 1
+		if rec.compressed <= uint64(size) {
+			dataEnd = dataStart + int64(rec.compressed)
+		}
+		switch {
+		case dataEnd > size:
+			flag(i, "compressed size %d extends past the end of the archive", rec.compressed)
+		case dataEnd > dirStart:
+			flag(i, "compressed data extends into the central directory")
+		}
+		extents = append(extents, extent{i, rec.offset, min(dataEnd, size)})
+	}
+
+	// with the entries sorted by position, any entry that begins before
+	// the furthest end of the entries before it overlaps that entry
+	slices.SortFunc(extents, func(a, b extent) int {
+		if c := cmp.Compare(a.start, b.start); c != 0 {
+			return c
+		}
+		return a.index - b.index
+	})
+	var furthest extent
+	for j, cur := range extents {
+		if j > 0 && cur.start < furthest.end {
+			flag(cur.index, "data overlaps entry %d", furthest.index)
+			flag(furthest.index, "data overlaps entry %d", cur.index)
+		}
+		if j == 0 || cur.end > furthest.end {
+			furthest = cur
+		}
+	}
+
+	results := make([]error, len(records))
+	for i, errs := range problems {
+		if len(errs) > 0 {
+			results[i] = fmt.Errorf("%w: %w", ErrMalformedEntry, errors.Join(errs...))
+		}
+	}
+	return results, nil
+}
+
+// readZipCentralDirectory reads the records of the central directory of
+// the zip archive r, and returns them along with the offset at which the
+// central directory begins. Like archive/zip, it allows for data to be
+// prepended to the archive, as in self-extracting executables, and
+// returns offsets that are relative to the start of r.
+func readZipCentralDirectory(r io.ReaderAt, size int64) ([]zipCentralRecord, int64, error) {
+	const eocdLen = 22
+
+	// find the end of central directory record, which is followed
+	// only by the archive's comment
+	bufLen := min(size, eocdLen+0xffff)
+	buf := make([]byte, bufLen)
+	if _, err := r.ReadAt(buf, size-bufLen); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	eocd := -1
+	for i := len(buf) - eocdLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == zipEndSignature &&
+			i+eocdLen+int(binary.LittleEndian.Uint16(buf[i+20:])) <= len(buf) {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return nil, 0, fmt.Errorf("end of central directory not found")
+	}
+	end := buf[eocd:]
+	endOffset := size - bufLen + int64(eocd)
+	dirSize := uint64(binary.LittleEndian.Uint32(end[12:16]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(end[16:20]))
+
+	// zip64 archives have another end record before this one
+	if binary.LittleEndian.Uint16(end[10:12]) == 0xffff || dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		var locator [20]byte
+		if endOffset >= int64(len(locator)) {
+			if _, err := r.ReadAt(locator[:], endOffset-int64(len(locator))); err == nil &&
+				binary.LittleEndian.Uint32(locator[0:4]) == zip64LocatorSignature {
+				var end64 [56]byte
+				end64Offset := int64(binary.LittleEndian.Uint64(locator[8:16]))
+				if _, err := r.ReadAt(end64[:], end64Offset); err != nil {
+					return nil, 0, fmt.Errorf("reading zip64 end of central directory: %w", err)
+				}
+				if binary.LittleEndian.Uint32(end64[0:4]) != zip64EndSignature {
+					return nil, 0, fmt.Errorf("zip64 end of central directory not found")
+				}
+				endOffset = end64Offset
+				dirSize = binary.LittleEndian.Uint64(end64[40:48])
+				dirOffset = binary.LittleEndian.Uint64(end64[48:56])
+			}
+		}
+	}
+	if dirSize > uint64(size) || dirOffset > uint64(size) {
+		return nil, 0, fmt.Errorf("central directory is outside of the archive")
+	}
+
+	// any data prepended to the archive shifts all offsets
+	baseOffset := endOffset - int64(dirSize) - int64(dirOffset)
+	if baseOffset > 0 {
+		var sig [4]byte
+		if _, err := r.ReadAt(sig[:], int64(dirOffset)); err == nil &&
+			binary.LittleEndian.Uint32(sig[:]) == zipCentralSignature {
+			baseOffset = 0
+		}
+	}
+	dirStart := baseOffset + int64(dirOffset)
+	if dirStart < 0 || dirStart+int64(dirSize) > size {
+		return nil, 0, fmt.Errorf("central directory is outside of the archive")
+	}
+
+	dir := make([]byte, dirSize)
+	if _, err := r.ReadAt(dir, dirStart); err != nil {
+		return nil, 0, fmt.Errorf("reading central directory: %w", err)
+	}
+
+	var records []zipCentralRecord
+	for len(dir) >= 46 && binary.LittleEndian.Uint32(dir[0:4]) == zipCentralSignature {
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:30]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:32]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:34]))
+		if len(dir) < 46+nameLen+extraLen+commentLen {
+			return nil, 0, fmt.Errorf("central directory record %d is truncated", len(records))
+		}
+		rec := zipCentralRecord{
+			name:       dir[46 : 46+nameLen],
+			compressed: uint64(binary.LittleEndian.Uint32(dir[20:24])),
+			offset:     int64(binary.LittleEndian.Uint32(dir[42:46])),
+		}
+		uncompressed := binary.LittleEndian.Uint32(dir[24:28])
+
+		// sizes and offsets that don't fit in 32 bits are in a zip64 extra field,
+		// in order, but only those whose values in the record are all 1s
+		for extra := dir[46+nameLen : 46+nameLen+extraLen]; len(extra) >= 4; {
+			id := binary.LittleEndian.Uint16(extra[0:2])
+			fieldLen := int(binary.LittleEndian.Uint16(extra[2:4]))
+			if len(extra) < 4+fieldLen {
+				break
+			}
+			field := extra[4 : 4+fieldLen]
+			extra = extra[4+fieldLen:]
+			if id != zip64ExtraID {
+				continue
+			}
+			if uncompressed == 0xffffffff && len(field) >= 8 {
+				field = field[8:]
+			}
+			if rec.compressed == 0xffffffff && len(field) >= 8 {
+				rec.compressed = binary.LittleEndian.Uint64(field)
+				field = field[8:]
+			}
+			if rec.offset == 0xffffffff && len(field) >= 8 {
+				rec.offset = int64(binary.LittleEndian.Uint64(field))
+			}
+		}
+		rec.offset += baseOffset
+
+		records = append(records, rec)
+		dir = dir[46+nameLen+extraLen+commentLen:]
+	}
+
+	return records, dirStart, nil
+}
+
+const (
+	zipLocalHeaderSignature = 0x04034b50
+	zipCentralSignature     = 0x02014b50
+	zipEndSignature         = 0x06054b50
+	zip64LocatorSignature   = 0x07064b50
+	zip64EndSignature       = 0x06064b50
+	zip64ExtraID            = 0x0001
+)
+
+// ErrMalformedEntry is returned (wrapped) for, or attached to, archive
+// entries that are inconsistent with the rest of the archive in ways
+// that well-formed archives never are, and that can be used to disguise
+// zip bombs or to show different contents to different programs.
+var ErrMalformedEntry = errors.New("malformed archive entry")
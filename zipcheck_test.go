@@ -0,0 +1,162 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// makeTestZip creates a zip archive with the given files, stored
+// without compression, and lets patch modify the records of its
+// central directory before returning it.
+func makeTestZip(t *testing.T, names []string, patch func(records [][]byte)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("contents of " + name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if patch != nil {
+		var records [][]byte
+		sig := binary.LittleEndian.AppendUint32(nil, zipCentralSignature)
+		for i := bytes.Index(data, sig); i >= 0; {
+			records = append(records, data[i:])
+			next := bytes.Index(data[i+4:], sig)
+			if next < 0 {
+				break
+			}
+			i += 4 + next
+		}
+		patch(records)
+	}
+	return data
+}
+
+// malformedEntries returns the errors of the entries of the zip
+// archive that are malformed, by name.
+func malformedEntries(t *testing.T, data []byte) map[string]error {
+	t.Helper()
+	found := make(map[string]error)
+	for name, file := range extractFileInfos(t, Zip{}, data) {
+		if file.Malformed != nil {
+			found[name] = file.Malformed
+		}
+	}
+	return found
+}
+
+func TestZipCheckWellFormed(t *testing.T) {
+	archives := [][]byte{makeTestZip(t, []string{"a", "dir/", "dir/b"}, nil)}
+	for _, name := range []string{"testdata/test.zip", "testdata/unordered.zip", "testdata/symlinks.zip"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+	}
+	for i, data := range archives {
+		if found := malformedEntries(t, data); len(found) > 0 {
+			t.Errorf("archive %d: unexpected malformed entries: %v", i, found)
+		}
+		err := Zip{Strict: true}.Extract(context.Background(), bytes.NewReader(data), func(context.Context, FileInfo) error { return nil })
+		if err != nil {
+			t.Errorf("archive %d: strict extraction failed: %v", i, err)
+		}
+	}
+
+	// data prepended to the archive, as in self-extracting archives, is fine too
+	prepended := append([]byte("#!/bin/sh\nexit 0\n"), archives[0]...)
+	if found := malformedEntries(t, prepended); len(found) > 0 {
+		t.Errorf("archive with prepended data: unexpected malformed entries: %v", found)
+	}
+}
+
+func TestZipCheckMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		files  []string
+		patch  func(records [][]byte)
+		expect []string // names of the entries expected to be flagged
+	}{
+		{
+			name:  "overlapping entries",
+			files: []string{"a", "b"},
+			patch: func(records [][]byte) {
+				// point b at the local header of a
+				binary.LittleEndian.PutUint32(records[1][42:], 0)
+			},
+			expect: []string{"a", "b"},
+		},
+		{
+			name:  "entries contained in another",
+			files: []string{"a", "b", "c"},
+			patch: func(records [][]byte) {
+				// extend the data of a to the end of the data of c
+				offsetOfC := binary.LittleEndian.Uint32(records[2][42:])
+				binary.LittleEndian.PutUint32(records[0][20:], offsetOfC+uint32(len("contents of c")))
+			},
+			expect: []string{"a", "b", "c"},
+		},
+		{
+			name:   "duplicate names",
+			files:  []string{"dup", "other", "dup"},
+			expect: []string{"dup"},
+		},
+		{
+			name:  "local name mismatch",
+			files: []string{"a", "b"},
+			patch: func(records [][]byte) {
+				records[1][46] = 'c' // the central directory now says "c"
+			},
+			expect: []string{"c"},
+		},
+		{
+			name:  "size past end of archive",
+			files: []string{"a", "b"},
+			patch: func(records [][]byte) {
+				binary.LittleEndian.PutUint32(records[1][20:], 1<<30)
+			},
+			expect: []string{"b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeTestZip(t, tc.files, tc.patch)
+
+			found := malformedEntries(t, data)
+			for _, name := range tc.expect {
+				if !errors.Is(found[name], ErrMalformedEntry) {
+					t.Errorf("expected %s to be flagged, got %v", name, found[name])
+				}
+			}
+			if len(found) != len(tc.expect) {
+				t.Errorf("expected %d flagged entries, got %v", len(tc.expect), found)
+			}
+
+			var handled int
+			err := Zip{Strict: true}.Extract(context.Background(), bytes.NewReader(data), func(context.Context, FileInfo) error {
+				handled++
+				return nil
+			})
+			if !errors.Is(err, ErrMalformedEntry) {
+				t.Errorf("expected strict extraction to fail with ErrMalformedEntry, got %v", err)
+			}
+			if handled > 0 {
+				t.Errorf("expected no entries to be handled in strict mode, got %d", handled)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zip"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestZipComments(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"b.txt": {Data: []byte("b")},
+		"c.txt": {Data: []byte("c")},
+	}
+	files := mapFSFiles(t, fsys, "a.txt", "b.txt")
+	files[0].Comment = "plain comment"
+	files[1].Comment = "encrypted, with UTF-8: ✓"
+
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z := Zip{
+		Comment: "provenance: build 1234",
+		FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+			if file.NameInArchive == "b.txt" {
+				return "secret", nil
+			}
+			return "", nil
+		},
+	}
+	if err := z.Archive(context.Background(), f, files); err != nil {
+		t.Fatal(err)
+	}
+
+	comment, err := Zip{}.ReadComment(context.Background(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != z.Comment {
+		t.Errorf("expected archive comment %q, got %q", z.Comment, comment)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted := extractFileInfos(t, Zip{}, data)
+	for _, file := range files {
+		if got := extracted[file.NameInArchive].Comment; got != file.Comment {
+			t.Errorf("%s: expected comment %q, got %q", file.NameInArchive, file.Comment, got)
+		}
+	}
+
+	// inserting keeps the archive comment, unless there is a new one
+	inserted := mapFSFiles(t, fsys, "c.txt")
+	inserted[0].Comment = "inserted"
+	if err := (Zip{}).Insert(context.Background(), f, inserted); err != nil {
+		t.Fatal(err)
+	}
+	if comment, _ := (Zip{}).ReadComment(context.Background(), f); comment != z.Comment {
+		t.Errorf("expected archive comment %q to be kept, got %q", z.Comment, comment)
+	}
+	if data, err = os.ReadFile(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if got := extractFileInfos(t, Zip{}, data)["c.txt"].Comment; got != "inserted" {
+		t.Errorf("expected comment of inserted file, got %q", got)
+	}
+	if err := (Zip{Comment: "provenance: build 1235"}).Insert(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if comment, _ := (Zip{}).ReadComment(context.Background(), f); comment != "provenance: build 1235" {
+		t.Errorf("expected new archive comment, got %q", comment)
+	}
+
+	if err := (Zip{Comment: strings.Repeat("x", 1<<16)}).Archive(context.Background(), io.Discard, nil); err == nil {
+		t.Error("expected error for comment that is too long")
+	}
+}
+
+func TestZipCommentEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.SetComment("caf\x82") // "café" in code page 437
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := Zip{TextEncoding: charmap.CodePage437}.ReadComment(context.Background(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "café" {
+		t.Errorf("expected %q, got %q", "café", comment)
+	}
+}
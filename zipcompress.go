@@ -0,0 +1,128 @@
+package archives
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+)
+
+// flateWriterPools are pools of flate writers by level, offset by
+// HuffmanOnly. Flate writers take a lot of memory to make, so like
+// the zip package, which the compressors here replace, they are reused.
+var flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+// flateCompressor returns a Deflate compressor for level.
+func flateCompressor(level int) zip.Compressor {
+	return func(out io.Writer) (io.WriteCloser, error) {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return nil, fmt.Errorf("invalid deflate compression level: %d", level)
+		}
+		pool := &flateWriterPools[level-flate.HuffmanOnly]
+		if fw, ok := pool.Get().(*flate.Writer); ok {
+			fw.Reset(out)
+			return &pooledFlateWriter{fw: fw, pool: pool}, nil
+		}
+		fw, err := flate.NewWriter(out, level)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledFlateWriter{fw: fw, pool: pool}, nil
+	}
+}
+
+// pooledFlateWriter returns its flate writer to its pool when closed.
+type pooledFlateWriter struct {
+	fw   *flate.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledFlateWriter) Write(p []byte) (int, error) {
+	if w.fw == nil {
+		return 0, errors.New("write to closed flate writer")
+	}
+	return w.fw.Write(p)
+}
+
+func (w *pooledFlateWriter) Close() error {
+	if w.fw == nil {
+		return nil
+	}
+	err := w.fw.Close()
+	w.pool.Put(w.fw)
+	w.fw = nil
+	return err
+}
+
+// zipSampleSize is how much of the start of a file is compressed
+// to tell whether it is worth compressing, for
+// SelectiveCompressionByContent.
+const zipSampleSize = 64 << 10
+
+// sampledFile is a file whose start has been read to tell whether it
+// is worth compressing. It is opened only once, so that files that
+// can't be opened again, like those being extracted from another
+// archive, can be sampled too.
+type sampledFile struct {
+	fs.File
+	sample []byte
+	r      io.Reader
+	closed bool
+}
+
+// sampleFile opens file and reads its start.
+func sampleFile(file FileInfo) (*sampledFile, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	sample, err := io.ReadAll(io.LimitReader(f, zipSampleSize))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &sampledFile{File: f, sample: sample, r: io.MultiReader(bytes.NewReader(sample), f)}, nil
+}
+
+// open is the Open function of the sampled file's FileInfo, which
+// returns the file as it was before its start was read.
+func (sf *sampledFile) open() (fs.File, error) {
+	if sf.closed {
+		return nil, fs.ErrClosed
+	}
+	return sf, nil
+}
+
+func (sf *sampledFile) Read(p []byte) (int, error) { return sf.r.Read(p) }
+
+// Close closes the file, unless it was closed already.
+func (sf *sampledFile) Close() error {
+	if sf.closed {
+		return nil
+	}
+	sf.closed = true
+	return sf.File.Close()
+}
+
+// compressible reports whether the sample gets meaningfully smaller,
+// by at least 1/16th, when compressed quickly. What is compressed with
+// more effort, or with another method, usually gets about as much
+// smaller as the sample does.
+func (sf *sampledFile) compressible() bool {
+	if len(sf.sample) == 0 {
+		return false
+	}
+	cw := &countingWriter{w: io.Discard}
+	fw, err := flateCompressor(flate.BestSpeed)(cw)
+	if err != nil {
+		return true
+	}
+	fw.Write(sf.sample)
+	fw.Close()
+	return cw.n <= int64(len(sf.sample)-len(sf.sample)/16)
+}
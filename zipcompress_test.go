@@ -0,0 +1,264 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+)
+
+// rawZipEntry returns the compressed data of the named entry.
+func rawZipEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		if zf.Name != name {
+			continue
+		}
+		r, err := zf.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	t.Fatalf("%s: not in archive", name)
+	return nil
+}
+
+func TestZipCompressionLevels(t *testing.T) {
+	var text strings.Builder
+	for i := range 5000 {
+		text.WriteString(strings.Repeat("level ", i%7))
+		text.WriteString("compression\n")
+	}
+	fsys := fstest.MapFS{"a.txt": {Data: []byte(text.String())}}
+	files := mapFSFiles(t, fsys, "a.txt")
+
+	archive := func(z Zip) []byte {
+		var buf bytes.Buffer
+		if err := z.Archive(context.Background(), &buf, files); err != nil {
+			t.Fatal(err)
+		}
+		contents, errs, err := extractEntries(t, z, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents["a.txt"] != text.String() {
+			t.Fatalf("method %d: contents differ (errors: %v)", z.Compression, errs)
+		}
+		return rawZipEntry(t, buf.Bytes(), "a.txt")
+	}
+
+	for _, tc := range []struct {
+		method    uint16
+		low, high int
+		check     func(t *testing.T, low, high []byte)
+	}{
+		{zip.Deflate, 1, 9, func(t *testing.T, low, high []byte) {
+			var buf bytes.Buffer
+			fw, _ := flate.NewWriter(&buf, 1)
+			fw.Write([]byte(text.String()))
+			fw.Close()
+			if !bytes.Equal(low, buf.Bytes()) {
+				t.Error("expected output of level 1")
+			}
+		}},
+		{ZipMethodBzip2, 1, 9, func(t *testing.T, low, high []byte) {
+			if !bytes.HasPrefix(low, []byte("BZh1")) || !bytes.HasPrefix(high, []byte("BZh9")) {
+				t.Errorf("expected block sizes of levels 1 and 9, got %q and %q", low[:4], high[:4])
+			}
+		}},
+		{ZipMethodZstd, 1, 19, nil},
+		{ZipMethodXz, 1, 9, nil},
+		{ZipMethodLzma, 1, 9, func(t *testing.T, low, high []byte) {
+			// the dictionary size follows the LZMA properties byte
+			if !bytes.Equal(low[5:9], []byte{0, 0, 0x10, 0}) || !bytes.Equal(high[5:9], []byte{0, 0, 0, 0x04}) {
+				t.Errorf("expected dictionary sizes of 1 and 64 MiB, got % x and % x", low[5:9], high[5:9])
+			}
+		}},
+	} {
+		// the level of the method takes precedence over CompressionLevel
+		low := archive(Zip{Compression: tc.method, CompressionLevel: tc.high, CompressionLevels: map[uint16]int{tc.method: tc.low}})
+		high := archive(Zip{Compression: tc.method, CompressionLevel: tc.high})
+		if bytes.Equal(low, high) {
+			t.Errorf("method %d: expected levels %d and %d to differ", tc.method, tc.low, tc.high)
+		}
+		if tc.check != nil {
+			tc.check(t, low, high)
+		}
+	}
+
+	// Zip values with different levels don't affect each other
+	expect := map[int][]byte{
+		1: archive(Zip{Compression: zip.Deflate, CompressionLevel: 1}),
+		9: archive(Zip{Compression: zip.Deflate, CompressionLevel: 9}),
+	}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		level := []int{1, 9}[i%2]
+		wg.Go(func() {
+			var buf bytes.Buffer
+			if err := (Zip{Compression: zip.Deflate, CompressionLevel: level}).Archive(context.Background(), &buf, files); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(rawZipEntry(t, buf.Bytes(), "a.txt"), expect[level]) {
+				t.Errorf("level %d: output differs", level)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestZipInsertCompressionLevel(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte(strings.Repeat("first ", 1000))},
+		"b.txt": {Data: []byte(strings.Repeat("second ", 1000))},
+	}
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := (Zip{}).Archive(context.Background(), f, mapFSFiles(t, fsys, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	z := Zip{SelectiveCompression: true, Compression: ZipMethodBzip2, CompressionLevel: 2}
+	if err := z.Insert(context.Background(), f, mapFSFiles(t, fsys, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawZipEntry(t, data, "b.txt"); !bytes.HasPrefix(raw, []byte("BZh2")) {
+		t.Errorf("expected block size of level 2, got %q", raw[:4])
+	}
+	contents, errs, err := extractEntries(t, z, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, file := range fsys {
+		if contents[name] != string(file.Data) {
+			t.Errorf("%s: contents differ (errors: %v)", name, errs)
+		}
+	}
+}
+
+func TestZipCompressionFunc(t *testing.T) {
+	text := []byte(strings.Repeat("choose a method per file\n", 500))
+	fsys := fstest.MapFS{
+		"a.txt":      {Data: text},
+		"b.log":      {Data: text},
+		"c.jpg":      {Data: text},
+		"dir":        {Mode: fs.ModeDir},
+		"dir/d.json": {Data: text},
+	}
+	var buf bytes.Buffer
+	z := Zip{
+		SelectiveCompression: true, // not used with CompressionFunc
+		CompressionLevels:    map[uint16]int{ZipMethodBzip2: 3},
+		CompressionFunc: func(file FileInfo) (uint16, int) {
+			switch path.Ext(file.Name()) {
+			case ".log":
+				return ZipMethodBzip2, 0
+			case ".json":
+				return ZipMethodBzip2, 1
+			case ".txt":
+				return zip.Store, 0
+			}
+			return zip.Deflate, 0
+		},
+	}
+	if err := z.Archive(context.Background(), &buf, mapFSFiles(t, fsys, "a.txt", "b.log", "c.jpg", "dir", "dir/d.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]uint16{"a.txt": zip.Store, "b.log": ZipMethodBzip2, "c.jpg": zip.Deflate, "dir/": zip.Store, "dir/d.json": ZipMethodBzip2}
+	for _, zf := range zr.File {
+		if zf.Method != expect[zf.Name] {
+			t.Errorf("%s: expected method %d, got %d", zf.Name, expect[zf.Name], zf.Method)
+		}
+	}
+	if raw := rawZipEntry(t, buf.Bytes(), "b.log"); !bytes.HasPrefix(raw, []byte("BZh3")) {
+		t.Errorf("b.log: expected level from CompressionLevels, got %q", raw[:4])
+	}
+	if raw := rawZipEntry(t, buf.Bytes(), "dir/d.json"); !bytes.HasPrefix(raw, []byte("BZh1")) {
+		t.Errorf("dir/d.json: expected level from CompressionFunc, got %q", raw[:4])
+	}
+}
+
+func TestZipSelectiveCompressionByContent(t *testing.T) {
+	random := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := []byte(strings.Repeat("compresses well, whatever its name\n", 3000))
+	fsys := fstest.MapFS{
+		"random.txt": {Data: random},
+		"text.jpg":   {Data: text},
+		"noext":      {Data: text},
+		"empty":      {},
+	}
+	files := mapFSFiles(t, fsys, "random.txt", "text.jpg", "noext", "empty")
+
+	// files can only be opened once, as when they come from another archive
+	opened := make(map[string]int)
+	for i, file := range files {
+		open := file.Open
+		files[i].Open = func() (fs.File, error) {
+			opened[file.NameInArchive]++
+			if opened[file.NameInArchive] > 1 {
+				return nil, errors.New("opened twice")
+			}
+			return open()
+		}
+	}
+
+	var buf bytes.Buffer
+	z := Zip{Compression: zip.Deflate, SelectiveCompressionByContent: true}
+	if err := z.Archive(context.Background(), &buf, files); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]uint16{"random.txt": zip.Store, "text.jpg": zip.Deflate, "noext": zip.Deflate, "empty": zip.Store}
+	for _, zf := range zr.File {
+		if zf.Method != expect[zf.Name] {
+			t.Errorf("%s: expected method %d, got %d", zf.Name, expect[zf.Name], zf.Method)
+		}
+	}
+	contents, errs, err := extractEntries(t, Zip{}, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, file := range fsys {
+		if contents[name] != string(file.Data) {
+			t.Errorf("%s: contents differ (errors: %v)", name, errs)
+		}
+	}
+}
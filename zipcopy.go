@@ -0,0 +1,145 @@
+package archives
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// copyRawFile writes f, the file in a zip archive that file comes from,
+// to zw in its compressed form, with the name and comment of file.
+func (z Zip) copyRawFile(zw *zip.Writer, idx int, file FileInfo, f *zip.File) error {
+	hdr := f.FileHeader
+	hdr.Name = file.NameInArchive
+	if hdr.Name == "" {
+		hdr.Name = f.Name
+	}
+	if file.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/" // required
+	}
+	hdr.Comment = file.Comment
+
+	// the name and comment may have been decoded, or changed
+	hdr.Flags &^= 0x800
+	if utf8.ValidString(hdr.Name) && utf8.ValidString(hdr.Comment) &&
+		(!isCP437Compatible(hdr.Name) || !isCP437Compatible(hdr.Comment)) {
+		hdr.Flags |= 0x800 // name and comment are UTF-8
+	}
+
+	// the writer adds a zip64 field of its own if the file needs one
+	hdr.Extra = withoutZipExtra(hdr.Extra, zip64ExtraID)
+
+	r, err := f.OpenRaw()
+	if err != nil {
+		return fmt.Errorf("opening file %d: %s: %w", idx, f.Name, err)
+	}
+	w, err := zw.CreateRaw(&hdr)
+	if err != nil {
+		return fmt.Errorf("creating header for file %d: %s: %w", idx, hdr.Name, err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("copying file %d: %s: %w", idx, hdr.Name, err)
+	}
+	return nil
+}
+
+// zipSourceFile returns the file in a zip archive that info is the
+// fs.FileInfo of, looking through the fs.FileInfo wrappers of this
+// package, or nil if it is not known to be of one.
+func zipSourceFile(info fs.FileInfo) *zip.File {
+	for {
+		switch fi := info.(type) {
+		case zipFileInfo:
+			return fi.file
+		case normalizedFileInfo:
+			info = fi.FileInfo
+		default:
+			return nil
+		}
+	}
+}
+
+// errZipNotRaw is returned (wrapped) when CopyRaw is set and a file
+// has the header of a file in a zip archive, but its fs.FileInfo is
+// not that of one, so it can't be copied in its compressed form.
+var errZipNotRaw = errors.New("FileInfo is not from Zip.Extract, so the file can't be copied raw")
+
+// withoutZipExtra returns a copy of the extra fields extra without
+// those with id.
+func withoutZipExtra(extra []byte, id uint16) []byte {
+	var kept []byte
+	for len(extra) >= 4 {
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
+		}
+		if binary.LittleEndian.Uint16(extra) != id {
+			kept = append(kept, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return kept
+}
+
+// Merge writes a zip archive to out with the files of the zip archives
+// inputs, in order, which are copied in their compressed form, as with
+// CopyRaw. Like for Extract, each input must be an io.ReaderAt and
+// io.Seeker. Files with the same name as one from an earlier input are
+// kept, left out, or renamed according to MergeConflict.
+func (z Zip) Merge(ctx context.Context, out io.Writer, inputs ...io.Reader) error {
+	var files []FileInfo
+	byName := make(map[string]int) // index of the file with each name
+
+	for i, input := range inputs {
+		err := z.Extract(ctx, input, func(ctx context.Context, file FileInfo) error {
+			name := file.NameInArchive
+			j, ok := byName[name]
+			if !ok {
+				byName[name] = len(files)
+				files = append(files, file)
+				return nil
+			}
+			if file.IsDir() && files[j].IsDir() {
+				return nil
+			}
+
+			switch z.MergeConflict {
+			case ConflictSkip:
+				return nil
+			case ConflictFail:
+				return fmt.Errorf("%s: %w", name, ErrDuplicateName)
+			case ConflictKeepNewer:
+				if !file.ModTime().After(files[j].ModTime()) {
+					return nil
+				}
+			case ConflictRename:
+				// only files are renamed, since directories have
+				// names of their own that end with a slash
+				for n := 1; ; n++ {
+					if _, ok := byName[numberedName(name, n)]; !ok {
+						file.NameInArchive = numberedName(name, n)
+						break
+					}
+				}
+				byName[file.NameInArchive] = len(files)
+				files = append(files, file)
+				return nil
+			}
+			files[j] = file
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading archive %d: %w", i, err)
+		}
+	}
+
+	z.CopyRaw = true
+	return z.Archive(ctx, out, files)
+}
@@ -0,0 +1,196 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipCopyRaw(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir":        {Mode: fs.ModeDir | 0o755},
+		"dir/a.txt":  {Data: []byte(strings.Repeat("copied as it is ", 100))},
+		"b.txt":      {Data: []byte("left out")},
+		"secret.txt": {Data: []byte(strings.Repeat("still encrypted ", 100))},
+	}
+	var src bytes.Buffer
+	z := Zip{
+		Compression: ZipMethodBzip2,
+		NTFSTimes:   true,
+		FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+			if file.NameInArchive == "secret.txt" {
+				return "secret", nil
+			}
+			return "", nil
+		},
+	}
+	if err := z.Archive(context.Background(), &src, mapFSFiles(t, fsys, "dir", "dir/a.txt", "b.txt", "secret.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// filter and rename the files, and copy them with a different method,
+	// which is not used, since they are not compressed again
+	var files []FileInfo
+	err := Zip{}.Extract(context.Background(), bytes.NewReader(src.Bytes()), func(ctx context.Context, file FileInfo) error {
+		switch file.NameInArchive {
+		case "b.txt":
+			return nil
+		case "dir/a.txt":
+			file.NameInArchive = "dir/renamed ✓.txt"
+			file.Comment = "renamed"
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err := (Zip{CopyRaw: true, Compression: zip.Deflate}).Archive(context.Background(), &dst, files); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := func(data []byte) map[string]zip.FileHeader {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs := make(map[string]zip.FileHeader)
+		for _, zf := range zr.File {
+			hdrs[zf.Name] = zf.FileHeader
+		}
+		return hdrs
+	}
+	srcHdrs, dstHdrs := headers(src.Bytes()), headers(dst.Bytes())
+	if len(dstHdrs) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(dstHdrs))
+	}
+	for srcName, dstName := range map[string]string{"dir/": "dir/", "dir/a.txt": "dir/renamed ✓.txt", "secret.txt": "secret.txt"} {
+		s, d := srcHdrs[srcName], dstHdrs[dstName]
+		if d.Method != s.Method || d.CRC32 != s.CRC32 || d.CompressedSize64 != s.CompressedSize64 || !bytes.Equal(d.Extra, s.Extra) ||
+			d.ModifiedDate != s.ModifiedDate || d.ModifiedTime != s.ModifiedTime {
+			t.Errorf("%s: expected header like %+v, got %+v", dstName, s, d)
+		}
+		if s.Method != zip.Store && !bytes.Equal(rawZipEntry(t, dst.Bytes(), dstName), rawZipEntry(t, src.Bytes(), srcName)) {
+			t.Errorf("%s: expected compressed data to be copied", dstName)
+		}
+	}
+	if d := dstHdrs["dir/renamed ✓.txt"]; d.Comment != "renamed" || d.Flags&0x800 == 0 {
+		t.Errorf("expected UTF-8 name and new comment, got flags %#x and comment %q", d.Flags, d.Comment)
+	}
+
+	contents, errs, err := extractEntries(t, Zip{Password: "secret"}, dst.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents["dir/renamed ✓.txt"] != string(fsys["dir/a.txt"].Data) || contents["secret.txt"] != string(fsys["secret.txt"].Data) {
+		t.Errorf("contents differ (errors: %v)", errs)
+	}
+}
+
+func TestZipCopyRawNormalized(t *testing.T) {
+	// written on Windows, with a backslash that the normalizer replaces
+	var src bytes.Buffer
+	zw := zip.NewWriter(&src)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: `dir\a.txt`, Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(strings.Repeat("copied as it is ", 100)))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []FileInfo
+	for _, file := range extractFileInfos(t, Zip{Normalizer: NameNormalizer{Separators: true}}, src.Bytes()) {
+		files = append(files, file)
+	}
+	if len(files) != 1 || files[0].NameInArchive != "dir/a.txt" || files[0].Name() != "a.txt" {
+		t.Fatalf("expected normalized file, got %+v", files)
+	}
+	var dst bytes.Buffer
+	if err := (Zip{CopyRaw: true, Compression: zip.Store}).Archive(context.Background(), &dst, files); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rawZipEntry(t, dst.Bytes(), "dir/a.txt"), rawZipEntry(t, src.Bytes(), `dir\a.txt`)) {
+		t.Error("expected compressed data to be copied")
+	}
+
+	// a FileInfo that can't be traced back to the zip file is an error
+	files[0].FileInfo = struct{ fs.FileInfo }{files[0].FileInfo}
+	err = (Zip{CopyRaw: true}).Archive(context.Background(), io.Discard, files)
+	if !errors.Is(err, errZipNotRaw) {
+		t.Errorf("expected errZipNotRaw, got %v", err)
+	}
+}
+
+func TestZipMerge(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	archive := func(data map[string]string, mtime time.Time) io.Reader {
+		fsys := fstest.MapFS{"dir": {Mode: fs.ModeDir | 0o755, ModTime: mtime}}
+		names := []string{"dir"}
+		for name, contents := range data {
+			fsys[name] = &fstest.MapFile{Data: []byte(contents), ModTime: mtime}
+			names = append(names, name)
+		}
+		var buf bytes.Buffer
+		if err := (Zip{Compression: zip.Deflate}).Archive(context.Background(), &buf, mapFSFiles(t, fsys, names...)); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(buf.Bytes())
+	}
+	inputs := func() []io.Reader {
+		return []io.Reader{
+			archive(map[string]string{"dir/a.txt": "first a", "b.txt": "first b"}, newer),
+			archive(map[string]string{"dir/a.txt": "second a", "c.txt": "second c"}, older),
+		}
+	}
+
+	for _, tc := range []struct {
+		policy ConflictPolicy
+		expect map[string]string
+	}{
+		{ConflictOverwrite, map[string]string{"dir/a.txt": "second a", "b.txt": "first b", "c.txt": "second c"}},
+		{ConflictSkip, map[string]string{"dir/a.txt": "first a", "b.txt": "first b", "c.txt": "second c"}},
+		{ConflictKeepNewer, map[string]string{"dir/a.txt": "first a", "b.txt": "first b", "c.txt": "second c"}},
+		{ConflictRename, map[string]string{"dir/a.txt": "first a", "dir/a (1).txt": "second a", "b.txt": "first b", "c.txt": "second c"}},
+	} {
+		var buf bytes.Buffer
+		if err := (Zip{MergeConflict: tc.policy}).Merge(context.Background(), &buf, inputs()...); err != nil {
+			t.Fatalf("policy %d: %v", tc.policy, err)
+		}
+		contents, errs, err := extractEntries(t, Zip{}, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, expect := range tc.expect {
+			if contents[name] != expect {
+				t.Errorf("policy %d: %s: expected %q, got %q (errors: %v)", tc.policy, name, expect, contents[name], errs)
+			}
+		}
+		if len(contents) != len(tc.expect) {
+			t.Errorf("policy %d: expected %d files, got %d", tc.policy, len(tc.expect), len(contents))
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != len(tc.expect)+1 {
+			t.Errorf("policy %d: expected the directory to be merged, got %d entries", tc.policy, len(zr.File))
+		}
+	}
+
+	err := (Zip{MergeConflict: ConflictFail}).Merge(context.Background(), io.Discard, inputs()...)
+	if !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("expected %v, got %v", ErrDuplicateName, err)
+	}
+}
@@ -0,0 +1,600 @@
+package archives
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// Zip entries can be encrypted with the traditional PKWARE cipher (often
+// called ZipCrypto), which is weak but universally supported, or with
+// WinZip's AES scheme (AE-1 and AE-2), which is documented at
+// https://www.winzip.com/en/support/aes-encryption/. The zip package
+// can't decrypt either, so encrypted entries are read raw and decrypted
+// and decompressed here. When writing, only AE-2 with 256-bit keys is
+// used, since the traditional cipher is easily broken.
+
+const (
+	zipFlagEncrypted       = 0x1
+	zipFlagDataDescriptor  = 0x8
+	zipFlagStrongEncrypted = 0x40
+
+	// zipMethodAES is the method of entries encrypted with AES;
+	// their real method is in their AES extra field
+	zipMethodAES = 99
+
+	zipExtraAES = 0x9901
+
+	// zipVersionAES is the version needed to extract AES encrypted entries
+	zipVersionAES = 51
+
+	zipDataDescriptorSignature = 0x08074b50
+)
+
+// isEncryptedZipEntry returns true if the entry's data is encrypted.
+func isEncryptedZipEntry(hdr *zip.FileHeader) bool {
+	return hdr.Flags&zipFlagEncrypted != 0
+}
+
+// openEncrypted opens the encrypted entry f for reading, decrypting it with
+// password. Like (*zip.File).Open, the returned reader verifies the checksum
+// of the entry once it has been read completely, except for AE-2 entries,
+// which are authenticated instead.
+func openEncrypted(f *zip.File, password string) (io.ReadCloser, error) {
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if f.Flags&zipFlagStrongEncrypted != 0 {
+		return nil, fmt.Errorf("PKWARE strong encryption is not supported")
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	method := f.Method
+	checkCRC := true
+	var decrypted io.Reader
+	if method == zipMethodAES {
+		aesInfo, err := parseZipAESExtra(f.Extra)
+		if err != nil {
+			return nil, err
+		}
+		decrypted, err = newZipAESReader(raw, f.CompressedSize64, aesInfo.strength, password)
+		if err != nil {
+			return nil, err
+		}
+		method = aesInfo.method
+		checkCRC = aesInfo.version == 1 // AE-2 does not store the CRC
+	} else {
+		// the last byte of the encryption header is checked against the CRC,
+		// or, if the CRC was not known in advance, against the modification time
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&zipFlagDataDescriptor != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		decrypted, err = newZipCryptoReader(raw, password, check)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dcomp := zipDecompressor(method, f.UncompressedSize64)
+	if dcomp == nil {
+		return nil, zip.ErrAlgorithm
+	}
+	rc := dcomp(decrypted)
+	if rc == nil {
+		return nil, fmt.Errorf("initializing decompressor for method %d", method)
+	}
+
+	return &zipChecksumReader{
+		rc:       rc,
+		hash:     crc32.NewIEEE(),
+		checkCRC: checkCRC,
+		crc32:    f.CRC32,
+		size:     f.UncompressedSize64,
+	}, nil
+}
+
+// zipAESInfo is the content of the AES extra field of an entry.
+type zipAESInfo struct {
+	version  uint16 // 1 for AE-1, 2 for AE-2
+	strength byte   // 1, 2, or 3 for 128-, 192-, or 256-bit keys
+	method   uint16 // the actual compression method
+}
+
+func parseZipAESExtra(extra []byte) (zipAESInfo, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraAES {
+			continue
+		}
+		if size < 7 || string(field[2:4]) != "AE" {
+			return zipAESInfo{}, fmt.Errorf("malformed AES extra field")
+		}
+		info := zipAESInfo{
+			version:  binary.LittleEndian.Uint16(field[0:2]),
+			strength: field[4],
+			method:   binary.LittleEndian.Uint16(field[5:7]),
+		}
+		if info.strength < 1 || info.strength > 3 {
+			return zipAESInfo{}, fmt.Errorf("unsupported AES strength %d", info.strength)
+		}
+		return info, nil
+	}
+	return zipAESInfo{}, fmt.Errorf("AES encrypted entry has no AES extra field")
+}
+
+// zipAESExtra returns the AES extra field for info.
+func zipAESExtra(info zipAESInfo) []byte {
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, info.version)
+	extra = append(extra, 'A', 'E', info.strength)
+	return binary.LittleEndian.AppendUint16(extra, info.method)
+}
+
+// zipAESKeys derives the encryption key, authentication key, and password
+// verifier for an AES encrypted entry with the given strength and salt.
+func zipAESKeys(password string, salt []byte, strength byte) (key, authKey, verifier []byte, err error) {
+	keyLen := 8 + 8*int(strength) // 16, 24, or 32
+	derived, err := pbkdf2.Key(sha1.New, password, salt, 1000, 2*keyLen+2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return derived[:keyLen], derived[keyLen : 2*keyLen], derived[2*keyLen:], nil
+}
+
+// zipAESSaltLen returns the length of the salt for the given strength.
+func zipAESSaltLen(strength byte) int {
+	return 4 + 4*int(strength) // 8, 12, or 16
+}
+
+// zipAESReader decrypts and authenticates the data of an AES
+// encrypted entry.
+type zipAESReader struct {
+	r    io.Reader // the encrypted data
+	raw  io.Reader // the rest of the entry, i.e. the authentication code
+	ctr  *zipAESCTR
+	mac  hash.Hash
+	done bool
+}
+
+// newZipAESReader reads the salt and password verifier from raw, which
+// contains all compressedSize bytes of the entry's data, and returns a
+// reader of the decrypted data.
+func newZipAESReader(raw io.Reader, compressedSize uint64, strength byte, password string) (*zipAESReader, error) {
+	saltLen := zipAESSaltLen(strength)
+	overhead := uint64(saltLen + 2 + zipAESMACLen)
+	if compressedSize < overhead {
+		return nil, fmt.Errorf("AES encrypted entry is too short")
+	}
+
+	header := make([]byte, saltLen+2)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, fmt.Errorf("reading AES header: %w", err)
+	}
+	key, authKey, verifier, err := zipAESKeys(password, header[:saltLen], strength)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(verifier, header[saltLen:]) {
+		return nil, ErrWrongPassword
+	}
+
+	ctr, err := newZipAESCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESReader{
+		r:   io.LimitReader(raw, int64(compressedSize-overhead)),
+		raw: raw,
+		ctr: ctr,
+		mac: hmac.New(sha1.New, authKey),
+	}, nil
+}
+
+func (ar *zipAESReader) Read(p []byte) (int, error) {
+	if ar.done {
+		return 0, io.EOF
+	}
+	n, err := ar.r.Read(p)
+	ar.mac.Write(p[:n])
+	ar.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		ar.done = true
+		var code [zipAESMACLen]byte
+		if _, err := io.ReadFull(ar.raw, code[:]); err != nil {
+			return n, fmt.Errorf("reading AES authentication code: %w", err)
+		}
+		if !hmac.Equal(code[:], ar.mac.Sum(nil)[:zipAESMACLen]) {
+			return n, fmt.Errorf("AES authentication failed: %w", zip.ErrChecksum)
+		}
+	}
+	return n, err
+}
+
+// zipAESWriter encrypts and authenticates the data of an AES
+// encrypted entry.
+type zipAESWriter struct {
+	w      io.Writer
+	header []byte // salt and password verifier, until written
+	ctr    *zipAESCTR
+	mac    hash.Hash
+	buf    []byte
+}
+
+// newZipAESWriter returns a writer that encrypts data before writing it
+// to w, preceded by a random salt and the password verifier. Nothing is
+// written to w until the first write, since the zip writers create the
+// compressor before they write the local file header. The authentication
+// code is written when the writer is closed.
+func newZipAESWriter(w io.Writer, password string, strength byte) (*zipAESWriter, error) {
+	salt := make([]byte, zipAESSaltLen(strength))
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, authKey, verifier, err := zipAESKeys(password, salt, strength)
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := newZipAESCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESWriter{
+		w:      w,
+		header: append(salt, verifier...),
+		ctr:    ctr,
+		mac:    hmac.New(sha1.New, authKey),
+	}, nil
+}
+
+func (aw *zipAESWriter) writeHeader() error {
+	if aw.header == nil {
+		return nil
+	}
+	_, err := aw.w.Write(aw.header)
+	aw.header = nil
+	return err
+}
+
+func (aw *zipAESWriter) Write(p []byte) (int, error) {
+	if err := aw.writeHeader(); err != nil {
+		return 0, err
+	}
+	aw.buf = append(aw.buf[:0], p...)
+	aw.ctr.XORKeyStream(aw.buf, aw.buf)
+	aw.mac.Write(aw.buf)
+	return aw.w.Write(aw.buf)
+}
+
+// Close writes the authentication code; it does not close the underlying writer.
+func (aw *zipAESWriter) Close() error {
+	if err := aw.writeHeader(); err != nil {
+		return err
+	}
+	_, err := aw.w.Write(aw.mac.Sum(nil)[:zipAESMACLen])
+	return err
+}
+
+// newZipAESCompressor returns a compressor that compresses data with comp and
+// encrypts the result with AES-256 using password, for entries whose header
+// has the AES extra field. The zip writer calls the compressor after it has
+// filled in the header, so started is called then to adjust the header, and
+// finished is called once the authentication code has been written, right
+// before the zip writer writes the data descriptor.
+func newZipAESCompressor(comp zip.Compressor, password string, started func(), finished func() error) zip.Compressor {
+	return func(out io.Writer) (io.WriteCloser, error) {
+		aw, err := newZipAESWriter(out, password, 3)
+		if err != nil {
+			return nil, err
+		}
+		cw, err := comp(aw)
+		if err != nil {
+			return nil, err
+		}
+		started()
+		return &zipAESCompressor{cw, aw, finished}, nil
+	}
+}
+
+type zipAESCompressor struct {
+	io.WriteCloser // the compressor, which writes to aw
+	aw             *zipAESWriter
+	finished       func() error
+}
+
+func (ac *zipAESCompressor) Close() error {
+	if err := ac.WriteCloser.Close(); err != nil {
+		return err
+	}
+	if err := ac.aw.Close(); err != nil {
+		return err
+	}
+	return ac.finished()
+}
+
+// createEncrypted adds an entry for hdr, whose Method is the compression
+// method to use, to zw, and returns a writer that compresses and encrypts
+// its contents with AES-256 as an AE-2 entry. The writer must be closed
+// before the next entry is added.
+//
+// The entry is written raw, since zip writers compute the CRC of the data
+// they compress, which AE-2 entries must not store, as it would give away
+// information about the encrypted data. So hdr is filled in here the way
+// (*zip.Writer).CreateHeader would, and its sizes once it is closed; its
+// times must already be in its DOS date and time and extra fields (see
+// Zip.zipExtra).
+func createEncrypted(zw *zip.Writer, hdr *zip.FileHeader, password string, comp zip.Compressor) (io.WriteCloser, error) {
+	if comp == nil {
+		return nil, zip.ErrAlgorithm
+	}
+
+	hdr.Extra = append(hdr.Extra, zipAESExtra(zipAESInfo{version: 2, strength: 3, method: hdr.Method})...)
+	hdr.Method = zipMethodAES
+	hdr.Flags |= zipFlagEncrypted | zipFlagDataDescriptor
+	if !hdr.NonUTF8 && utf8.ValidString(hdr.Name) && utf8.ValidString(hdr.Comment) &&
+		(!isCP437Compatible(hdr.Name) || !isCP437Compatible(hdr.Comment)) {
+		hdr.Flags |= 0x800 // name and comment are UTF-8
+	}
+	hdr.CreatorVersion = hdr.CreatorVersion&0xff00 | 20
+	hdr.ReaderVersion = zipVersionAES
+	hdr.CRC32 = 0
+	hdr.CompressedSize64, hdr.UncompressedSize64 = 0, 0
+
+	w, err := zw.CreateRaw(hdr)
+	if err != nil {
+		return nil, err
+	}
+	ew := &zipEncryptedEntry{hdr: hdr, raw: &countingWriter{w: w}}
+	ew.aw, err = newZipAESWriter(ew.raw, password, 3)
+	if err != nil {
+		return nil, err
+	}
+	ew.cw, err = comp(ew.aw)
+	if err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+// zipEncryptedEntry writes the contents of an entry made by createEncrypted.
+type zipEncryptedEntry struct {
+	hdr  *zip.FileHeader
+	cw   io.WriteCloser // compresses into aw
+	aw   *zipAESWriter  // encrypts into raw
+	raw  *countingWriter
+	size int64
+}
+
+func (ew *zipEncryptedEntry) Write(p []byte) (int, error) {
+	n, err := ew.cw.Write(p)
+	ew.size += int64(n)
+	return n, err
+}
+
+func (ew *zipEncryptedEntry) Close() error {
+	if err := ew.cw.Close(); err != nil {
+		return err
+	}
+	if err := ew.aw.Close(); err != nil {
+		return err
+	}
+
+	// the zip writer writes these to the data descriptor
+	// and central directory of the entry
+	ew.hdr.CompressedSize64 = uint64(ew.raw.n)
+	ew.hdr.UncompressedSize64 = uint64(ew.size)
+	ew.hdr.CompressedSize = uint32(min(ew.raw.n, math.MaxUint32))
+	ew.hdr.UncompressedSize = uint32(min(ew.size, math.MaxUint32))
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// isCP437Compatible returns true if s can be stored in a zip archive
+// without setting the UTF-8 flag; like the zip package, it only allows
+// the characters that CP-437 and ASCII have in common.
+func isCP437Compatible(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			return false
+		}
+	}
+	return true
+}
+
+// timeToMsDosTime converts t to an MS-DOS date and time, which
+// have a resolution of two seconds and no time zone.
+func timeToMsDosTime(t time.Time) (date uint16, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// zipCRCEraser sits between the updater that Insert uses and its output,
+// and erases the CRC-32 of AE-2 entries from their data descriptors, for
+// the same reason as createEncrypted; unlike zip.Writer, the updater can't
+// write entries raw. It does not buffer its output, so when erased is set,
+// the data descriptor is at the start of the next write.
+type zipCRCEraser struct {
+	w io.Writer
+
+	// if set, the next write, which must be a data descriptor, has its
+	// CRC erased, and erased is called so that it can be erased from the
+	// header of the entry too, before the central directory is written
+	erased func()
+}
+
+func (ce *zipCRCEraser) Write(p []byte) (int, error) {
+	if ce.erased != nil {
+		if len(p) < 8 || binary.LittleEndian.Uint32(p) != zipDataDescriptorSignature {
+			return 0, fmt.Errorf("expected data descriptor after AES encrypted entry")
+		}
+		p = bytes.Clone(p)
+		clear(p[4:8])
+		ce.erased()
+		ce.erased = nil
+	}
+	return ce.w.Write(p)
+}
+
+// zipAESMACLen is the length of the authentication code after the
+// data of an AES encrypted entry; it is a truncated HMAC-SHA1.
+const zipAESMACLen = 10
+
+// zipAESCTR is AES in counter mode as WinZip uses it, with a little-endian
+// counter that starts at 1, unlike the big-endian one of cipher.NewCTR.
+type zipAESCTR struct {
+	block   cipher.Block
+	counter uint64
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newZipAESCTR(key []byte) (*zipAESCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESCTR{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *zipAESCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			c.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], c.counter)
+			c.block.Encrypt(c.stream[:], ctr[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// zipCryptoKeys is the state of the traditional PKWARE cipher.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) streamByte() byte {
+	temp := k[2] | 2
+	return byte((temp * (temp ^ 1)) >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(p []byte) {
+	for i, c := range p {
+		p[i] = c ^ k.streamByte()
+		k.update(p[i])
+	}
+}
+
+// zipCryptoHeaderLen is the length of the encryption header that
+// precedes the data of entries encrypted with the traditional cipher.
+const zipCryptoHeaderLen = 12
+
+// zipCryptoReader decrypts data encrypted with the traditional cipher.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+// newZipCryptoReader reads and checks the encryption header from r, and
+// returns a reader of the decrypted data that follows it. The last byte of
+// the header must match check, which catches most wrong passwords.
+func newZipCryptoReader(r io.Reader, password string, check byte) (*zipCryptoReader, error) {
+	keys := newZipCryptoKeys(password)
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	keys.decrypt(header[:])
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, ErrWrongPassword
+	}
+	return &zipCryptoReader{r: r, keys: keys}, nil
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.keys.decrypt(p[:n])
+	return n, err
+}
+
+// zipChecksumReader verifies the size and, optionally, the CRC-32 of
+// the decompressed data of an entry when it reaches the end.
+type zipChecksumReader struct {
+	rc       io.ReadCloser
+	hash     hash.Hash32
+	checkCRC bool
+	crc32    uint32
+	size     uint64
+	read     uint64
+}
+
+func (cr *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.hash.Write(p[:n])
+	cr.read += uint64(n)
+	if cr.read > cr.size {
+		return n, zip.ErrFormat
+	}
+	if err == io.EOF {
+		if cr.read != cr.size {
+			return n, io.ErrUnexpectedEOF
+		}
+		if cr.checkCRC && cr.hash.Sum32() != cr.crc32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+func (cr *zipChecksumReader) Close() error { return cr.rc.Close() }
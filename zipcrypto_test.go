@@ -0,0 +1,384 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZipCrypto(t *testing.T) {
+	// created with Info-ZIP's zip -P, which always writes data descriptors
+	data, err := os.ReadFile("testdata/encrypted.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"secret.txt": strings.Repeat("hello, encrypted world\n", 50),
+		"small.txt":  "tiny\n",
+		"-":          "streamed\n",
+	}
+
+	contents, errs, err := extractEntries(t, Zip{Password: "hunter2"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for name, want := range expect {
+		if contents[name] != want {
+			t.Errorf("%s: expected %q, got %q", name, want, contents[name])
+		}
+	}
+
+	for _, password := range []string{"", "hunter3"} {
+		_, errs, err := extractEntries(t, Zip{Password: password}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != len(expect) {
+			t.Errorf("password %q: expected every entry to fail, got errors %v", password, errs)
+		}
+	}
+
+	// without a data descriptor, the header is checked against the CRC
+	plain := []byte("checked against the CRC")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "crc.txt",
+		Method:             zip.Store,
+		Flags:              zipFlagEncrypted,
+		CRC32:              crc32.ChecksumIEEE(plain),
+		CompressedSize64:   uint64(zipCryptoHeaderLen + len(plain)),
+		UncompressedSize64: uint64(len(plain)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(zipCryptoEncrypt("secret", crc32.ChecksumIEEE(plain), plain))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	contents, errs, err = extractEntries(t, Zip{Password: "secret"}, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents["crc.txt"] != string(plain) {
+		t.Errorf("expected %q, got %q (errors: %v)", plain, contents["crc.txt"], errs)
+	}
+}
+
+func TestZipAES(t *testing.T) {
+	plain := []byte(strings.Repeat("attack at dawn; ", 100))
+
+	for _, tc := range []struct {
+		name     string
+		version  uint16
+		strength byte
+		method   uint16
+	}{
+		{"ae1-128-store", 1, 1, zip.Store},
+		{"ae2-192-deflate", 2, 2, zip.Deflate},
+		{"ae2-256-deflate", 2, 3, zip.Deflate},
+		{"ae1-256-zstd", 1, 3, ZipMethodZstd},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := makeAESZip(t, tc.name, plain, "correct horse", tc.version, tc.strength, tc.method)
+
+			contents, errs, err := extractEntries(t, Zip{Password: "correct horse"}, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contents[tc.name] != string(plain) {
+				t.Errorf("contents differ (errors: %v)", errs)
+			}
+
+			if _, errs, _ := extractEntries(t, Zip{Password: "battery staple"}, data); errs[tc.name] == nil {
+				t.Error("expected error with wrong password")
+			}
+
+			// flip a bit of the encrypted data, just before the authentication code
+			tampered := bytes.Clone(data)
+			dataEnd := bytes.Index(tampered, binary.LittleEndian.AppendUint32(nil, zipCentralSignature)) - zipAESMACLen
+			tampered[dataEnd-1] ^= 1
+			if _, errs, _ := extractEntries(t, Zip{Password: "correct horse"}, tampered); errs[tc.name] == nil {
+				t.Error("expected error with tampered data")
+			}
+		})
+	}
+}
+
+func TestZipAESArchive(t *testing.T) {
+	plain := strings.Repeat("attack at dawn; ", 100)
+	fsys := fstest.MapFS{
+		"dir":          {Mode: fs.ModeDir | 0o755},
+		"dir/plan.txt": {Data: []byte(plain)},
+		"public.txt":   {Data: []byte("nothing to see here")},
+	}
+	files := mapFSFiles(t, fsys, "dir", "dir/plan.txt", "public.txt")
+
+	for _, method := range []uint16{zip.Store, zip.Deflate, ZipMethodBzip2, ZipMethodZstd, ZipMethodXz} {
+		var buf bytes.Buffer
+		err := Zip{Compression: method, Password: "correct horse"}.Archive(context.Background(), &buf, files)
+		if err != nil {
+			t.Fatalf("method %d: %v", method, err)
+		}
+		checkAE2Entries(t, buf.Bytes(), "dir/plan.txt", "public.txt")
+		for name, file := range extractFileInfos(t, Zip{}, buf.Bytes()) {
+			if !file.Encrypted && !file.IsDir() {
+				t.Errorf("method %d: %s: expected file to be reported as encrypted", method, name)
+			}
+		}
+
+		contents, errs, err := extractEntries(t, Zip{Password: "correct horse"}, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents["dir/plan.txt"] != plain || contents["public.txt"] != "nothing to see here" {
+			t.Errorf("method %d: contents differ (errors: %v)", method, errs)
+		}
+		if _, errs, _ := extractEntries(t, Zip{Password: "battery staple"}, buf.Bytes()); len(errs) != 2 {
+			t.Errorf("method %d: expected every file to fail with wrong password, got errors %v", method, errs)
+		}
+	}
+
+	// with a password for each file, and none for some
+	z := Zip{
+		Compression: zip.Deflate,
+		FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+			if file.NameInArchive == "public.txt" {
+				return "", nil
+			}
+			return "secret for " + file.NameInArchive, nil
+		},
+	}
+	jobs := make(chan ArchiveAsyncJob)
+	results := make(chan error)
+	var buf bytes.Buffer
+	go func() {
+		for _, file := range files {
+			jobs <- ArchiveAsyncJob{File: file, Result: results}
+			if err := <-results; err != nil {
+				t.Error(err)
+			}
+		}
+		close(jobs)
+	}()
+	if err := z.ArchiveAsync(context.Background(), &buf, jobs); err != nil {
+		t.Fatal(err)
+	}
+	checkAE2Entries(t, buf.Bytes(), "dir/plan.txt")
+	for name, file := range extractFileInfos(t, Zip{}, buf.Bytes()) {
+		if file.Encrypted != (name == "dir/plan.txt") {
+			t.Errorf("%s: unexpected Encrypted: %v", name, file.Encrypted)
+		}
+	}
+	contents, errs, err := extractEntries(t, Zip{Password: "secret for dir/plan.txt"}, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents["dir/plan.txt"] != plain || contents["public.txt"] != "nothing to see here" {
+		t.Errorf("contents differ: %q (errors: %v)", contents, errs)
+	}
+}
+
+func TestZipAESInsert(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("first")},
+		"b.txt": {Data: []byte("second")},
+		"c.txt": {Data: []byte("third")},
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := (Zip{Password: "open sesame"}).Archive(context.Background(), f, mapFSFiles(t, fsys, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []uint16{zip.Deflate, ZipMethodZstd} {
+		z := Zip{SelectiveCompression: true, Compression: method, Password: "open sesame"}
+		if err := z.Insert(context.Background(), f, mapFSFiles(t, fsys, "b.txt", "c.txt")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAE2Entries(t, data, "a.txt", "b.txt", "c.txt")
+	contents, errs, err := extractEntries(t, Zip{Password: "open sesame"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for name, file := range fsys {
+		if contents[name] != string(file.Data) {
+			t.Errorf("%s: expected %q, got %q", name, file.Data, contents[name])
+		}
+	}
+}
+
+// checkAE2Entries checks that the named entries of the zip archive are
+// encrypted with AES-256 as AE-2, which does not store the CRC.
+func checkAE2Entries(t *testing.T, data []byte, names ...string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		found := false
+		for _, zf := range zr.File {
+			if zf.Name != name {
+				continue
+			}
+			found = true
+			aesInfo, err := parseZipAESExtra(zf.Extra)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if zf.Method != zipMethodAES || aesInfo.version != 2 || aesInfo.strength != 3 || zf.CRC32 != 0 {
+				t.Errorf("%s: expected AE-2 with 256-bit key and no CRC, got method %d, %+v, CRC %x",
+					name, zf.Method, aesInfo, zf.CRC32)
+			}
+
+			// the CRC must not be in the data descriptor either
+			offset, err := zf.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			descriptor := data[offset+int64(zf.CompressedSize64):]
+			if binary.LittleEndian.Uint32(descriptor) != zipDataDescriptorSignature {
+				t.Errorf("%s: expected data descriptor", name)
+			} else if crc := binary.LittleEndian.Uint32(descriptor[4:]); crc != 0 {
+				t.Errorf("%s: found CRC %x in data descriptor", name, crc)
+			}
+		}
+		if !found {
+			t.Errorf("%s: not in archive", name)
+		}
+	}
+
+}
+
+// mapFSFiles returns the named files of fsys, to be archived.
+func mapFSFiles(t *testing.T, fsys fstest.MapFS, names ...string) []FileInfo {
+	t.Helper()
+	var files []FileInfo
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileInfo{
+			FileInfo:      info,
+			NameInArchive: name,
+			Open:          func() (fs.File, error) { return fsys.Open(name) },
+		})
+	}
+	return files
+}
+
+// makeAESZip creates a zip archive with one entry, compressed
+// with method and encrypted with AES as WinZip does.
+func makeAESZip(t *testing.T, name string, plain []byte, password string, version uint16, strength byte, method uint16) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	var cw io.WriteCloser
+	switch method {
+	case zip.Store:
+		compressed.Write(plain)
+	case zip.Deflate:
+		cw, _ = flate.NewWriter(&compressed, flate.DefaultCompression)
+	case ZipMethodZstd:
+		cw, _ = zstd.NewWriter(&compressed)
+	}
+	if cw != nil {
+		cw.Write(plain)
+		cw.Close()
+	}
+
+	salt := bytes.Repeat([]byte{0x5a}, zipAESSaltLen(strength))
+	key, authKey, verifier, err := zipAESKeys(password, salt, strength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := bytes.Clone(compressed.Bytes())
+	ctr, err := newZipAESCTR(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctr.XORKeyStream(encrypted, encrypted)
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(encrypted)
+
+	var payload bytes.Buffer
+	payload.Write(salt)
+	payload.Write(verifier)
+	payload.Write(encrypted)
+	payload.Write(mac.Sum(nil)[:zipAESMACLen])
+
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, version)
+	extra = append(extra, 'A', 'E', strength)
+	extra = binary.LittleEndian.AppendUint16(extra, method)
+
+	hdr := &zip.FileHeader{
+		Name:               name,
+		Method:             zipMethodAES,
+		Flags:              zipFlagEncrypted,
+		Extra:              extra,
+		CompressedSize64:   uint64(payload.Len()),
+		UncompressedSize64: uint64(len(plain)),
+	}
+	if version == 1 {
+		hdr.CRC32 = crc32.ChecksumIEEE(plain)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(payload.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipCryptoEncrypt encrypts plain with the traditional PKWARE cipher,
+// preceded by an encryption header that ends with the top byte of crc.
+func zipCryptoEncrypt(password string, crc uint32, plain []byte) []byte {
+	keys := newZipCryptoKeys(password)
+	out := make([]byte, zipCryptoHeaderLen, zipCryptoHeaderLen+len(plain))
+	out[zipCryptoHeaderLen-1] = byte(crc >> 24)
+	out = append(out, plain...)
+	for i, p := range out {
+		out[i] = p ^ keys.streamByte()
+		keys.update(p)
+	}
+	return out
+}
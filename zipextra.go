@@ -0,0 +1,288 @@
+package archives
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"io/fs"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// The extra fields of zip file headers that record what the rest of the
+// header can't: times more precise than the DOS date and time, which only
+// has 2-second resolution and no time zone, and the owner of the file.
+// See APPNOTE.TXT section 4.5 and Info-ZIP's extrafld.txt.
+const (
+	zipExtraNTFS      = 0x000a
+	zipExtraPKUnix    = 0x000d // PKWARE Unix
+	zipExtraTimestamp = 0x5455 // extended timestamp
+	zipExtraUnixOld   = 0x5855 // Info-ZIP Unix, first version
+	zipExtraUnix      = 0x7875 // Info-ZIP Unix, current version
+)
+
+// ZipExtra is what the extra fields of a zip file header say about the
+// file beyond the rest of the header. The times are zero if not recorded.
+type ZipExtra struct {
+	ModTime      time.Time
+	AccessTime   time.Time
+	CreationTime time.Time
+
+	// The owner of the file, if HasOwner is true.
+	Uid, Gid int
+	HasOwner bool
+}
+
+// ParseZipExtra parses the extra fields of a zip file header, such as the
+// Extra field of the zip.FileHeader that is the Header of the files that
+// Zip.Extract returns. It reads the NTFS (0x000a), extended timestamp
+// (0x5455), and Unix (0x7875, 0x5855, 0x000d) fields; the times of NTFS
+// fields, which are to 100 nanoseconds, take precedence over the others,
+// which are only to the second. Fields that are unknown or malformed are
+// skipped.
+//
+// Headers in the central directory of a zip file, which is what Extract
+// reads, usually only have the modification time of the file, while the
+// local headers before the contents of each file may have all of them.
+func ParseZipExtra(extra []byte) ZipExtra {
+	var x ZipExtra
+	var ntfs, timestamp, unix [3]time.Time // modification, access, creation
+	var oldOwner ZipExtra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		data := extra[:size]
+		extra = extra[size:]
+
+		switch id {
+		case zipExtraNTFS:
+			// 4 reserved bytes, then attributes, of which tag 1 has the times
+			if len(data) < 4 {
+				continue
+			}
+			for data = data[4:]; len(data) >= 4; {
+				tag := binary.LittleEndian.Uint16(data)
+				tagSize := int(binary.LittleEndian.Uint16(data[2:]))
+				data = data[4:]
+				if tagSize > len(data) {
+					break
+				}
+				if tag == 1 && tagSize >= 24 {
+					for i := range ntfs {
+						ntfs[i] = ntfsTime(binary.LittleEndian.Uint64(data[i*8:]))
+					}
+				}
+				data = data[tagSize:]
+			}
+
+		case zipExtraTimestamp:
+			// the flags say which times the local header has; the one
+			// in the central directory may only have the first of them
+			if len(data) < 1 {
+				continue
+			}
+			flags := data[0]
+			data = data[1:]
+			for i := range timestamp {
+				if flags&(1<<i) == 0 || len(data) < 4 {
+					continue
+				}
+				timestamp[i] = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+				data = data[4:]
+			}
+
+		case zipExtraUnixOld, zipExtraPKUnix:
+			// access and modification times, then the 16-bit uid and gid,
+			// which are only in local headers
+			if len(data) < 8 {
+				continue
+			}
+			unix[1] = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+			unix[0] = time.Unix(int64(binary.LittleEndian.Uint32(data[4:])), 0)
+			if len(data) >= 12 {
+				oldOwner.Uid = int(binary.LittleEndian.Uint16(data[8:]))
+				oldOwner.Gid = int(binary.LittleEndian.Uint16(data[10:]))
+				oldOwner.HasOwner = true
+			}
+
+		case zipExtraUnix:
+			// version 1, then the uid and gid, each preceded by its size
+			if len(data) < 1 || data[0] != 1 {
+				continue
+			}
+			uid, rest, ok := zipExtraID(data[1:])
+			if !ok {
+				continue
+			}
+			gid, _, ok := zipExtraID(rest)
+			if !ok {
+				continue
+			}
+			x.Uid, x.Gid, x.HasOwner = uid, gid, true
+		}
+	}
+
+	if !x.HasOwner {
+		x.Uid, x.Gid, x.HasOwner = oldOwner.Uid, oldOwner.Gid, oldOwner.HasOwner
+	}
+	for i, t := range []*time.Time{&x.ModTime, &x.AccessTime, &x.CreationTime} {
+		for _, fieldTimes := range [][3]time.Time{ntfs, timestamp, unix} {
+			if !fieldTimes[i].IsZero() {
+				*t = fieldTimes[i]
+				break
+			}
+		}
+	}
+	return x
+}
+
+// zipExtraID reads a uid or gid of the Unix extra field, which is
+// preceded by its size, and returns what follows it.
+func zipExtraID(b []byte) (int, []byte, bool) {
+	if len(b) < 1 {
+		return 0, nil, false
+	}
+	size := int(b[0])
+	b = b[1:]
+	if size > len(b) || size > 8 {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint64(b[i])
+	}
+	if id > math.MaxInt32 {
+		return 0, nil, false
+	}
+	return int(id), b[size:], true
+}
+
+// ntfsEpoch is January 1, 1601 UTC, from which NTFS times count
+// 100-nanosecond intervals, in those intervals since the Unix epoch.
+const ntfsEpoch = -116444736000000000
+
+// ntfsTime converts an NTFS time to a time.Time; 0 is the zero time.
+func ntfsTime(t uint64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	ticks := int64(t) + ntfsEpoch
+	return time.Unix(ticks/1e7, ticks%1e7*100)
+}
+
+// toNTFSTime converts t to an NTFS time.
+func toNTFSTime(t time.Time) uint64 {
+	return uint64(t.Unix()*1e7 + int64(t.Nanosecond()/100) - ntfsEpoch)
+}
+
+// zipExtra returns the extra fields that the header of file is written
+// with, and its modification time as a DOS date and time in the time zone
+// of z, or in that of the time itself if z has none. The zip writers add
+// an extended timestamp field of their own to headers that have a Modified
+// time, so headers given these must not.
+func (z Zip) zipExtra(file FileInfo) (extra []byte, date, tm uint16) {
+	mtime := file.ModTime()
+	if z.TimeZone != nil {
+		date, tm = timeToMsDosTime(mtime.In(z.TimeZone))
+	} else {
+		date, tm = timeToMsDosTime(mtime)
+	}
+
+	// the extended timestamp field only has the modification time, since
+	// the headers in the central directory shouldn't have the others
+	if secs := mtime.Unix(); secs >= 0 && secs <= math.MaxUint32 {
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraTimestamp)
+		extra = binary.LittleEndian.AppendUint16(extra, 5)
+		extra = append(extra, 1)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(secs))
+	}
+
+	meta := zipSourceExtra(file)
+	if meta.HasOwner && meta.Uid >= 0 && meta.Gid >= 0 {
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraUnix)
+		extra = binary.LittleEndian.AppendUint16(extra, 11)
+		extra = append(extra, 1, 4)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(meta.Uid))
+		extra = append(extra, 4)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(meta.Gid))
+	}
+
+	if z.NTFSTimes {
+		atime, ctime := meta.AccessTime, meta.CreationTime
+		if atime.IsZero() {
+			atime = mtime
+		}
+		if ctime.IsZero() {
+			ctime = mtime
+		}
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraNTFS)
+		extra = binary.LittleEndian.AppendUint16(extra, 32)
+		extra = binary.LittleEndian.AppendUint32(extra, 0) // reserved
+		extra = binary.LittleEndian.AppendUint16(extra, 1) // tag of the times
+		extra = binary.LittleEndian.AppendUint16(extra, 24)
+		for _, t := range []time.Time{mtime, atime, ctime} {
+			extra = binary.LittleEndian.AppendUint64(extra, toNTFSTime(t))
+		}
+	}
+
+	return extra, date, tm
+}
+
+// zipSourceExtra returns the owner, access time, and creation time of
+// file that are known from where it comes from: the header of another
+// archive, or the file system.
+func zipSourceExtra(file FileInfo) ZipExtra {
+	switch hdr := file.Header.(type) {
+	case *tar.Header:
+		return ZipExtra{Uid: hdr.Uid, Gid: hdr.Gid, HasOwner: true, AccessTime: hdr.AccessTime}
+	case zip.FileHeader:
+		return ParseZipExtra(hdr.Extra)
+	}
+	if file.FileInfo == nil {
+		return ZipExtra{}
+	}
+	uid, gid, ok := sysOwner(file.FileInfo)
+	return ZipExtra{Uid: uid, Gid: gid, HasOwner: ok}
+}
+
+// zipFileInfo is the fs.FileInfo of a file in a zip archive, with the
+// modification time from its extra fields, or its DOS date and time in
+// the time zone that they are known to be in, and the file itself, so
+// that it can be copied into another archive as it is.
+type zipFileInfo struct {
+	fs.FileInfo
+	modTime time.Time // if zero, that of the header
+	file    *zip.File
+}
+
+func (zfi zipFileInfo) ModTime() time.Time {
+	if zfi.modTime.IsZero() {
+		return zfi.FileInfo.ModTime()
+	}
+	return zfi.modTime
+}
+
+// fileInfo returns the fs.FileInfo of the zip file f.
+func (z Zip) fileInfo(f *zip.File) fs.FileInfo {
+	info := zipFileInfo{FileInfo: f.FileInfo(), file: f}
+	if extra := ParseZipExtra(f.Extra); !extra.ModTime.IsZero() {
+		info.modTime = extra.ModTime
+	} else if z.TimeZone != nil && (f.ModifiedDate != 0 || f.ModifiedTime != 0) {
+		info.modTime = msDosTimeToTime(f.ModifiedDate, f.ModifiedTime, z.TimeZone)
+	}
+	return info
+}
+
+// msDosTimeToTime converts a DOS date and time in loc to a time.Time.
+func msDosTimeToTime(date, tm uint16, loc *time.Location) time.Time {
+	return time.Date(
+		int(date>>9+1980), time.Month(date>>5&0xf), int(date&0x1f),
+		int(tm>>11), int(tm>>5&0x3f), int(tm&0x1f*2), 0,
+		loc,
+	)
+}
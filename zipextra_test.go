@@ -0,0 +1,204 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// zipExtraField returns an extra field with id and data.
+func zipExtraField(id uint16, data ...byte) []byte {
+	field := binary.LittleEndian.AppendUint16(nil, id)
+	field = binary.LittleEndian.AppendUint16(field, uint16(len(data)))
+	return append(field, data...)
+}
+
+func TestParseZipExtra(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456700, time.UTC)
+	ntfs := []byte{0, 0, 0, 0, 1, 0, 24, 0}
+	ntfs = binary.LittleEndian.AppendUint64(ntfs, toNTFSTime(mtime))
+	ntfs = binary.LittleEndian.AppendUint64(ntfs, toNTFSTime(mtime.Add(time.Hour)))
+	ntfs = binary.LittleEndian.AppendUint64(ntfs, toNTFSTime(mtime.Add(-time.Hour)))
+	timestamp := []byte{3}
+	timestamp = binary.LittleEndian.AppendUint32(timestamp, uint32(mtime.Unix()+10))
+	timestamp = binary.LittleEndian.AppendUint32(timestamp, uint32(mtime.Unix()+20))
+
+	for _, tc := range []struct {
+		name   string
+		extra  []byte
+		expect ZipExtra
+	}{
+		{
+			name:  "NTFS takes precedence",
+			extra: append(zipExtraField(zipExtraTimestamp, timestamp...), zipExtraField(zipExtraNTFS, ntfs...)...),
+			expect: ZipExtra{
+				ModTime:      mtime,
+				AccessTime:   mtime.Add(time.Hour),
+				CreationTime: mtime.Add(-time.Hour),
+			},
+		},
+		{
+			name:  "extended timestamp",
+			extra: zipExtraField(zipExtraTimestamp, timestamp...),
+			expect: ZipExtra{
+				ModTime:    time.Unix(mtime.Unix()+10, 0),
+				AccessTime: time.Unix(mtime.Unix()+20, 0),
+			},
+		},
+		{
+			name:   "Unix with 2-byte IDs",
+			extra:  zipExtraField(zipExtraUnix, 1, 2, 0xe8, 0x03, 2, 0xe9, 0x03),
+			expect: ZipExtra{Uid: 1000, Gid: 1001, HasOwner: true},
+		},
+		{
+			name:  "old Unix",
+			extra: zipExtraField(zipExtraUnixOld, 0, 0, 0, 0, 10, 0, 0, 0, 5, 0, 6, 0),
+			expect: ZipExtra{
+				ModTime:    time.Unix(10, 0),
+				AccessTime: time.Unix(0, 0),
+				Uid:        5, Gid: 6, HasOwner: true,
+			},
+		},
+		{
+			name:  "malformed",
+			extra: append(zipExtraField(zipExtraUnix, 1, 9), zipExtraField(zipExtraNTFS, 0, 0)[:3]...),
+		},
+	} {
+		got := ParseZipExtra(tc.extra)
+		if !got.ModTime.Equal(tc.expect.ModTime) || !got.AccessTime.Equal(tc.expect.AccessTime) ||
+			!got.CreationTime.Equal(tc.expect.CreationTime) || got.Uid != tc.expect.Uid ||
+			got.Gid != tc.expect.Gid || got.HasOwner != tc.expect.HasOwner {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.expect, got)
+		}
+	}
+}
+
+func TestZipExtraFields(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456700, time.UTC)
+	atime := mtime.Add(time.Minute)
+	fsys := fstest.MapFS{
+		"plain.txt":  {Data: []byte("plain"), ModTime: mtime},
+		"secret.txt": {Data: []byte("secret"), ModTime: mtime},
+		"insert.txt": {Data: []byte("insert"), ModTime: mtime},
+	}
+	files := mapFSFiles(t, fsys, "plain.txt", "secret.txt")
+	for i := range files {
+		// as if they came from a tar archive
+		files[i].Header = &tar.Header{Uid: 1000, Gid: 1001, AccessTime: atime}
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z := Zip{
+		NTFSTimes: true,
+		FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+			if file.NameInArchive == "secret.txt" {
+				return "secret", nil
+			}
+			return "", nil
+		},
+	}
+	if err := z.Archive(context.Background(), f, files); err != nil {
+		t.Fatal(err)
+	}
+	inserted := mapFSFiles(t, fsys, "insert.txt")
+	inserted[0].Header = &tar.Header{Uid: 7, Gid: 8}
+	if err := (Zip{}).Insert(context.Background(), f, inserted); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, file := range extractFileInfos(t, Zip{}, data) {
+		hdr := file.Header.(zip.FileHeader)
+		var timestamps int
+		for extra := hdr.Extra; len(extra) >= 4; extra = extra[4+binary.LittleEndian.Uint16(extra[2:]):] {
+			if binary.LittleEndian.Uint16(extra) == zipExtraTimestamp {
+				timestamps++
+			}
+		}
+		if timestamps != 1 {
+			t.Errorf("%s: expected 1 extended timestamp field, got %d", name, timestamps)
+		}
+
+		extra := ParseZipExtra(hdr.Extra)
+		expectMtime, expectOwner := mtime, [2]int{1000, 1001}
+		if name == "insert.txt" {
+			// no NTFS field, so only to the second
+			expectMtime, expectOwner = mtime.Truncate(time.Second), [2]int{7, 8}
+		} else if !extra.AccessTime.Equal(atime) {
+			t.Errorf("%s: expected access time %s, got %s", name, atime, extra.AccessTime)
+		}
+		if !file.ModTime().Equal(expectMtime) {
+			t.Errorf("%s: expected modification time %s, got %s", name, expectMtime, file.ModTime())
+		}
+		if !extra.HasOwner || [2]int{extra.Uid, extra.Gid} != expectOwner {
+			t.Errorf("%s: expected owner %v, got %+v", name, expectOwner, extra)
+		}
+		if atime, _ := entryTimes(file); name != "insert.txt" && !atime.Equal(extra.AccessTime) {
+			t.Errorf("%s: expected access time to extract, got %s", name, atime)
+		}
+		if owner, ok := entryOwner(file); !ok || owner.Uid != expectOwner[0] {
+			t.Errorf("%s: expected owner to extract, got %+v", name, owner)
+		}
+	}
+}
+
+func TestZipTimeZone(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	mtime := time.Date(2024, 5, 6, 7, 8, 10, 0, zone)
+
+	// written in the time zone, with no extra field, as on Windows
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hdr := &zip.FileHeader{Name: "windows.txt"}
+	hdr.ModifiedDate, hdr.ModifiedTime = timeToMsDosTime(mtime)
+	if _, err := zw.CreateHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	if got := extractFileInfos(t, Zip{TimeZone: zone}, buf.Bytes())["windows.txt"].ModTime(); !got.Equal(mtime) {
+		t.Errorf("expected %s, got %s", mtime, got)
+	}
+	if got := extractFileInfos(t, Zip{}, buf.Bytes())["windows.txt"].ModTime(); !got.Equal(mtime.Add(2 * time.Hour)) {
+		t.Errorf("expected DOS time in UTC by default, got %s", got)
+	}
+
+	// the DOS time is written in the time zone, but the extra field is used to read it
+	fsys := fstest.MapFS{"a.txt": {ModTime: mtime, Mode: fs.ModePerm}}
+	buf.Reset()
+	if err := (Zip{TimeZone: zone}).Archive(context.Background(), &buf, mapFSFiles(t, fsys, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	file := extractFileInfos(t, Zip{}, buf.Bytes())["a.txt"]
+	written := file.Header.(zip.FileHeader)
+	if date, tm := timeToMsDosTime(mtime); written.ModifiedDate != date || written.ModifiedTime != tm {
+		t.Errorf("expected DOS time in time zone")
+	}
+	if !file.ModTime().Equal(mtime) {
+		t.Errorf("expected %s, got %s", mtime, file.ModTime())
+	}
+
+	// without a time zone, the DOS time is written in that of the time itself
+	buf.Reset()
+	if err := (Zip{}).Archive(context.Background(), &buf, mapFSFiles(t, fsys, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	written = extractFileInfos(t, Zip{}, buf.Bytes())["a.txt"].Header.(zip.FileHeader)
+	if date, tm := timeToMsDosTime(mtime); written.ModifiedDate != date || written.ModifiedTime != tm {
+		t.Errorf("expected DOS time in the time zone of the file's time")
+	}
+}
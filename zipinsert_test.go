@@ -0,0 +1,103 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipInsertLikeArchive(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"dir":        {Mode: fs.ModeDir | 0o755, ModTime: mtime},
+		"dir/a.txt":  {Data: []byte(strings.Repeat("after a directory ", 50)), ModTime: mtime},
+		"link":       {Data: []byte("dir/a.txt"), Mode: fs.ModeSymlink | 0o777, ModTime: mtime},
+		"b.txt":      {Data: []byte(strings.Repeat("compressed ", 50)), ModTime: mtime},
+		"secret.txt": {Data: []byte("secret"), ModTime: mtime},
+	}
+	files := mapFSFiles(t, fsys, "dir", "dir/a.txt")
+	info, err := fs.Lstat(fsys, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, FileInfo{FileInfo: info, NameInArchive: "link", LinkTarget: "dir/a.txt"})
+	files = append(files, mapFSFiles(t, fsys, "b.txt", "secret.txt")...)
+	files[3].Comment = "with a comment"
+
+	z := Zip{
+		Compression: ZipMethodBzip2, // without SelectiveCompression
+		NTFSTimes:   true,
+		FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+			if file.NameInArchive == "secret.txt" {
+				return "secret", nil
+			}
+			return "", nil
+		},
+	}
+	var archived bytes.Buffer
+	if err := z.Archive(context.Background(), &archived, files); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := z.Archive(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Insert(context.Background(), f, files); err != nil {
+		t.Fatal(err)
+	}
+	inserted, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := func(data []byte) map[string]zip.FileHeader {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs := make(map[string]zip.FileHeader)
+		for _, zf := range zr.File {
+			hdrs[zf.Name] = zf.FileHeader
+		}
+		return hdrs
+	}
+	expect, got := headers(archived.Bytes()), headers(inserted)
+	if len(got) != len(expect) {
+		t.Fatalf("expected %d files, got %d", len(expect), len(got))
+	}
+	for name, e := range expect {
+		g := got[name]
+		if g.Method != e.Method || g.Flags != e.Flags || g.Comment != e.Comment || g.ExternalAttrs != e.ExternalAttrs ||
+			g.ModifiedDate != e.ModifiedDate || g.ModifiedTime != e.ModifiedTime || !bytes.Equal(g.Extra, e.Extra) {
+			t.Errorf("%s: expected header %+v, got %+v", name, e, g)
+		}
+	}
+	if got["b.txt"].Method != ZipMethodBzip2 {
+		t.Errorf("expected method %d, got %d", ZipMethodBzip2, got["b.txt"].Method)
+	}
+
+	z.Password = "secret"
+	contents, errs, err := extractEntries(t, z, inserted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/a.txt", "b.txt", "secret.txt"} {
+		if contents[name] != string(fsys[name].Data) {
+			t.Errorf("%s: contents differ (errors: %v)", name, errs)
+		}
+	}
+	if linkTarget := extractFileInfos(t, z, inserted)["link"].LinkTarget; linkTarget != "dir/a.txt" {
+		t.Errorf("expected link target %q, got %q", "dir/a.txt", linkTarget)
+	}
+}
@@ -0,0 +1,164 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// LZMA in zip files (APPNOTE.TXT section 5.8) is not the .lzma format,
+// which is what the lzma package reads and writes: the entry data starts
+// with the version of the LZMA SDK that compressed it and the size of
+// the properties, then has the 5 bytes of properties, but not the 8-byte
+// uncompressed size that .lzma files have next. Other programs, 7-Zip
+// among them, don't read entries that have the .lzma header.
+
+// zipFlagLzmaEOS is the general purpose flag that says the LZMA
+// data of an entry ends with an end of stream marker, which is
+// always the case for entries that this package writes.
+const zipFlagLzmaEOS = 0x2
+
+// zipLzmaVersion is the LZMA SDK version written to zip entries, which
+// is only informational; this is the one Python's zipfile module writes.
+var zipLzmaVersion = [2]byte{9, 4}
+
+const zipLzmaPropsLen = 5
+
+// zipLzmaMaxDictSize is the largest dictionary that entries may ask for,
+// which is the largest that 7-Zip uses. The dictionary is allocated
+// before anything is decompressed, so the size can't be left to what
+// the untrusted properties say.
+const zipLzmaMaxDictSize = 1536 << 20
+
+// newZipLzmaReader returns a reader that decompresses the LZMA data of a
+// zip entry of size bytes, or of unknown size if size is negative; the
+// dictionary is made no larger than the entry. Entries may or may not
+// have an end of stream marker, which is only known from the general
+// purpose flags; without one, the data ends where the compressed data
+// does, and the caller checks that the size and checksum of what was
+// read are right.
+func newZipLzmaReader(r io.Reader, size int64) io.ReadCloser {
+	var header [4 + zipLzmaPropsLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return errReadCloser{fmt.Errorf("reading lzma header: %w", noEOF(err))}
+	}
+	if size := binary.LittleEndian.Uint16(header[2:]); size != zipLzmaPropsLen {
+		return errReadCloser{fmt.Errorf("lzma properties are %d bytes, expected %d", size, zipLzmaPropsLen)}
+	}
+
+	// make the .lzma header that the lzma package expects,
+	// with the uncompressed size unknown
+	var lzmaHeader [lzma.HeaderLen]byte
+	copy(lzmaHeader[:], header[4:])
+	binary.LittleEndian.PutUint64(lzmaHeader[zipLzmaPropsLen:], ^uint64(0))
+	dictSize := int64(binary.LittleEndian.Uint32(lzmaHeader[1:]))
+	if dictSize > zipLzmaMaxDictSize {
+		return errReadCloser{fmt.Errorf("lzma dictionary size %d is larger than the maximum of %d", dictSize, zipLzmaMaxDictSize)}
+	}
+	if size >= 0 && size < dictSize {
+		// matches can't reach further back than the start of the entry
+		binary.LittleEndian.PutUint32(lzmaHeader[1:], uint32(max(size, lzma.MinDictCap)))
+	}
+
+	er := &eofReader{r: r}
+	lr, err := lzma.ReaderConfig{DictCap: zipLzmaMaxDictSize}.NewReader(io.MultiReader(bytes.NewReader(lzmaHeader[:]), er))
+	if err != nil {
+		return errReadCloser{err}
+	}
+	return io.NopCloser(&zipLzmaReader{r: lr, er: er})
+}
+
+type zipLzmaReader struct {
+	r     io.Reader
+	er    *eofReader
+	ended bool
+}
+
+func (z *zipLzmaReader) Read(p []byte) (int, error) {
+	for {
+		n, err := z.r.Read(p)
+		if err == nil || err == io.EOF || !z.er.eof {
+			return n, err
+		}
+		// without an end of stream marker, the lzma reader fails at the
+		// end of the compressed data, and may still have data to return
+		if n > 0 {
+			return n, nil
+		}
+		if z.ended {
+			return 0, io.EOF
+		}
+		z.ended = true
+	}
+}
+
+// eofReader remembers whether r has been read to the end.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
+}
+
+// zipLzmaCompressor returns a compressor for LZMA at level, which
+// is like an xz level; 0 is the lzma package's default. The lzma
+// package writes a .lzma header first, which zipLzmaHeaderWriter
+// turns into the zip one.
+func zipLzmaCompressor(level int) zip.Compressor {
+	return lazyCompressor(func(out io.Writer) (io.WriteCloser, error) {
+		var dictCap int
+		if level != 0 {
+			var err error
+			if dictCap, err = xzDictCap(level); err != nil {
+				return nil, err
+			}
+		}
+		header := []byte{zipLzmaVersion[0], zipLzmaVersion[1], zipLzmaPropsLen, 0}
+		if _, err := out.Write(header); err != nil {
+			return nil, err
+		}
+		return lzma.WriterConfig{DictCap: dictCap, EOSMarker: true}.NewWriter(&zipLzmaHeaderWriter{w: out})
+	})
+}
+
+// zipLzmaHeaderWriter is what the lzma writer writes to; it drops
+// the uncompressed size from the .lzma header.
+type zipLzmaHeaderWriter struct {
+	w       io.Writer
+	written int // bytes of the .lzma header passed through so far
+}
+
+func (h *zipLzmaHeaderWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && h.written < lzma.HeaderLen {
+		if h.written < zipLzmaPropsLen {
+			if _, err := h.w.Write(p[:1]); err != nil {
+				return 0, err
+			}
+		}
+		h.written++
+		p = p[1:]
+	}
+	if len(p) > 0 {
+		if _, err := h.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// errReadCloser is a decompressor for data that can't be decompressed.
+type errReadCloser struct{ err error }
+
+func (e errReadCloser) Read([]byte) (int, error) { return 0, e.err }
+func (e errReadCloser) Close() error             { return nil }
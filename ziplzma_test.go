@@ -0,0 +1,192 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestZipLzma(t *testing.T) {
+	t.Run("python", func(t *testing.T) {
+		// created with Python's zipfile module and ZIP_LZMA
+		data, err := os.ReadFile("testdata/lzma.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, errs, err := extractEntries(t, Zip{}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if want := strings.Repeat("Hello from zipfile, compressed with LZMA.\n", 40); contents["hello.txt"] != want {
+			t.Errorf("expected %q, got %q", want, contents["hello.txt"])
+		}
+		if contents["empty.txt"] != "" {
+			t.Errorf("expected empty file, got %q", contents["empty.txt"])
+		}
+	})
+
+	plain := strings.Repeat("LZMA entries have their own header in zip files. ", 100)
+	fsys := fstest.MapFS{
+		"plain.txt":  {Data: []byte(plain)},
+		"secret.txt": {Data: []byte(plain)},
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		var buf bytes.Buffer
+		z := Zip{
+			Compression: ZipMethodLzma,
+			FilePassword: func(ctx context.Context, file FileInfo) (string, error) {
+				if file.NameInArchive == "secret.txt" {
+					return "lzma", nil
+				}
+				return "", nil
+			},
+		}
+		if err := z.Archive(context.Background(), &buf, mapFSFiles(t, fsys, "plain.txt", "secret.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := zr.File[0]
+		if f.Method != ZipMethodLzma || f.Flags&zipFlagLzmaEOS == 0 {
+			t.Errorf("expected method %d with end of stream flag, got method %d and flags %#x", ZipMethodLzma, f.Method, f.Flags)
+		}
+		raw, err := f.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := make([]byte, 4)
+		raw.Read(header)
+		if header[2] != zipLzmaPropsLen || header[3] != 0 {
+			t.Errorf("expected properties size %d, got header % x", zipLzmaPropsLen, header)
+		}
+
+		z.Password = "lzma"
+		contents, errs, err := extractEntries(t, z, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range fsys {
+			if contents[name] != plain {
+				t.Errorf("%s: contents differ (errors: %v)", name, errs)
+			}
+		}
+	})
+
+	t.Run("no end marker", func(t *testing.T) {
+		var lzmaData bytes.Buffer
+		lw, err := lzma.WriterConfig{SizeInHeader: true, Size: int64(len(plain))}.NewWriter(&lzmaData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lw.Write([]byte(plain))
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		compressed := append([]byte{9, 4, zipLzmaPropsLen, 0}, lzmaData.Bytes()[:zipLzmaPropsLen]...)
+		compressed = append(compressed, lzmaData.Bytes()[lzma.HeaderLen:]...)
+
+		for _, tc := range []struct {
+			name  string
+			size  int
+			valid bool
+		}{
+			{"complete", len(plain), true},
+			{"wrong size", len(plain) + 1, false},
+		} {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:               "sized.txt",
+				Method:             ZipMethodLzma,
+				CRC32:              crc32.ChecksumIEEE([]byte(plain)),
+				CompressedSize64:   uint64(len(compressed)),
+				UncompressedSize64: uint64(tc.size),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(compressed)
+			zw.Close()
+
+			contents, errs, err := extractEntries(t, Zip{}, buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.valid && contents["sized.txt"] != plain {
+				t.Errorf("%s: contents differ (errors: %v)", tc.name, errs)
+			}
+			if !tc.valid && errs["sized.txt"] == nil {
+				t.Errorf("%s: expected error", tc.name)
+			}
+		}
+	})
+
+	t.Run("dictionary size", func(t *testing.T) {
+		// the dictionary is allocated when the entry is opened, before
+		// any limit on what is read from it can apply
+		for _, entry := range [][]byte{
+			{9, 20, 5, 0, 0x5d, 0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0},
+			{9, 20, 5, 0, 0x5d, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0},
+		} {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:             "huge.txt",
+				Method:           ZipMethodLzma,
+				CompressedSize64: uint64(len(entry)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(entry)
+			zw.Close()
+
+			_, errs, err := extractEntries(t, Zip{}, buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if errs["huge.txt"] == nil {
+				t.Errorf("% x: expected error", entry)
+			}
+		}
+
+		// a dictionary that isn't too large is still only as large as the entry
+		var buf bytes.Buffer
+		if err := (Zip{Compression: ZipMethodLzma}).Archive(context.Background(), &buf, mapFSFiles(t, fsys, "plain.txt")); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		props := bytes.Index(data, []byte{zipLzmaVersion[0], zipLzmaVersion[1], zipLzmaPropsLen, 0}) + 4
+		binary.LittleEndian.PutUint32(data[props+1:], 1<<30)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		contents, errs, err := extractEntries(t, Zip{}, data)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents["plain.txt"] != plain {
+			t.Errorf("contents differ (errors: %v)", errs)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+			t.Errorf("expected the dictionary to be limited to the entry size, but %d bytes were allocated", allocated)
+		}
+	})
+}
@@ -0,0 +1,1268 @@
+package archives
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PPMd in zip files (APPNOTE.TXT section 5.10) is variant I, revision 1
+// of Dmitry Shkarin's PPMd, while 7z archives use variant H, which is all
+// that the ppmd package that 7z archives are read with implements. This
+// is a port of the decoder of the LZMA SDK (Ppmd8.c and Ppmd8Dec.c), which
+// is in the public domain, without support for the "freeze" method of
+// restoring the model, which 7-Zip doesn't support either.
+//
+// The entry data starts with 2 bytes that have the parameters of the
+// model, then is range coded. It has no end marker, so the size of the
+// entry is needed to know where it ends.
+//
+// The model is kept in one block of memory, as in the C code, with the
+// pointers into it as offsets from its start; its layout is what the
+// compression depends on when the memory runs out, so it is kept as is.
+
+const (
+	ppmdMinOrder   = 2
+	ppmdMaxOrder   = 16
+	ppmdNumIndexes = 4 + 4 + 4 + 26
+	ppmdUnitSize   = 12
+	ppmdStateSize  = 6
+	ppmdMaxFreq    = 124
+	ppmdIntBits    = 7
+	ppmdPeriodBits = 7
+	ppmdBinScale   = 1 << (ppmdIntBits + ppmdPeriodBits)
+	ppmdEmptyNode  = 0xFFFFFFFF
+
+	// how the model is restored when its memory runs out
+	ppmdRestoreRestart = 0
+	ppmdRestoreCutOff  = 1
+	ppmdRestoreFreeze  = 2
+
+	ppmdTop = 1 << 24
+	ppmdBot = 1 << 15
+)
+
+var (
+	ppmdInitBinEsc = [8]uint16{0x3CDD, 0x1F3F, 0x59BF, 0x48F3, 0x64A1, 0x5ABC, 0x6632, 0x6051}
+	ppmdExpEscape  = [16]byte{25, 14, 9, 7, 5, 5, 4, 4, 4, 3, 3, 3, 2, 2, 2, 2}
+)
+
+// errZipPPMdCorrupt is returned for PPMd data that can't be decoded.
+var errZipPPMdCorrupt = errors.New("zip: PPMd data is corrupt")
+
+// newZipPPMdReader returns a reader that decompresses the PPMd data of a
+// zip entry of size bytes; if size is negative, the data has to end with
+// an end marker, which 7-Zip doesn't write.
+func newZipPPMdReader(r io.Reader, size int64) io.ReadCloser {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return errReadCloser{fmt.Errorf("reading PPMd header: %w", noEOF(err))}
+	}
+	params := binary.LittleEndian.Uint16(header[:])
+	order := int(params&0xf) + 1
+	memSize := (uint32(params>>4&0xff) + 1) << 20
+	restore := int(params >> 12)
+	if order < ppmdMinOrder {
+		return errReadCloser{fmt.Errorf("PPMd order %d is too small", order)}
+	}
+	if restore >= ppmdRestoreFreeze {
+		return errReadCloser{fmt.Errorf("PPMd model restoration method %d is not supported", restore)}
+	}
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	p := newPPMd8(order, memSize, restore)
+	p.in = br
+	if !p.initDecoder() {
+		return errReadCloser{errZipPPMdCorrupt}
+	}
+	return &zipPPMdReader{p: p, remaining: size}
+}
+
+type zipPPMdReader struct {
+	p         *ppmd8
+	remaining int64 // negative if unknown
+	err       error
+}
+
+func (z *zipPPMdReader) Read(b []byte) (int, error) {
+	var n int
+	for n < len(b) && z.remaining != 0 && z.err == nil {
+		sym := z.p.decodeSymbol()
+		switch {
+		case z.p.inErr != nil:
+			z.err = z.p.inErr
+		case sym == -1 && z.remaining < 0:
+			z.err = io.EOF
+		case sym < 0:
+			z.err = errZipPPMdCorrupt
+		default:
+			b[n] = byte(sym)
+			n++
+			z.remaining--
+		}
+	}
+	if z.remaining == 0 {
+		return n, io.EOF
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+func (z *zipPPMdReader) Close() error { return nil }
+
+// ppmdSee is an adaptive estimate of the escape frequency.
+type ppmdSee struct {
+	summ  uint16
+	shift byte
+	count byte
+}
+
+func (s *ppmdSee) update() {
+	if s.shift < ppmdPeriodBits {
+		s.count--
+		if s.count == 0 {
+			s.summ <<= 1
+			s.count = byte(3 << s.shift)
+			s.shift++
+		}
+	}
+}
+
+// ppmd8 is the model of PPMd variant I and its range coder.
+//
+// In mem, a context is 12 bytes: the number of its symbols minus 1, its
+// flags, the sum of the frequencies of its symbols, the offset of its
+// states, and that of its suffix; contexts with one symbol have its state
+// in place of the sum and the offset of the states. A state is 6 bytes:
+// the symbol, its frequency, and the offset of its successor. The free
+// blocks of units are nodes of a stamp, the offset of the next node, and
+// the number of units.
+type ppmd8 struct {
+	mem []byte
+
+	minContext, maxContext uint32
+	foundState             uint32
+	orderFall, initEsc     uint32
+	prevSuccess, maxOrder  uint32
+	runLength, initRL      int32
+
+	size        uint32
+	glueCount   uint32
+	text        uint32
+	unitsStart  uint32
+	loUnit      uint32
+	hiUnit      uint32
+	alignOffset uint32
+	restore     int
+
+	indx2Units [ppmdNumIndexes]byte
+	units2Indx [128]byte
+	freeList   [ppmdNumIndexes]uint32
+	stamps     [ppmdNumIndexes]uint32
+	ns2BSIndx  [256]byte
+	ns2Indx    [260]byte
+	dummySee   ppmdSee
+	see        [24][32]ppmdSee
+	binSumm    [25][64]uint16
+
+	// the range coder
+	rng, code, low uint32
+	in             io.ByteReader
+	inErr          error
+}
+
+func newPPMd8(maxOrder int, size uint32, restore int) *ppmd8 {
+	p := &ppmd8{
+		size:        size,
+		alignOffset: 4 - size&3,
+		maxOrder:    uint32(maxOrder),
+		restore:     restore,
+	}
+	p.mem = make([]byte, p.alignOffset+size)
+
+	k := 0
+	for i := range ppmdNumIndexes {
+		step := 4
+		if i < 12 {
+			step = i>>2 + 1
+		}
+		for ; step > 0; step-- {
+			p.units2Indx[k] = byte(i)
+			k++
+		}
+		p.indx2Units[i] = byte(k)
+	}
+
+	p.ns2BSIndx[0] = 0 << 1
+	p.ns2BSIndx[1] = 1 << 1
+	for i := 2; i < 11; i++ {
+		p.ns2BSIndx[i] = 2 << 1
+	}
+	for i := 11; i < 256; i++ {
+		p.ns2BSIndx[i] = 3 << 1
+	}
+	for i := range 5 {
+		p.ns2Indx[i] = byte(i)
+	}
+	for i, m, k := 5, 5, 1; i < 260; i++ {
+		p.ns2Indx[i] = byte(m)
+		if k--; k == 0 {
+			m++
+			k = m - 4
+		}
+	}
+
+	p.restartModel()
+	p.dummySee = ppmdSee{shift: ppmdPeriodBits, count: 64}
+	return p
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// accessors of what is in mem
+
+func (p *ppmd8) get32(off uint32) uint32     { return binary.LittleEndian.Uint32(p.mem[off:]) }
+func (p *ppmd8) put32(off, v uint32)         { binary.LittleEndian.PutUint32(p.mem[off:], v) }
+func (p *ppmd8) numStats(c uint32) uint32    { return uint32(p.mem[c]) }
+func (p *ppmd8) setNumStats(c, n uint32)     { p.mem[c] = byte(n) }
+func (p *ppmd8) flags(c uint32) uint32       { return uint32(p.mem[c+1]) }
+func (p *ppmd8) setFlags(c, f uint32)        { p.mem[c+1] = byte(f) }
+func (p *ppmd8) summFreq(c uint32) uint32    { return uint32(binary.LittleEndian.Uint16(p.mem[c+2:])) }
+func (p *ppmd8) setSummFreq(c, f uint32)     { binary.LittleEndian.PutUint16(p.mem[c+2:], uint16(f)) }
+func (p *ppmd8) stats(c uint32) uint32       { return p.get32(c + 4) }
+func (p *ppmd8) setStats(c, s uint32)        { p.put32(c+4, s) }
+func (p *ppmd8) suffix(c uint32) uint32      { return p.get32(c + 8) }
+func (p *ppmd8) setSuffix(c, s uint32)       { p.put32(c+8, s) }
+func (p *ppmd8) symbol(s uint32) byte        { return p.mem[s] }
+func (p *ppmd8) freq(s uint32) uint32        { return uint32(p.mem[s+1]) }
+func (p *ppmd8) setFreq(s, f uint32)         { p.mem[s+1] = byte(f) }
+func (p *ppmd8) successor(s uint32) uint32   { return p.get32(s + 2) }
+func (p *ppmd8) setSuccessor(s, v uint32)    { p.put32(s+2, v) }
+func (p *ppmd8) state(s uint32) (st [6]byte) { copy(st[:], p.mem[s:]); return }
+func (p *ppmd8) setState(s uint32, st [6]byte) {
+	copy(p.mem[s:s+ppmdStateSize], st[:])
+}
+
+// oneState returns the state of the context c that has one symbol.
+func oneState(c uint32) uint32 { return c + 2 }
+
+func (p *ppmd8) swapStates(s1, s2 uint32) {
+	st := p.state(s1)
+	p.setState(s1, p.state(s2))
+	p.setState(s2, st)
+}
+
+func (p *ppmd8) i2u(indx uint32) uint32 { return uint32(p.indx2Units[indx]) }
+func (p *ppmd8) u2i(nu uint32) uint32   { return uint32(p.units2Indx[nu-1]) }
+func u2b(nu uint32) uint32              { return nu * ppmdUnitSize }
+
+func (p *ppmd8) copyUnits(dst, src, nu uint32) {
+	copy(p.mem[dst:dst+u2b(nu)], p.mem[src:])
+}
+
+// the memory allocator
+
+func (p *ppmd8) insertNode(node, indx uint32) {
+	p.put32(node, ppmdEmptyNode)
+	p.put32(node+4, p.freeList[indx])
+	p.put32(node+8, p.i2u(indx))
+	p.freeList[indx] = node
+	p.stamps[indx]++
+}
+
+func (p *ppmd8) removeNode(indx uint32) uint32 {
+	node := p.freeList[indx]
+	p.freeList[indx] = p.get32(node + 4)
+	p.stamps[indx]--
+	return node
+}
+
+func (p *ppmd8) splitBlock(ptr, oldIndx, newIndx uint32) {
+	nu := p.i2u(oldIndx) - p.i2u(newIndx)
+	ptr += u2b(p.i2u(newIndx))
+	i := p.u2i(nu)
+	if p.i2u(i) != nu {
+		i--
+		k := p.i2u(i)
+		p.insertNode(ptr+u2b(k), nu-k-1)
+	}
+	p.insertNode(ptr, i)
+}
+
+func (p *ppmd8) glueFreeBlocks() {
+	// prev is where the offset of the next node goes, 0 being head
+	var head, prev uint32
+	setPrev := func(v uint32) {
+		if prev == 0 {
+			head = v
+		} else {
+			p.put32(prev, v)
+		}
+	}
+
+	p.glueCount = 1 << 13
+	p.stamps = [ppmdNumIndexes]uint32{}
+
+	// the order-0 context is always at the top, so only the
+	// blocks up to loUnit need a guard after them
+	if p.loUnit != p.hiUnit {
+		p.put32(p.loUnit, 0)
+	}
+
+	for i := range p.freeList {
+		next := p.freeList[i]
+		p.freeList[i] = 0
+		for next != 0 {
+			node := next
+			if nu := p.get32(node + 8); nu != 0 {
+				setPrev(next)
+				prev = node + 4
+				for {
+					node2 := node + u2b(p.get32(node+8))
+					if p.get32(node2) != ppmdEmptyNode {
+						break
+					}
+					p.put32(node+8, p.get32(node+8)+p.get32(node2+8))
+					p.put32(node2+8, 0)
+				}
+			}
+			next = p.get32(node + 4)
+		}
+	}
+	setPrev(0)
+
+	for head != 0 {
+		node := head
+		head = p.get32(node + 4)
+		nu := p.get32(node + 8)
+		if nu == 0 {
+			continue
+		}
+		for ; nu > 128; nu -= 128 {
+			p.insertNode(node, ppmdNumIndexes-1)
+			node += u2b(128)
+		}
+		i := p.u2i(nu)
+		if p.i2u(i) != nu {
+			i--
+			k := p.i2u(i)
+			p.insertNode(node+u2b(k), nu-k-1)
+		}
+		p.insertNode(node, i)
+	}
+}
+
+// allocUnitsRare returns 0 if there is no memory left.
+func (p *ppmd8) allocUnitsRare(indx uint32) uint32 {
+	if p.glueCount == 0 {
+		p.glueFreeBlocks()
+		if p.freeList[indx] != 0 {
+			return p.removeNode(indx)
+		}
+	}
+	i := indx
+	for {
+		i++
+		if i == ppmdNumIndexes {
+			numBytes := u2b(p.i2u(indx))
+			p.glueCount--
+			if p.unitsStart-p.text > numBytes {
+				p.unitsStart -= numBytes
+				return p.unitsStart
+			}
+			return 0
+		}
+		if p.freeList[i] != 0 {
+			break
+		}
+	}
+	retVal := p.removeNode(i)
+	p.splitBlock(retVal, i, indx)
+	return retVal
+}
+
+func (p *ppmd8) allocUnits(indx uint32) uint32 {
+	if p.freeList[indx] != 0 {
+		return p.removeNode(indx)
+	}
+	numBytes := u2b(p.i2u(indx))
+	if numBytes <= p.hiUnit-p.loUnit {
+		retVal := p.loUnit
+		p.loUnit += numBytes
+		return retVal
+	}
+	return p.allocUnitsRare(indx)
+}
+
+func (p *ppmd8) shrinkUnits(oldPtr, oldNU, newNU uint32) uint32 {
+	i0 := p.u2i(oldNU)
+	i1 := p.u2i(newNU)
+	if i0 == i1 {
+		return oldPtr
+	}
+	if p.freeList[i1] != 0 {
+		ptr := p.removeNode(i1)
+		p.copyUnits(ptr, oldPtr, newNU)
+		p.insertNode(oldPtr, i0)
+		return ptr
+	}
+	p.splitBlock(oldPtr, i0, i1)
+	return oldPtr
+}
+
+func (p *ppmd8) freeUnits(ptr, nu uint32) {
+	p.insertNode(ptr, p.u2i(nu))
+}
+
+func (p *ppmd8) specialFreeUnit(ptr uint32) {
+	if ptr != p.unitsStart {
+		p.insertNode(ptr, 0)
+	} else {
+		p.unitsStart += ppmdUnitSize
+	}
+}
+
+func (p *ppmd8) moveUnitsUp(oldPtr, nu uint32) uint32 {
+	indx := p.u2i(nu)
+	if oldPtr > p.unitsStart+16*1024 || oldPtr > p.freeList[indx] {
+		return oldPtr
+	}
+	ptr := p.removeNode(indx)
+	p.copyUnits(ptr, oldPtr, nu)
+	if oldPtr != p.unitsStart {
+		p.insertNode(oldPtr, indx)
+	} else {
+		p.unitsStart += u2b(p.i2u(indx))
+	}
+	return ptr
+}
+
+func (p *ppmd8) expandTextArea() {
+	var count [ppmdNumIndexes]uint32
+	if p.loUnit != p.hiUnit {
+		p.put32(p.loUnit, 0)
+	}
+
+	node := p.unitsStart
+	for p.get32(node) == ppmdEmptyNode {
+		nu := p.get32(node + 8)
+		p.put32(node, 0)
+		count[p.u2i(nu)]++
+		node += u2b(nu)
+	}
+	p.unitsStart = node
+
+	for i := range p.freeList {
+		// next is where the offset of the node is, 0 being freeList[i]
+		var next uint32
+		get := func() uint32 {
+			if next == 0 {
+				return p.freeList[i]
+			}
+			return p.get32(next)
+		}
+		for count[i] != 0 {
+			node := get()
+			for p.get32(node) == 0 {
+				if next == 0 {
+					p.freeList[i] = p.get32(node + 4)
+				} else {
+					p.put32(next, p.get32(node+4))
+				}
+				node = get()
+				p.stamps[i]--
+				if count[i]--; count[i] == 0 {
+					break
+				}
+			}
+			next = node + 4
+		}
+	}
+}
+
+func (p *ppmd8) usedMemory() uint32 {
+	var v uint32
+	for i, n := range p.stamps {
+		v += n * p.i2u(uint32(i))
+	}
+	return p.size - (p.hiUnit - p.loUnit) - (p.unitsStart - p.text) - u2b(v)
+}
+
+// the model
+
+func (p *ppmd8) restartModel() {
+	p.freeList = [ppmdNumIndexes]uint32{}
+	p.stamps = [ppmdNumIndexes]uint32{}
+	p.text = p.alignOffset
+	p.hiUnit = p.text + p.size
+	p.loUnit = p.hiUnit - p.size/8/ppmdUnitSize*7*ppmdUnitSize
+	p.unitsStart = p.loUnit
+	p.glueCount = 0
+
+	p.orderFall = p.maxOrder
+	p.initRL = -int32(p.maxOrder) - 1
+	if p.maxOrder > 12 {
+		p.initRL = -12 - 1
+	}
+	p.runLength = p.initRL
+	p.prevSuccess = 0
+
+	p.hiUnit -= ppmdUnitSize
+	c := p.hiUnit
+	p.minContext, p.maxContext = c, c
+	p.setSuffix(c, 0)
+	p.setNumStats(c, 255)
+	p.setFlags(c, 0)
+	p.setSummFreq(c, 256+1)
+	p.foundState = p.loUnit
+	p.setStats(c, p.loUnit)
+	for i := range uint32(256) {
+		s := p.loUnit + i*ppmdStateSize
+		p.mem[s] = byte(i)
+		p.setFreq(s, 1)
+		p.setSuccessor(s, 0)
+	}
+	p.loUnit += u2b(256 / 2)
+
+	for i := range p.binSumm {
+		for k, esc := range ppmdInitBinEsc {
+			val := uint16(ppmdBinScale - uint32(esc)/uint32(i+2))
+			for m := 0; m < 64; m += 8 {
+				p.binSumm[i][k+m] = val
+			}
+		}
+	}
+	for i := range p.see {
+		for k := range p.see[i] {
+			p.see[i][k] = ppmdSee{
+				summ:  uint16((2*i + 5) << (ppmdPeriodBits - 4)),
+				shift: ppmdPeriodBits - 4,
+				count: 7,
+			}
+		}
+	}
+}
+
+func (p *ppmd8) refresh(ctx, oldNU, scale uint32) {
+	i := p.numStats(ctx)
+	s := p.shrinkUnits(p.stats(ctx), oldNU, (i+2)>>1)
+	p.setStats(ctx, s)
+	flags := p.flags(ctx)&(0x10+0x04*scale) + 0x08*b2u(p.symbol(s) >= 0x40)
+	escFreq := p.summFreq(ctx) - p.freq(s)
+	sumFreq := (p.freq(s) + scale) >> scale
+	p.setFreq(s, sumFreq)
+	for ; i > 0; i-- {
+		s += ppmdStateSize
+		escFreq -= p.freq(s)
+		f := (p.freq(s) + scale) >> scale
+		p.setFreq(s, f)
+		sumFreq += f
+		flags |= 0x08 * b2u(p.symbol(s) >= 0x40)
+	}
+	p.setSummFreq(ctx, sumFreq+(escFreq+scale)>>scale)
+	p.setFlags(ctx, flags)
+}
+
+func (p *ppmd8) cutOff(ctx, order uint32) uint32 {
+	ns := p.numStats(ctx)
+	if ns == 0 {
+		s := oneState(ctx)
+		if p.successor(s) >= p.unitsStart {
+			if order < p.maxOrder {
+				p.setSuccessor(s, p.cutOff(p.successor(s), order+1))
+			} else {
+				p.setSuccessor(s, 0)
+			}
+			if p.successor(s) != 0 || order <= 9 {
+				return ctx
+			}
+		}
+		p.specialFreeUnit(ctx)
+		return 0
+	}
+
+	tmp := (ns + 2) >> 1
+	p.setStats(ctx, p.moveUnitsUp(p.stats(ctx), tmp))
+	stats := p.stats(ctx)
+	i := int(ns)
+	for j := int(ns); j >= 0; j-- {
+		s := stats + uint32(j)*ppmdStateSize
+		if p.successor(s) < p.unitsStart {
+			s2 := stats + uint32(i)*ppmdStateSize
+			i--
+			p.setSuccessor(s, 0)
+			p.swapStates(s, s2)
+		} else if order < p.maxOrder {
+			p.setSuccessor(s, p.cutOff(p.successor(s), order+1))
+		} else {
+			p.setSuccessor(s, 0)
+		}
+	}
+
+	if i != int(ns) && order != 0 {
+		p.setNumStats(ctx, uint32(i))
+		s := p.stats(ctx)
+		switch {
+		case i < 0:
+			p.freeUnits(s, tmp)
+			p.specialFreeUnit(ctx)
+			return 0
+		case i == 0:
+			p.setFlags(ctx, p.flags(ctx)&0x10+0x08*b2u(p.symbol(s) >= 0x40))
+			p.setState(oneState(ctx), p.state(s))
+			p.freeUnits(s, tmp)
+			p.setFreq(oneState(ctx), (p.freq(oneState(ctx))+11)>>3)
+		default:
+			p.refresh(ctx, tmp, b2u(p.summFreq(ctx) > 16*uint32(i)))
+		}
+	}
+	return ctx
+}
+
+// restoreModel makes room in the model when its memory has run out,
+// undoing what updateModel did to the contexts up to c1 first.
+func (p *ppmd8) restoreModel(c1 uint32) {
+	p.text = p.alignOffset
+	c := p.maxContext
+	for ; c != c1; c = p.suffix(c) {
+		ns := p.numStats(c) - 1
+		p.setNumStats(c, ns)
+		if ns == 0 {
+			s := p.stats(c)
+			p.setFlags(c, p.flags(c)&0x10+0x08*b2u(p.symbol(s) >= 0x40))
+			p.setState(oneState(c), p.state(s))
+			p.specialFreeUnit(s)
+			p.setFreq(oneState(c), (p.freq(oneState(c))+11)>>3)
+		} else {
+			p.refresh(c, (ns+3)>>1, 0)
+		}
+	}
+	for ; c != p.minContext; c = p.suffix(c) {
+		if ns := p.numStats(c); ns == 0 {
+			f := p.freq(oneState(c))
+			p.setFreq(oneState(c), f-f>>1)
+		} else {
+			p.setSummFreq(c, p.summFreq(c)+4)
+			if p.summFreq(c) > 128+4*ns {
+				p.refresh(c, (ns+2)>>1, 1)
+			}
+		}
+	}
+
+	if p.restore == ppmdRestoreRestart || p.usedMemory() < p.size>>1 {
+		p.restartModel()
+		return
+	}
+	for p.suffix(p.maxContext) != 0 {
+		p.maxContext = p.suffix(p.maxContext)
+	}
+	for {
+		p.cutOff(p.maxContext, 0)
+		p.expandTextArea()
+		if p.usedMemory() <= 3*(p.size>>2) {
+			break
+		}
+	}
+	p.glueCount = 0
+	p.orderFall = p.maxOrder
+}
+
+// createSuccessors returns 0 if there is no memory left.
+func (p *ppmd8) createSuccessors(skip bool, s1, c uint32) uint32 {
+	upBranch := p.successor(p.foundState)
+	fSymbol := p.symbol(p.foundState)
+	var ps [ppmdMaxOrder + 1]uint32
+	numPs := 0
+	if !skip {
+		ps[numPs] = p.foundState
+		numPs++
+	}
+
+	for p.suffix(c) != 0 {
+		c = p.suffix(c)
+		var s uint32
+		switch {
+		case s1 != 0:
+			s, s1 = s1, 0
+		case p.numStats(c) != 0:
+			for s = p.stats(c); p.symbol(s) != fSymbol; s += ppmdStateSize {
+			}
+			if p.freq(s) < ppmdMaxFreq-9 {
+				p.setFreq(s, p.freq(s)+1)
+				p.setSummFreq(c, p.summFreq(c)+1)
+			}
+		default:
+			s = oneState(c)
+			if p.numStats(p.suffix(c)) == 0 && p.freq(s) < 24 {
+				p.setFreq(s, p.freq(s)+1)
+			}
+		}
+		if successor := p.successor(s); successor != upBranch {
+			c = successor
+			if numPs == 0 {
+				return c
+			}
+			break
+		}
+		ps[numPs] = s
+		numPs++
+	}
+
+	upSymbol := p.mem[upBranch]
+	flags := 0x10*b2u(fSymbol >= 0x40) + 0x08*b2u(upSymbol >= 0x40)
+	var upFreq uint32
+	if p.numStats(c) == 0 {
+		upFreq = p.freq(oneState(c))
+	} else {
+		s := p.stats(c)
+		for p.symbol(s) != upSymbol {
+			s += ppmdStateSize
+		}
+		cf := p.freq(s) - 1
+		s0 := p.summFreq(c) - p.numStats(c) - cf
+		if 2*cf <= s0 {
+			upFreq = 1 + b2u(5*cf > s0)
+		} else {
+			upFreq = 1 + (cf+2*s0-3)/s0
+		}
+	}
+
+	for numPs != 0 {
+		var c1 uint32
+		switch {
+		case p.hiUnit != p.loUnit:
+			p.hiUnit -= ppmdUnitSize
+			c1 = p.hiUnit
+		case p.freeList[0] != 0:
+			c1 = p.removeNode(0)
+		default:
+			if c1 = p.allocUnitsRare(0); c1 == 0 {
+				return 0
+			}
+		}
+		p.setNumStats(c1, 0)
+		p.setFlags(c1, flags)
+		p.mem[oneState(c1)] = upSymbol
+		p.setFreq(oneState(c1), upFreq)
+		p.setSuccessor(oneState(c1), upBranch+1)
+		p.setSuffix(c1, c)
+		numPs--
+		p.setSuccessor(ps[numPs], c1)
+		c = c1
+	}
+	return c
+}
+
+// reduceOrder returns 0 if there is no memory left.
+func (p *ppmd8) reduceOrder(s1, c uint32) uint32 {
+	c1 := c
+	upBranch := p.text
+	fSymbol := p.symbol(p.foundState)
+	p.setSuccessor(p.foundState, upBranch)
+	p.orderFall++
+
+	var s uint32
+	for {
+		if s1 != 0 {
+			c = p.suffix(c)
+			s, s1 = s1, 0
+		} else {
+			if p.suffix(c) == 0 {
+				return c
+			}
+			c = p.suffix(c)
+			if p.numStats(c) != 0 {
+				for s = p.stats(c); p.symbol(s) != fSymbol; s += ppmdStateSize {
+				}
+				if p.freq(s) < ppmdMaxFreq-9 {
+					p.setFreq(s, p.freq(s)+2)
+					p.setSummFreq(c, p.summFreq(c)+2)
+				}
+			} else {
+				s = oneState(c)
+				p.setFreq(s, p.freq(s)+b2u(p.freq(s) < 32))
+			}
+		}
+		if p.successor(s) != 0 {
+			break
+		}
+		p.setSuccessor(s, upBranch)
+		p.orderFall++
+	}
+
+	if p.successor(s) <= upBranch {
+		s2 := p.foundState
+		p.foundState = s
+		p.setSuccessor(s, p.createSuccessors(false, 0, c))
+		p.foundState = s2
+	}
+	if p.orderFall == 1 && c1 == p.maxContext {
+		p.setSuccessor(p.foundState, p.successor(s))
+		p.text--
+	}
+	return p.successor(s)
+}
+
+func (p *ppmd8) updateModel() {
+	fSuccessor := p.successor(p.foundState)
+	fFreq := p.freq(p.foundState)
+	fSymbol := p.symbol(p.foundState)
+
+	var s uint32
+	if fFreq < ppmdMaxFreq/4 && p.suffix(p.minContext) != 0 {
+		c := p.suffix(p.minContext)
+		if p.numStats(c) == 0 {
+			s = oneState(c)
+			if p.freq(s) < 32 {
+				p.setFreq(s, p.freq(s)+1)
+			}
+		} else {
+			s = p.stats(c)
+			if p.symbol(s) != fSymbol {
+				for s += ppmdStateSize; p.symbol(s) != fSymbol; s += ppmdStateSize {
+				}
+				if p.freq(s) >= p.freq(s-ppmdStateSize) {
+					p.swapStates(s, s-ppmdStateSize)
+					s -= ppmdStateSize
+				}
+			}
+			if p.freq(s) < ppmdMaxFreq-9 {
+				p.setFreq(s, p.freq(s)+2)
+				p.setSummFreq(c, p.summFreq(c)+2)
+			}
+		}
+	}
+
+	c := p.maxContext
+	if p.orderFall == 0 && fSuccessor != 0 {
+		cs := p.createSuccessors(true, s, p.minContext)
+		if cs == 0 {
+			p.setSuccessor(p.foundState, 0)
+			p.restoreModel(c)
+		} else {
+			p.setSuccessor(p.foundState, cs)
+			p.maxContext = cs
+		}
+		return
+	}
+
+	p.mem[p.text] = fSymbol
+	p.text++
+	successor := p.text
+	if p.text >= p.unitsStart {
+		p.restoreModel(c)
+		return
+	}
+
+	if fSuccessor == 0 {
+		cs := p.reduceOrder(s, p.minContext)
+		if cs == 0 {
+			p.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	} else if fSuccessor < p.unitsStart {
+		cs := p.createSuccessors(false, s, p.minContext)
+		if cs == 0 {
+			p.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	}
+
+	p.orderFall--
+	if p.orderFall == 0 {
+		successor = fSuccessor
+		if p.maxContext != p.minContext {
+			p.text--
+		}
+	}
+
+	ns := p.numStats(p.minContext)
+	s0 := p.summFreq(p.minContext) - ns - fFreq
+	flag := 0x08 * b2u(fSymbol >= 0x40)
+
+	for ; c != p.minContext; c = p.suffix(c) {
+		ns1 := p.numStats(c)
+		if ns1 != 0 {
+			if ns1&1 != 0 {
+				// the states fill their units, so they need one more
+				oldNU := (ns1 + 1) >> 1
+				i := p.u2i(oldNU)
+				if i != p.u2i(oldNU+1) {
+					ptr := p.allocUnits(i + 1)
+					if ptr == 0 {
+						p.restoreModel(c)
+						return
+					}
+					oldPtr := p.stats(c)
+					p.copyUnits(ptr, oldPtr, oldNU)
+					p.insertNode(oldPtr, i)
+					p.setStats(c, ptr)
+				}
+			}
+			p.setSummFreq(c, p.summFreq(c)+b2u(3*ns1+1 < ns))
+		} else {
+			s2 := p.allocUnits(0)
+			if s2 == 0 {
+				p.restoreModel(c)
+				return
+			}
+			p.setState(s2, p.state(oneState(c)))
+			p.setStats(c, s2)
+			if f := p.freq(s2); f < ppmdMaxFreq/4-1 {
+				p.setFreq(s2, f<<1)
+			} else {
+				p.setFreq(s2, ppmdMaxFreq-4)
+			}
+			p.setSummFreq(c, p.freq(s2)+p.initEsc+b2u(ns > 2))
+		}
+
+		cf := 2 * fFreq * (p.summFreq(c) + 6)
+		sf := s0 + p.summFreq(c)
+		if cf < 6*sf {
+			cf = 1 + b2u(cf > sf) + b2u(cf >= 4*sf)
+			p.setSummFreq(c, p.summFreq(c)+4)
+		} else {
+			cf = 4 + b2u(cf > 9*sf) + b2u(cf > 12*sf) + b2u(cf > 15*sf)
+			p.setSummFreq(c, p.summFreq(c)+cf)
+		}
+		s2 := p.stats(c) + (ns1+1)*ppmdStateSize
+		p.setSuccessor(s2, successor)
+		p.mem[s2] = fSymbol
+		p.setFreq(s2, cf)
+		p.setFlags(c, p.flags(c)|flag)
+		p.setNumStats(c, ns1+1)
+	}
+	p.maxContext, p.minContext = fSuccessor, fSuccessor
+}
+
+func (p *ppmd8) rescale() {
+	mc := p.minContext
+	stats := p.stats(mc)
+	s := p.foundState
+
+	// move the found state to the front
+	if s != stats {
+		tmp := p.state(s)
+		for ; s != stats; s -= ppmdStateSize {
+			p.setState(s, p.state(s-ppmdStateSize))
+		}
+		p.setState(s, tmp)
+	}
+	escFreq := p.summFreq(mc) - p.freq(s)
+	adder := b2u(p.orderFall != 0)
+	p.setFreq(s, (uint32(byte(p.freq(s)+4))+adder)>>1)
+	sumFreq := p.freq(s)
+
+	// halve the frequencies, keeping the states sorted by them
+	for i := p.numStats(mc); i > 0; i-- {
+		s += ppmdStateSize
+		escFreq -= p.freq(s)
+		p.setFreq(s, (p.freq(s)+adder)>>1)
+		sumFreq += p.freq(s)
+		if p.freq(s) > p.freq(s-ppmdStateSize) {
+			s1 := s
+			tmp := p.state(s1)
+			for {
+				p.setState(s1, p.state(s1-ppmdStateSize))
+				s1 -= ppmdStateSize
+				if s1 == stats || uint32(tmp[1]) <= p.freq(s1-ppmdStateSize) {
+					break
+				}
+			}
+			p.setState(s1, tmp)
+		}
+	}
+
+	// drop the states that are left with no frequency
+	if p.freq(s) == 0 {
+		numStats := p.numStats(mc)
+		var i uint32
+		for {
+			i++
+			s -= ppmdStateSize
+			if p.freq(s) != 0 {
+				break
+			}
+		}
+		escFreq += i
+		p.setNumStats(mc, numStats-i)
+		if numStats == i {
+			tmp := p.state(stats)
+			tmp[1] = byte((2*uint32(tmp[1]) + escFreq - 1) / escFreq)
+			if tmp[1] > ppmdMaxFreq/3 {
+				tmp[1] = ppmdMaxFreq / 3
+			}
+			p.insertNode(stats, p.u2i((numStats+2)>>1))
+			p.setFlags(mc, p.flags(mc)&0x10+0x08*b2u(tmp[0] >= 0x40))
+			p.foundState = oneState(mc)
+			p.setState(p.foundState, tmp)
+			return
+		}
+		n0 := (numStats + 2) >> 1
+		n1 := (numStats - i + 2) >> 1
+		if n0 != n1 {
+			p.setStats(mc, p.shrinkUnits(stats, n0, n1))
+		}
+		s = p.stats(mc)
+		flags := p.flags(mc)&^0x08 | 0x08*b2u(p.symbol(s) >= 0x40)
+		for j := p.numStats(mc); j > 0; j-- {
+			s += ppmdStateSize
+			flags |= 0x08 * b2u(p.symbol(s) >= 0x40)
+		}
+		p.setFlags(mc, flags)
+	}
+	p.setSummFreq(mc, sumFreq+escFreq-escFreq>>1)
+	p.setFlags(mc, p.flags(mc)|0x04)
+	p.foundState = p.stats(mc)
+}
+
+func (p *ppmd8) makeEscFreq(numMasked uint32) (*ppmdSee, uint32) {
+	mc := p.minContext
+	ns := p.numStats(mc)
+	if ns == 0xff {
+		return &p.dummySee, 1
+	}
+	see := &p.see[p.ns2Indx[ns+2]-3][b2u(p.summFreq(mc) > 11*(ns+1))+
+		2*b2u(2*ns < p.numStats(p.suffix(mc))+numMasked)+
+		p.flags(mc)]
+	r := uint32(see.summ >> see.shift)
+	see.summ -= uint16(r)
+	return see, r + b2u(r == 0)
+}
+
+// binSummOf returns the probability of the symbol of the
+// current context, which has only that one.
+func (p *ppmd8) binSummOf() *uint16 {
+	mc := p.minContext
+	return &p.binSumm[p.ns2Indx[p.freq(oneState(mc))-1]][uint32(p.ns2BSIndx[p.numStats(p.suffix(mc))])+
+		p.prevSuccess+p.flags(mc)+uint32((p.runLength>>26)&0x20)]
+}
+
+func (p *ppmd8) nextContext() {
+	c := p.successor(p.foundState)
+	if p.orderFall == 0 && c > p.text {
+		p.minContext, p.maxContext = c, c
+	} else {
+		p.updateModel()
+		p.minContext = p.maxContext
+	}
+}
+
+// update1 updates the model after a symbol other than the
+// first one of the current context.
+func (p *ppmd8) update1() {
+	s := p.foundState
+	p.setFreq(s, p.freq(s)+4)
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	if p.freq(s) > p.freq(s-ppmdStateSize) {
+		p.swapStates(s, s-ppmdStateSize)
+		s -= ppmdStateSize
+		p.foundState = s
+		if p.freq(s) > ppmdMaxFreq {
+			p.rescale()
+		}
+	}
+	p.nextContext()
+}
+
+// update1First updates the model after the first symbol of the current context.
+func (p *ppmd8) update1First() {
+	p.prevSuccess = b2u(2*p.freq(p.foundState) >= p.summFreq(p.minContext))
+	p.runLength += int32(p.prevSuccess)
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	p.setFreq(p.foundState, p.freq(p.foundState)+4)
+	if p.freq(p.foundState) > ppmdMaxFreq {
+		p.rescale()
+	}
+	p.nextContext()
+}
+
+// updateBin updates the model after the symbol of a context that has only it.
+func (p *ppmd8) updateBin() {
+	p.setFreq(p.foundState, p.freq(p.foundState)+b2u(p.freq(p.foundState) < 196))
+	p.prevSuccess = 1
+	p.runLength++
+	p.nextContext()
+}
+
+// update2 updates the model after a symbol that was escaped to.
+func (p *ppmd8) update2() {
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	p.setFreq(p.foundState, p.freq(p.foundState)+4)
+	if p.freq(p.foundState) > ppmdMaxFreq {
+		p.rescale()
+	}
+	p.runLength = p.initRL
+	p.updateModel()
+	p.minContext = p.maxContext
+}
+
+// the decoder
+
+func (p *ppmd8) readByte() uint32 {
+	b, err := p.in.ReadByte()
+	if err != nil && p.inErr == nil {
+		p.inErr = noEOF(err)
+	}
+	return uint32(b)
+}
+
+func (p *ppmd8) initDecoder() bool {
+	p.low = 0
+	p.rng = 0xFFFFFFFF
+	p.code = 0
+	for range 4 {
+		p.code = p.code<<8 | p.readByte()
+	}
+	return p.code < 0xFFFFFFFF && p.inErr == nil
+}
+
+func (p *ppmd8) threshold(total uint32) uint32 {
+	p.rng /= total
+	return p.code / p.rng
+}
+
+func (p *ppmd8) decode(start, size uint32) {
+	start *= p.rng
+	p.low += start
+	p.code -= start
+	p.rng *= size
+	for {
+		if p.low^(p.low+p.rng) >= ppmdTop {
+			if p.rng >= ppmdBot {
+				break
+			}
+			p.rng = -p.low & (ppmdBot - 1)
+		}
+		p.code = p.code<<8 | p.readByte()
+		p.rng <<= 8
+		p.low <<= 8
+	}
+}
+
+// decodeSymbol returns the next symbol, -1 at the end marker,
+// or -2 if the data is corrupt.
+func (p *ppmd8) decodeSymbol() int {
+	var charMask [256]bool // symbols that were escaped from
+	mc := p.minContext
+	if p.numStats(mc) != 0 {
+		s := p.stats(mc)
+		count := p.threshold(p.summFreq(mc))
+		hiCnt := p.freq(s)
+		if count < hiCnt {
+			p.decode(0, hiCnt)
+			p.foundState = s
+			sym := p.symbol(s)
+			p.update1First()
+			return int(sym)
+		}
+		p.prevSuccess = 0
+		for i := p.numStats(mc); i > 0; i-- {
+			s += ppmdStateSize
+			if hiCnt += p.freq(s); hiCnt > count {
+				p.decode(hiCnt-p.freq(s), p.freq(s))
+				p.foundState = s
+				sym := p.symbol(s)
+				p.update1()
+				return int(sym)
+			}
+		}
+		if count >= p.summFreq(mc) {
+			return -2
+		}
+		p.decode(hiCnt, p.summFreq(mc)-hiCnt)
+		charMask[p.symbol(s)] = true
+		for i := p.numStats(mc); i > 0; i-- {
+			s -= ppmdStateSize
+			charMask[p.symbol(s)] = true
+		}
+	} else {
+		prob := p.binSummOf()
+		p.rng >>= 14
+		if p.code/p.rng < uint32(*prob) {
+			p.decode(0, uint32(*prob))
+			*prob = *prob + 1<<ppmdIntBits - ppmdMean(*prob)
+			p.foundState = oneState(mc)
+			sym := p.symbol(p.foundState)
+			p.updateBin()
+			return int(sym)
+		}
+		p.decode(uint32(*prob), ppmdBinScale-uint32(*prob))
+		*prob -= ppmdMean(*prob)
+		p.initEsc = uint32(ppmdExpEscape[*prob>>10])
+		charMask[p.symbol(oneState(mc))] = true
+		p.prevSuccess = 0
+	}
+
+	var ps [256]uint32
+	for {
+		numMasked := p.numStats(p.minContext)
+		for {
+			p.orderFall++
+			if p.suffix(p.minContext) == 0 {
+				return -1
+			}
+			p.minContext = p.suffix(p.minContext)
+			if p.numStats(p.minContext) != numMasked {
+				break
+			}
+		}
+		mc := p.minContext
+
+		var hiCnt uint32
+		unmasked := ps[:0]
+		num := int(p.numStats(mc) - numMasked)
+		for s := p.stats(mc); len(unmasked) != num; s += ppmdStateSize {
+			if !charMask[p.symbol(s)] {
+				hiCnt += p.freq(s)
+				unmasked = append(unmasked, s)
+			}
+		}
+
+		see, freqSum := p.makeEscFreq(numMasked)
+		freqSum += hiCnt
+		count := p.threshold(freqSum)
+		if count < hiCnt {
+			hiCnt = 0
+			var s uint32
+			for _, s = range unmasked {
+				if hiCnt += p.freq(s); hiCnt > count {
+					break
+				}
+			}
+			p.decode(hiCnt-p.freq(s), p.freq(s))
+			see.update()
+			p.foundState = s
+			sym := p.symbol(s)
+			p.update2()
+			return int(sym)
+		}
+		if count >= freqSum {
+			return -2
+		}
+		p.decode(hiCnt, freqSum-hiCnt)
+		see.summ += uint16(freqSum)
+		for _, s := range unmasked {
+			charMask[p.symbol(s)] = true
+		}
+	}
+}
+
+// ppmdMean is how much a binary probability moves each time it is updated.
+func ppmdMean(prob uint16) uint16 {
+	return (prob + 1<<(ppmdPeriodBits-2)) >> ppmdPeriodBits
+}
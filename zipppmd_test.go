@@ -0,0 +1,333 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// ppmdEncoder compresses data with PPMd variant I as 7-Zip does for zip
+// entries, for making test entries. It is the encoder of the LZMA SDK
+// (Ppmd8Enc.c), which updates the model the same way as the decoder.
+type ppmdEncoder struct {
+	*ppmd8
+	out bytes.Buffer
+}
+
+// ppmdCompress compresses data with the model parameters of the zip PPMd
+// header, and the end marker after it if endMarker is true.
+func ppmdCompress(data []byte, order, memSizeMB, restore int, endMarker bool) []byte {
+	e := ppmdEncoder{ppmd8: newPPMd8(order, uint32(memSizeMB)<<20, restore)}
+	params := uint16(order-1) | uint16(memSizeMB-1)<<4 | uint16(restore)<<12
+	e.out.Write(binary.LittleEndian.AppendUint16(nil, params))
+	e.low, e.rng = 0, 0xFFFFFFFF
+	for _, b := range data {
+		e.encodeSymbol(int(b))
+	}
+	if endMarker {
+		e.encodeSymbol(-1)
+	}
+	for range 4 {
+		e.out.WriteByte(byte(e.low >> 24))
+		e.low <<= 8
+	}
+	return e.out.Bytes()
+}
+
+func (e *ppmdEncoder) normalize() {
+	for {
+		if e.low^(e.low+e.rng) >= ppmdTop {
+			if e.rng >= ppmdBot {
+				break
+			}
+			e.rng = -e.low & (ppmdBot - 1)
+		}
+		e.out.WriteByte(byte(e.low >> 24))
+		e.rng <<= 8
+		e.low <<= 8
+	}
+}
+
+func (e *ppmdEncoder) encode(start, size, total uint32) {
+	e.rng /= total
+	e.low += start * e.rng
+	e.rng *= size
+	e.normalize()
+}
+
+func (e *ppmdEncoder) encodeSymbol(symbol int) {
+	var charMask [256]bool
+	mc := e.minContext
+	if e.numStats(mc) != 0 {
+		s := e.stats(mc)
+		if int(e.symbol(s)) == symbol {
+			e.encode(0, e.freq(s), e.summFreq(mc))
+			e.foundState = s
+			e.update1First()
+			return
+		}
+		e.prevSuccess = 0
+		sum := e.freq(s)
+		for i := e.numStats(mc); i > 0; i-- {
+			s += ppmdStateSize
+			if int(e.symbol(s)) == symbol {
+				e.encode(sum, e.freq(s), e.summFreq(mc))
+				e.foundState = s
+				e.update1()
+				return
+			}
+			sum += e.freq(s)
+		}
+		charMask[e.symbol(s)] = true
+		for i := e.numStats(mc); i > 0; i-- {
+			s -= ppmdStateSize
+			charMask[e.symbol(s)] = true
+		}
+		e.encode(sum, e.summFreq(mc)-sum, e.summFreq(mc))
+	} else {
+		prob := e.binSummOf()
+		s := oneState(mc)
+		if int(e.symbol(s)) == symbol {
+			e.rng = (e.rng >> 14) * uint32(*prob)
+			e.normalize()
+			*prob = *prob + 1<<ppmdIntBits - ppmdMean(*prob)
+			e.foundState = s
+			e.updateBin()
+			return
+		}
+		e.rng >>= 14
+		e.low += uint32(*prob) * e.rng
+		e.rng *= ppmdBinScale - uint32(*prob)
+		e.normalize()
+		*prob -= ppmdMean(*prob)
+		e.initEsc = uint32(ppmdExpEscape[*prob>>10])
+		charMask[e.symbol(s)] = true
+		e.prevSuccess = 0
+	}
+
+	for {
+		numMasked := e.numStats(e.minContext)
+		for {
+			e.orderFall++
+			if e.suffix(e.minContext) == 0 {
+				return // the end marker
+			}
+			e.minContext = e.suffix(e.minContext)
+			if e.numStats(e.minContext) != numMasked {
+				break
+			}
+		}
+		mc := e.minContext
+
+		see, escFreq := e.makeEscFreq(numMasked)
+		var sum, low uint32
+		found := uint32(0)
+		s := e.stats(mc)
+		for i := e.numStats(mc) + 1; i > 0; i, s = i-1, s+ppmdStateSize {
+			if charMask[e.symbol(s)] {
+				continue
+			}
+			if int(e.symbol(s)) == symbol {
+				found, low = s, sum
+			} else if found == 0 {
+				charMask[e.symbol(s)] = true
+			}
+			sum += e.freq(s)
+		}
+		if found != 0 {
+			e.encode(low, e.freq(found), sum+escFreq)
+			see.update()
+			e.foundState = found
+			e.update2()
+			return
+		}
+		e.encode(sum, escFreq, sum+escFreq)
+		see.summ += uint16(sum + escFreq)
+	}
+}
+
+// ppmdWriter compresses what is written to it with ppmdCompress when
+// it is closed, with the default parameters of 7-Zip.
+type ppmdWriter struct {
+	out io.Writer
+	buf bytes.Buffer
+}
+
+func (w *ppmdWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *ppmdWriter) Close() error {
+	_, err := w.out.Write(ppmdCompress(w.buf.Bytes(), 6, 16, ppmdRestoreRestart, false))
+	return err
+}
+
+// ppmdTestData returns size bytes of text that is compressible,
+// but not so much that the model doesn't grow.
+func ppmdTestData(size int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over a lazy dog while PPMd " +
+		"predicts each byte from the ones before it in contexts of up to sixteen bytes")
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rnd.Intn(len(words))])
+		switch rnd.Intn(10) {
+		case 0:
+			buf.WriteString(".\n")
+		case 1:
+			buf.WriteByte(byte(rnd.Intn(256)))
+		default:
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:size]
+}
+
+// ppmdZip returns a zip archive with an entry for each of entries,
+// which are compressed with PPMd already.
+func ppmdZip(t *testing.T, entries map[string][2][]byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, entry := range entries {
+		plain, compressed := entry[0], entry[1]
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               name,
+			Method:             ZipMethodPPMd,
+			CRC32:              crc32.ChecksumIEEE(plain),
+			CompressedSize64:   uint64(len(compressed)),
+			UncompressedSize64: uint64(len(plain)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(compressed)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipPPMd(t *testing.T) {
+	t.Run("fixture", func(t *testing.T) {
+		// made with ppmdCompress, with order 6 and 16 MB, like 7-Zip's default
+		data, err := os.ReadFile("testdata/ppmd.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, errs, err := extractEntries(t, Zip{}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if want := strings.Repeat("PPMd entries in zip files are compressed with variant I.\n", 40); contents["hello.txt"] != want {
+			t.Errorf("expected %q, got %q", want, contents["hello.txt"])
+		}
+		if want := string(ppmdTestData(20000)); contents["words.txt"] != want {
+			t.Errorf("words.txt: contents differ")
+		}
+		if contents["empty.txt"] != "" {
+			t.Errorf("expected empty file, got %q", contents["empty.txt"])
+		}
+	})
+
+	t.Run("memory runs out", func(t *testing.T) {
+		// 1 MB is used up several times over, so the model has to be restored
+		plain := ppmdTestData(1 << 20)
+		for _, tc := range []struct {
+			name           string
+			order, restore int
+		}{
+			{"restart", 8, ppmdRestoreRestart},
+			{"cut off", 8, ppmdRestoreCutOff},
+			{"max order", ppmdMaxOrder, ppmdRestoreCutOff},
+			{"min order", ppmdMinOrder, ppmdRestoreRestart},
+		} {
+			compressed := ppmdCompress(plain, tc.order, 1, tc.restore, false)
+			if len(compressed) >= len(plain)/2 {
+				t.Errorf("%s: expected data to compress, got %d bytes", tc.name, len(compressed))
+			}
+			contents, errs, err := extractEntries(t, Zip{}, ppmdZip(t, map[string][2][]byte{"a.txt": {plain, compressed}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contents["a.txt"] != string(plain) {
+				t.Errorf("%s: contents differ (errors: %v)", tc.name, errs)
+			}
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		plain := ppmdTestData(5000)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := createEncrypted(zw, &zip.FileHeader{Name: "a.txt", Method: ZipMethodPPMd}, "ppmd",
+			func(out io.Writer) (io.WriteCloser, error) {
+				return &ppmdWriter{out: out}, nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zw.Close()
+
+		contents, errs, err := extractEntries(t, Zip{Password: "ppmd"}, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents["a.txt"] != string(plain) {
+			t.Errorf("contents differ (errors: %v)", errs)
+		}
+	})
+
+	t.Run("end marker", func(t *testing.T) {
+		// without the size, as the zip package reads entries
+		plain := ppmdTestData(5000)
+		r := zipDecompressors[ZipMethodPPMd](bytes.NewReader(ppmdCompress(plain, 6, 16, 0, true)))
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("contents differ")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		plain := ppmdTestData(5000)
+		compressed := ppmdCompress(plain, 6, 16, 0, false)
+		corrupt := bytes.Clone(compressed)
+		corrupt[len(corrupt)/2] ^= 0xff
+		for _, tc := range []struct {
+			name       string
+			compressed []byte
+			expect     error
+		}{
+			{"truncated", compressed[:len(compressed)/2], io.ErrUnexpectedEOF},
+			{"corrupt", corrupt, nil},
+			{"freeze", ppmdCompress(plain, 6, 16, ppmdRestoreFreeze, false), nil},
+			{"order 1", append([]byte{0, 0}, compressed[2:]...), nil},
+		} {
+			_, errs, err := extractEntries(t, Zip{}, ppmdZip(t, map[string][2][]byte{"a.txt": {plain, tc.compressed}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if errs["a.txt"] == nil {
+				t.Errorf("%s: expected error", tc.name)
+			} else if tc.expect != nil && !errors.Is(errs["a.txt"], tc.expect) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, errs["a.txt"])
+			}
+		}
+	})
+}